@@ -53,17 +53,69 @@ func printUsage() {
 	fmt.Println("  set <region>         Process specific region")
 	fmt.Println("  show [<region>]      Show resources only (no tagging)")
 	fmt.Println("  activate             Activate Cost Allocation Tags")
-	fmt.Println("  ec2                  Process only EC2 instances + volumes + snapshots")
+	fmt.Println("  ec2                  Process only EC2 instances + volumes + snapshots + AMIs")
 	fmt.Println("  ebs                  Process only EBS volumes + snapshots")
 	fmt.Println("  volumes              Process only EBS volumes")
 	fmt.Println("  snapshots            Process only EBS snapshots")
 	fmt.Println("  fsx                  Process only FSx resources")
 	fmt.Println("  efs                  Process only EFS resources")
+	fmt.Println("  audit                Report tag compliance without changing anything")
+	fmt.Println("  cleanup              Remove tool-managed tags chosen with --keys or --from-journal")
+	fmt.Println("  apply-plan <file>    Apply a plan saved with --plan-out")
+	fmt.Println("  undo <journal>       Revert the tags written or removed by an apply run")
 	fmt.Println()
 	fmt.Println("Options:")
 	fmt.Println("  --apply              Apply changes (default: dry-run)")
 	fmt.Println("  --tag-storage        Also tag EFS + FSx resources")
-	fmt.Println("  --fix-orphans        Only fix orphaned AMI snapshots")
+	fmt.Println("  --fix-orphans        Only name untagged AMI snapshots after their AMI")
+	fmt.Println("  --output <format>    Output format: text, json, ndjson, csv (default: text)")
+	fmt.Println("  --plan-out <file>    Save the dry-run plan for apply-plan")
+	fmt.Println("  --journal <file>     Where --apply records written and removed tags (default: tagging-journal-<time>.ndjson)")
+	fmt.Println("  --propagate-keys <k1,k2>")
+	fmt.Println("                       Copy these instance tags to volumes and snapshots")
+	fmt.Println("  --propagate-conflict keep|overwrite|error")
+	fmt.Println("                       What to do when a volume/snapshot has a different value (default: keep)")
+	fmt.Println("  --policy <file>      YAML/JSON tag policy: required keys, allowed values, defaults")
+	fmt.Println("  --min-compliance <%> Fail audit below this compliance percentage (default: 100)")
+	fmt.Println("  --machine-key-value empty|id|name|<template>")
+	fmt.Println("                       Value written for machine keys; templates use {id}, {name}, {key}, {region}")
+	fmt.Println("  --migrate-machine-key")
+	fmt.Println("                       Rewrite existing empty machine keys with --machine-key-value")
+	fmt.Println("  --migrate-stale-keys Replace machine keys left by an earlier instance Name; needs")
+	fmt.Println("                       --from-journal or --migrate-unjournaled-keys")
+	fmt.Println("  --migrate-unjournaled-keys")
+	fmt.Println("                       Also migrate possibly stale keys no journal shows the tool wrote")
+	fmt.Println("  --concurrency <n>    Process up to n regions in parallel (default: 1)")
+	fmt.Println("  --max-attempts <n>   Attempts per AWS call, retries included (default: 5)")
+	fmt.Println("  --failed-out <file>  Save the tags --apply failed to write as a plan for apply-plan")
+	fmt.Println("  --ids <id1,id2>      Only process these resources")
+	fmt.Println("  --name <glob>        Only process resources whose Name matches ('*' and '?' wildcards)")
+	fmt.Println("  --tag-filter <Key=Value,Key>")
+	fmt.Println("                       Only process resources with all these tags")
+	fmt.Println("  --exclude-tag <Key=Value,Key>")
+	fmt.Println("                       Skip resources with any of these tags")
+	fmt.Println("  --exclude-file <file>")
+	fmt.Println("                       IDs/ARNs never to change, like resources tagged coaws:skip=true")
+	fmt.Println("  --accounts org|<id|role-arn,...>")
+	fmt.Println("                       Run in each member account through AssumeRole (org: every active account of the organization)")
+	fmt.Println("  --role-name <name>   Role assumed in accounts given by ID (default: OrganizationAccountAccessRole)")
+	fmt.Println("  --include-keys <g1,g2>, --exclude-keys <g1,g2>")
+	fmt.Println("                       Tag key globs activate works on")
+	fmt.Println("  --reconcile          With activate, also deactivate keys not selected or that no resource holds;")
+	fmt.Println("                       needs --regions auto|all and the organization's accounts in --accounts")
+	fmt.Println("  --regions auto|all|<r1,r2>")
+	fmt.Println("                       Discover enabled (auto) or all regions, or list them")
+	fmt.Println("  --include-regions <g1,g2>, --exclude-regions <g1,g2>")
+	fmt.Println("                       Region globs to keep or leave out")
+	fmt.Println("  --refresh-regions    Discover regions again instead of using the cache")
+	fmt.Println("  --profile <name>     Shared config profile to use")
+	fmt.Println("  --role-arn <arn>     Role to assume for the whole run")
+	fmt.Println("  --mfa-serial <arn>   MFA device for --role-arn; the code is read from stdin")
+	fmt.Println("  --endpoint-url <url> Endpoint for every service, such as LocalStack")
+	fmt.Println("  --keys <p1,p2>       Key patterns removed by cleanup ('*' and '?' wildcards)")
+	fmt.Println("  --from-journal <f1,f2>")
+	fmt.Println("                       Journals whose added keys cleanup removes (Name is kept), or")
+	fmt.Println("                       that show which stale machine keys the tool wrote")
 	fmt.Println()
 	fmt.Println("Examples:")
 	fmt.Println("  cost-optimization start")
@@ -74,6 +126,17 @@ func printUsage() {
 	fmt.Println("  cost-optimization tagging activate --apply")
 	fmt.Println("  cost-optimization tagging ec2 --apply")
 	fmt.Println("  cost-optimization tagging all --apply --tag-storage")
+	fmt.Println("  cost-optimization tagging all --apply --tag-storage --concurrency 8")
+	fmt.Println("  cost-optimization tagging all --output json > report.json")
+	fmt.Println("  cost-optimization tagging all --plan-out plan.json")
+	fmt.Println("  cost-optimization tagging apply-plan plan.json")
+	fmt.Println("  cost-optimization tagging all --apply --max-attempts 10 --failed-out failed.json")
+	fmt.Println("  cost-optimization tagging all --apply --machine-key-value id --migrate-machine-key")
+	fmt.Println("  cost-optimization tagging ec2 --apply --migrate-stale-keys --from-journal tagging-journal-20240101-120000.ndjson")
+	fmt.Println("  cost-optimization tagging all --name 'web-*' --tag-filter Env=prod --exclude-tag Backup=skip")
+	fmt.Println("  cost-optimization tagging audit --policy policy.yaml --min-compliance 95")
+	fmt.Println("  cost-optimization tagging cleanup --keys 'old-*,tmp-?' --apply")
+	fmt.Println("  cost-optimization tagging undo tagging-journal-20240101-120000.ndjson --apply")
 }
 
 func runShell() int {
@@ -140,31 +203,53 @@ func runTagging(args []string) int {
 			opts.Region = flags[0]
 			flags = flags[1:]
 		}
+	case "audit":
+		opts.Mode = tagging.ModeAudit
+	case "cleanup":
+		opts.Mode = tagging.ModeCleanup
+	case "apply-plan":
+		opts.Mode = tagging.ModeApplyPlan
+		if len(flags) > 0 && !strings.HasPrefix(flags[0], "--") {
+			opts.PlanFile = flags[0]
+			flags = flags[1:]
+		} else {
+			fmt.Println("Error: 'apply-plan' requires a plan file")
+			fmt.Println("Usage: cost-optimization tagging apply-plan <plan.json>")
+			return 1
+		}
+	case "undo":
+		opts.Mode = tagging.ModeUndo
+		if len(flags) > 0 && !strings.HasPrefix(flags[0], "--") {
+			opts.UndoFile = flags[0]
+			flags = flags[1:]
+		} else {
+			fmt.Println("Error: 'undo' requires a journal file")
+			fmt.Println("Usage: cost-optimization tagging undo <journal> [--apply]")
+			return 1
+		}
 	default:
 		fmt.Println("Unknown tagging mode:", mode)
-		fmt.Println("Available modes: all, set, show, activate, ec2, ebs, volumes, snapshots, fsx, efs")
+		fmt.Println("Available modes: all, set, show, activate, ec2, ebs, volumes, snapshots, fsx, efs, audit, cleanup, apply-plan, undo")
 		return 1
 	}
 
 	// Parse flags
-	for _, f := range flags {
-		switch f {
-		case "--apply":
-			opts.Apply = true
-		case "--tag-storage":
-			opts.TagStorage = true
-		case "--fix-orphans":
-			opts.FixOrphans = true
-		default:
-			fmt.Println("Unknown flag:", f)
-			fmt.Println("Available flags: --apply, --tag-storage, --fix-orphans")
-			return 1
-		}
+	if err := tagging.ParseFlags(&opts, flags); err != nil {
+		fmt.Println("Error:", err)
+		fmt.Println("Available flags:", tagging.FlagUsage)
+		return 1
 	}
 
 	// Execute
-	eng := tagging.NewEngine(opts)
-	if err := eng.Run(context.Background()); err != nil {
+	eng := tagging.NewEngine(opts, nil)
+	report, err := eng.Run(context.Background())
+	if report != nil {
+		if rerr := tagging.Render(os.Stdout, report, opts.Output); rerr != nil {
+			fmt.Fprintln(os.Stderr, "Error:", rerr)
+			return 1
+		}
+	}
+	if err != nil {
 		fmt.Fprintln(os.Stderr, "Error:", err)
 		return 1
 	}
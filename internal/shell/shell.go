@@ -206,16 +206,31 @@ func printBanner() {
 
 func printHelp() {
 	fmt.Println("Available commands:")
-	fmt.Println("  tagging all [--apply] [--tag-storage] [--fix-orphans]")
+	fmt.Println("  tagging all [--apply] [--tag-storage] [--fix-orphans] [--output text|json|ndjson|csv] [--concurrency <n>]")
+	fmt.Println("  tagging all --policy <policy.yaml> [--apply]")
+	fmt.Println("  tagging all --machine-key-value id|name|<template> [--migrate-machine-key] [--apply]")
+	fmt.Println("  tagging all --migrate-stale-keys --from-journal <journal> | --migrate-unjournaled-keys [--apply]")
+	fmt.Println("  tagging all [--ids <id1,id2>] [--name <glob>] [--tag-filter Key=Value] [--exclude-tag Key=Value]")
+	fmt.Println("  tagging all --exclude-file <file> [--apply]")
+	fmt.Println("  tagging all --accounts org|<id|role-arn,...> [--role-name <name>] [--apply]")
+	fmt.Println("  tagging all --regions auto|all|<r1,r2> [--include-regions <globs>] [--exclude-regions <globs>] [--refresh-regions]")
+	fmt.Println("  tagging all [--profile <name>] [--role-arn <arn> [--mfa-serial <arn>]] [--endpoint-url <url>]")
 	fmt.Println("  tagging set <region> [--apply] [--tag-storage]")
 	fmt.Println("  tagging show [<region>]")
-	fmt.Println("  tagging activate [--apply]")
-	fmt.Println("  tagging ec2 [--apply]")
+	fmt.Println("  tagging activate [--include-keys <globs>] [--exclude-keys <globs>] [--apply]")
+	fmt.Println("  tagging activate --include-keys <globs> --reconcile --regions auto|all [--accounts org] [--apply]")
+	fmt.Println("  tagging ec2 [--apply] [--propagate-keys CostCenter,Owner] [--propagate-conflict keep|overwrite|error]")
 	fmt.Println("  tagging ebs [--apply]")
 	fmt.Println("  tagging volumes [--apply]")
 	fmt.Println("  tagging snapshots [--apply]")
 	fmt.Println("  tagging fsx [--apply]")
 	fmt.Println("  tagging efs [--apply]")
+	fmt.Println("  tagging audit [--policy <policy.yaml>] [--min-compliance <percent>]")
+	fmt.Println("  tagging cleanup --keys <pattern1,pattern2> | --from-journal <journal> [--apply]")
+	fmt.Println("  tagging all --plan-out <plan.json>")
+	fmt.Println("  tagging all --apply [--max-attempts <n>] [--failed-out <failed.json>]")
+	fmt.Println("  tagging apply-plan <plan.json>")
+	fmt.Println("  tagging undo <journal> [--apply]")
 	fmt.Println("  !<command>       - Execute shell command (e.g., !clear, !ls)")
 	fmt.Println("  help")
 	fmt.Println("  exit | quit")
@@ -242,7 +257,7 @@ func handleCommand(line string) error {
 
 func handleTagging(args []string) error {
 	if len(args) == 0 {
-		fmt.Println("Usage: tagging <all|set|show|activate|ec2|ebs|volumes|snapshots|fsx|efs> [options]")
+		fmt.Println("Usage: tagging <all|set|show|activate|ec2|ebs|volumes|snapshots|fsx|efs|audit|cleanup|apply-plan|undo> [options]")
 		return nil
 	}
 
@@ -288,30 +303,50 @@ func handleTagging(args []string) error {
 		opts.Mode = tagging.ModeEFS
 		opts.TagStorage = true
 		opts.TagEFS = true
+	case "audit":
+		opts.Mode = tagging.ModeAudit
+	case "cleanup":
+		opts.Mode = tagging.ModeCleanup
+	case "apply-plan":
+		opts.Mode = tagging.ModeApplyPlan
+		if len(flags) > 0 && !strings.HasPrefix(flags[0], "--") {
+			opts.PlanFile = flags[0]
+			flags = flags[1:]
+		} else {
+			fmt.Println("Error: 'apply-plan' requires a plan file. Usage: tagging apply-plan <plan.json>")
+			return nil
+		}
+	case "undo":
+		opts.Mode = tagging.ModeUndo
+		if len(flags) > 0 && !strings.HasPrefix(flags[0], "--") {
+			opts.UndoFile = flags[0]
+			flags = flags[1:]
+		} else {
+			fmt.Println("Error: 'undo' requires a journal file. Usage: tagging undo <journal> [--apply]")
+			return nil
+		}
 	default:
 		fmt.Println("Unknown tagging mode:", sub)
-		fmt.Println("Available modes: all, set, show, activate, ec2, ebs, volumes, snapshots, fsx, efs")
+		fmt.Println("Available modes: all, set, show, activate, ec2, ebs, volumes, snapshots, fsx, efs, audit, cleanup, apply-plan, undo")
 		return nil
 	}
 
 	// Parse flags
-	for _, f := range flags {
-		switch f {
-		case "--apply":
-			opts.Apply = true
-		case "--tag-storage":
-			opts.TagStorage = true
-		case "--fix-orphans":
-			opts.FixOrphans = true
-		default:
-			fmt.Println("Unknown flag:", f)
-			fmt.Println("Available flags: --apply, --tag-storage, --fix-orphans")
-		}
+	if err := tagging.ParseFlags(&opts, flags); err != nil {
+		fmt.Println("Error:", err)
+		fmt.Println("Available flags:", tagging.FlagUsage)
+		return nil
 	}
 
 	// Execute
-	eng := tagging.NewEngine(opts)
-	return eng.Run(context.Background())
+	eng := tagging.NewEngine(opts, nil)
+	report, err := eng.Run(context.Background())
+	if report != nil {
+		if rerr := tagging.Render(os.Stdout, report, opts.Output); rerr != nil {
+			return rerr
+		}
+	}
+	return err
 }
 
 // executeShellCommand runs a shell command and displays the output
@@ -0,0 +1,201 @@
+package tagging
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
+	"github.com/aws/aws-sdk-go-v2/service/organizations"
+	orgtypes "github.com/aws/aws-sdk-go-v2/service/organizations/types"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+)
+
+// AccountsOrganization is the --accounts value that runs in every active
+// account of the organization, listed with ListAccounts
+const AccountsOrganization = "org"
+
+// DefaultAccountRole is the role assumed into member accounts given by ID,
+// the one AWS Organizations creates in the accounts it creates
+const DefaultAccountRole = "OrganizationAccountAccessRole"
+
+// roleSessionName identifies the tool's sessions in member accounts' CloudTrail
+const roleSessionName = "coaws-tagging"
+
+var (
+	accountIDPattern = regexp.MustCompile(`^\d{12}$`)
+	roleARNPattern   = regexp.MustCompile(`^arn:aws[a-z-]*:iam::(\d{12}):role/.+$`)
+)
+
+// Account is a member account the engine runs in, and the role assumed into it
+type Account struct {
+	ID      string
+	RoleARN string
+}
+
+// ParseAccount resolves an --accounts item: a role ARN, or an account ID
+// whose role is roleName
+func ParseAccount(ref, roleName string) (Account, error) {
+	if m := roleARNPattern.FindStringSubmatch(ref); m != nil {
+		return Account{ID: m[1], RoleARN: ref}, nil
+	}
+	if !accountIDPattern.MatchString(ref) {
+		return Account{}, fmt.Errorf("invalid account %q (expected a 12-digit account ID or an IAM role ARN)", ref)
+	}
+	if roleName == "" {
+		roleName = DefaultAccountRole
+	}
+	return Account{ID: ref, RoleARN: fmt.Sprintf("arn:aws:iam::%s:role/%s", ref, strings.TrimPrefix(roleName, "/"))}, nil
+}
+
+// AccountClientFactory is a ClientFactory that can also build the clients
+// of a member account, by assuming a role into it
+type AccountClientFactory interface {
+	ClientFactory
+	ForAccount(ctx context.Context, account Account) (ClientFactory, error)
+}
+
+// ForAccount returns clients using credentials of account's role, assumed
+// with the factory's own credentials and refreshed before they expire
+func (f *awsClientFactory) ForAccount(ctx context.Context, account Account) (ClientFactory, error) {
+	provider := stscreds.NewAssumeRoleProvider(sts.NewFromConfig(f.cfg), account.RoleARN, func(o *stscreds.AssumeRoleOptions) {
+		o.RoleSessionName = roleSessionName
+	})
+	cfg := f.cfg.Copy()
+	cfg.Credentials = aws.NewCredentialsCache(provider)
+
+	// Fail once here rather than on the first call of every region
+	if _, err := cfg.Credentials.Retrieve(ctx); err != nil {
+		return nil, fmt.Errorf("cannot assume %s: %w", account.RoleARN, err)
+	}
+	return NewClientFactory(cfg, f.maxAttempts), nil
+}
+
+// useAccount points the engine at an account's clients, creating them on
+// first use. The empty account is the one the engine was started with.
+func (e *Engine) useAccount(ctx context.Context, accountID string) error {
+	if accountID == e.account {
+		return nil
+	}
+	clients, ok := e.accountClients[accountID]
+	if !ok {
+		factory, isAccountFactory := e.rootClients.(AccountClientFactory)
+		if !isAccountFactory {
+			return fmt.Errorf("account %s: clients cannot assume roles into other accounts", accountID)
+		}
+		account, err := e.accountRef(accountID)
+		if err != nil {
+			return err
+		}
+		if clients, err = factory.ForAccount(ctx, account); err != nil {
+			return fmt.Errorf("account %s: %w", accountID, err)
+		}
+		e.accountClients[accountID] = clients
+	}
+	e.account = accountID
+	e.clients = clients
+	e.snapshots = newSnapshotIndexes()
+	return nil
+}
+
+// accountRef returns how to reach an account ID: as given in Accounts, or
+// through AccountRole for accounts recorded in a plan or journal
+func (e *Engine) accountRef(accountID string) (Account, error) {
+	for _, ref := range e.opts.Accounts {
+		if account, err := ParseAccount(ref, e.opts.AccountRole); err == nil && account.ID == accountID {
+			return account, nil
+		}
+	}
+	return ParseAccount(accountID, e.opts.AccountRole)
+}
+
+// memberAccounts resolves Accounts, in order and without duplicates.
+// AccountsOrganization stands for the active accounts of the organization,
+// but the one the tool runs with, which has no role to assume.
+func (e *Engine) memberAccounts(ctx context.Context) ([]Account, error) {
+	var accounts []Account
+	seen := make(map[string]bool)
+	add := func(account Account) {
+		if !seen[account.ID] {
+			seen[account.ID] = true
+			accounts = append(accounts, account)
+		}
+	}
+
+	for _, ref := range e.opts.Accounts {
+		if ref != AccountsOrganization {
+			account, err := ParseAccount(ref, e.opts.AccountRole)
+			if err != nil {
+				return nil, err
+			}
+			add(account)
+			continue
+		}
+
+		members, err := e.organizationAccounts(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, account := range members {
+			add(account)
+		}
+	}
+	return accounts, nil
+}
+
+// organizationAccounts lists the active accounts of the organization, but
+// the one the tool runs with
+func (e *Engine) organizationAccounts(ctx context.Context) ([]Account, error) {
+	caller := ""
+	if identity, ok := e.rootClients.(IdentityClientFactory); ok {
+		caller, _ = identity.CallerAccount(ctx)
+	}
+	var accounts []Account
+	pages := organizations.NewListAccountsPaginator(e.rootClients.Organizations(), &organizations.ListAccountsInput{})
+	for pages.HasMorePages() {
+		page, err := pages.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("cannot list the accounts of the organization: %w", err)
+		}
+		for _, member := range page.Accounts {
+			id := aws.ToString(member.Id)
+			if member.Status != orgtypes.AccountStatusActive || id == caller {
+				continue
+			}
+			account, err := ParseAccount(id, e.opts.AccountRole)
+			if err != nil {
+				return nil, err
+			}
+			accounts = append(accounts, account)
+		}
+	}
+	return accounts, nil
+}
+
+// runAccounts runs the mode in each account of Accounts in turn. An account
+// that cannot be assumed is warned about and skipped.
+func (e *Engine) runAccounts(ctx context.Context, regions []string) error {
+	accounts, err := e.memberAccounts(ctx)
+	if err != nil {
+		return err
+	}
+	for _, account := range accounts {
+		if uerr := e.useAccount(ctx, account.ID); uerr != nil {
+			e.report.Warnings = append(e.report.Warnings, uerr.Error())
+			continue
+		}
+		e.report.Accounts = append(e.report.Accounts, account.ID)
+
+		err = e.runMode(ctx, regions)
+		e.flushTags(ctx, "")
+		if err != nil {
+			break
+		}
+	}
+	if uerr := e.useAccount(ctx, ""); uerr != nil && err == nil {
+		err = uerr
+	}
+	return err
+}
@@ -0,0 +1,215 @@
+package tagging
+
+import (
+	"context"
+	"fmt"
+)
+
+// Problems reported by audit mode
+const (
+	IssueMissing = "missing"
+	IssueEmpty   = "empty"
+	IssueInvalid = "invalid"
+)
+
+// ComplianceIssue is a tag problem found on a resource by audit mode
+type ComplianceIssue struct {
+	Key     string `json:"key"`
+	Problem string `json:"problem"`
+	Detail  string `json:"detail,omitempty"`
+}
+
+// TypeCompliance counts the compliant resources of one type in a region.
+// Missing, Empty and Invalid count resources with at least one such issue,
+// Unreadable those whose tags could not be read; none of them is compliant.
+type TypeCompliance struct {
+	Type       string `json:"type"`
+	Resources  int    `json:"resources"`
+	Compliant  int    `json:"compliant"`
+	Missing    int    `json:"missing"`
+	Empty      int    `json:"empty"`
+	Invalid    int    `json:"invalid"`
+	Unreadable int    `json:"unreadable"`
+}
+
+// RegionCompliance is the audit result of a single region
+type RegionCompliance struct {
+	Account string           `json:"account,omitempty"`
+	Region  string           `json:"region"`
+	Types   []TypeCompliance `json:"types"`
+}
+
+// ComplianceSummary is the overall result of audit mode. Incomplete counts
+// the warnings of the audited accounts and regions, such as resources that
+// could not be listed or accounts that could not be assumed.
+type ComplianceSummary struct {
+	Resources  int                `json:"resources"`
+	Compliant  int                `json:"compliant"`
+	Percent    float64            `json:"percent"`
+	Threshold  float64            `json:"threshold"`
+	Incomplete int                `json:"incomplete"`
+	Regions    []RegionCompliance `json:"regions"`
+}
+
+// Passed reports whether compliance reached the threshold. An incomplete
+// audit never passes: resources it could not see may not be compliant.
+func (s *ComplianceSummary) Passed() bool {
+	return s.Incomplete == 0 && s.Percent >= s.Threshold
+}
+
+// runAudit walks the same resources as a tagging run, including EFS and
+// FSx, and checks their tags without planning any change. The verdict is
+// left to summarizeAudit, once every account has been audited.
+func (e *Engine) runAudit(ctx context.Context, regions []string) error {
+	e.opts.Apply = false
+	e.opts.TagStorage = true
+	e.forEachRegion(ctx, regions, e.regionFamilies()...)
+
+	for _, rr := range e.report.Regions {
+		if rr.Account == e.account {
+			for _, res := range rr.Resources {
+				e.auditResource(res)
+			}
+		}
+	}
+	return nil
+}
+
+// summarizeAudit sums up the compliance of the whole report. It fails when
+// compliance is below Options.MinCompliance, when some resources could not
+// be listed or some accounts assumed, or when no account was audited.
+func (e *Engine) summarizeAudit() error {
+	summary := &ComplianceSummary{
+		Threshold:  e.opts.MinCompliance,
+		Incomplete: len(e.report.Warnings),
+		Regions:    []RegionCompliance{},
+	}
+	for _, rr := range e.report.Regions {
+		rc := rr.Compliance()
+		for _, tc := range rc.Types {
+			summary.Resources += tc.Resources
+			summary.Compliant += tc.Compliant
+		}
+		summary.Incomplete += len(rr.Warnings)
+		summary.Regions = append(summary.Regions, rc)
+	}
+
+	summary.Percent = 100
+	if summary.Resources > 0 {
+		summary.Percent = 100 * float64(summary.Compliant) / float64(summary.Resources)
+	}
+	e.report.Compliance = summary
+
+	if len(e.opts.Accounts) > 0 && len(e.report.Accounts) == 0 {
+		return fmt.Errorf("tag compliance cannot be verified: no account could be audited")
+	}
+	if summary.Incomplete > 0 {
+		return fmt.Errorf("tag compliance cannot be verified: %d listing failures, see the warnings", summary.Incomplete)
+	}
+	if !summary.Passed() {
+		return fmt.Errorf("tag compliance %.1f%% is below the required %.1f%%", summary.Percent, summary.Threshold)
+	}
+	return nil
+}
+
+// requiredKeys returns the keys every resource of res's type must have:
+// Name, the machine key when known, and the policy's required keys
+func (e *Engine) requiredKeys(res *ResourceResult) []string {
+	keys := []string{"Name"}
+	if res.MachineKey != "" && res.MachineKey != "Name" {
+		keys = append(keys, res.MachineKey)
+	}
+	if e.policy != nil {
+		for _, rule := range e.policy.Tags {
+			if rule.Required && rule.AppliesTo(res.Type) && !contains(keys, rule.Key) {
+				keys = append(keys, rule.Key)
+			}
+		}
+	}
+	return keys
+}
+
+// auditResource records the compliance issues of a resource. Resources
+// whose tags could not be read are counted as unreadable instead.
+func (e *Engine) auditResource(res *ResourceResult) {
+	if res.Error != "" {
+		return
+	}
+	for _, key := range e.requiredKeys(res) {
+		value, ok := res.ExistingTags[key]
+		switch {
+		case !ok:
+			res.Issues = append(res.Issues, ComplianceIssue{Key: key, Problem: IssueMissing})
+		case value == "" && (key != res.MachineKey || e.opts.MachineKeyValue != ""):
+			// Machine keys are empty markers unless a value is configured
+			res.Issues = append(res.Issues, ComplianceIssue{Key: key, Problem: IssueEmpty})
+		}
+	}
+
+	if e.policy == nil {
+		return
+	}
+	for _, rule := range e.policy.Tags {
+		value, ok := res.ExistingTags[rule.Key]
+		if !ok || value == "" || !rule.AppliesTo(res.Type) {
+			continue
+		}
+		if reason := rule.Check(value); reason != "" {
+			res.Issues = append(res.Issues, ComplianceIssue{
+				Key:     rule.Key,
+				Problem: IssueInvalid,
+				Detail:  fmt.Sprintf("%q %s", value, reason),
+			})
+		}
+	}
+}
+
+// Compliance counts the audited resources of the region per type
+func (r *RegionReport) Compliance() RegionCompliance {
+	rc := RegionCompliance{Account: r.Account, Region: r.Region, Types: []TypeCompliance{}}
+	index := make(map[string]int)
+	for _, res := range r.Resources {
+		i, ok := index[res.Type]
+		if !ok {
+			i = len(rc.Types)
+			index[res.Type] = i
+			rc.Types = append(rc.Types, TypeCompliance{Type: res.Type})
+		}
+
+		tc := &rc.Types[i]
+		tc.Resources++
+		if res.Error != "" {
+			tc.Unreadable++
+			continue
+		}
+		if len(res.Issues) == 0 {
+			tc.Compliant++
+			continue
+		}
+		seen := make(map[string]bool)
+		for _, issue := range res.Issues {
+			seen[issue.Problem] = true
+		}
+		if seen[IssueMissing] {
+			tc.Missing++
+		}
+		if seen[IssueEmpty] {
+			tc.Empty++
+		}
+		if seen[IssueInvalid] {
+			tc.Invalid++
+		}
+	}
+	return rc
+}
+
+// NonCompliant returns the resources audit mode found issues on, in processing order
+func (r *Report) NonCompliant() []*ResourceResult {
+	var found []*ResourceResult
+	for _, res := range r.Resources() {
+		if len(res.Issues) > 0 {
+			found = append(found, res)
+		}
+	}
+	return found
+}
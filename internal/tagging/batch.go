@@ -0,0 +1,183 @@
+package tagging
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/aws/smithy-go"
+)
+
+// createTagsBatchSize is the most resources sent in one CreateTags call,
+// well under the 1000 IDs the API accepts
+const createTagsBatchSize = 500
+
+// tagBatch is a set of EC2 resources in one account and region waiting for the same tags
+type tagBatch struct {
+	region    string
+	client    EC2API
+	tags      []types.Tag
+	resources []*ResourceResult
+}
+
+// tagBatcher groups pending EC2 tag writes by region and tag set, so that
+// resources getting identical tags share CreateTags calls. It is shared by
+// the regions processed concurrently.
+type tagBatcher struct {
+	mu      sync.Mutex
+	pending map[string]*tagBatch
+	order   []string
+}
+
+func newTagBatcher() *tagBatcher {
+	return &tagBatcher{pending: make(map[string]*tagBatch)}
+}
+
+// batchKey identifies an account, region and tag set, whatever the order
+// of the tags
+func batchKey(account, region string, tags []types.Tag) string {
+	pairs := make([]string, len(tags))
+	for i, tag := range tags {
+		pairs[i] = aws.ToString(tag.Key) + "=" + aws.ToString(tag.Value)
+	}
+	sort.Strings(pairs)
+	return account + "\x00" + region + "\x00" + strings.Join(pairs, "\x00")
+}
+
+// add queues res and returns a batch that is full and must be flushed now, if any
+func (b *tagBatcher) add(client EC2API, res *ResourceResult, tags []types.Tag) *tagBatch {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	key := batchKey(res.Account, res.Region, tags)
+	batch, ok := b.pending[key]
+	if !ok {
+		batch = &tagBatch{region: res.Region, client: client, tags: tags}
+		b.pending[key] = batch
+		b.order = append(b.order, key)
+	}
+	batch.resources = append(batch.resources, res)
+
+	if len(batch.resources) < createTagsBatchSize {
+		return nil
+	}
+	b.remove(key)
+	return batch
+}
+
+// take removes and returns the pending batches of region, or of every
+// region when region is empty, in the order they were started
+func (b *tagBatcher) take(region string) []*tagBatch {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	batches := []*tagBatch{}
+	for _, key := range append([]string(nil), b.order...) {
+		if batch := b.pending[key]; region == "" || batch.region == region {
+			batches = append(batches, batch)
+			b.remove(key)
+		}
+	}
+	return batches
+}
+
+func (b *tagBatcher) remove(key string) {
+	delete(b.pending, key)
+	for i, k := range b.order {
+		if k == key {
+			b.order = append(b.order[:i], b.order[i+1:]...)
+			break
+		}
+	}
+}
+
+// queueTags adds an EC2 tag write to the pending batches, flushing the
+// batch right away once it is full
+func (e *Engine) queueTags(ctx context.Context, client EC2API, res *ResourceResult, tags []types.Tag) {
+	if batch := e.batcher.add(client, res, tags); batch != nil {
+		e.flushBatch(ctx, batch)
+	}
+}
+
+// flushTags writes the pending EC2 tags of region, or of every region when
+// region is empty
+func (e *Engine) flushTags(ctx context.Context, region string) {
+	for _, batch := range e.batcher.take(region) {
+		e.flushBatch(ctx, batch)
+	}
+}
+
+// flushBatch tags every resource of a batch with one CreateTags call. If
+// the call fails because of one of the resources, each resource is retried
+// on its own so that the error is reported on the resources it belongs to.
+// Any other error, such as throttling that outlasted the retries, fails the
+// whole batch without issuing more calls.
+func (e *Engine) flushBatch(ctx context.Context, batch *tagBatch) {
+	if err := e.journalError(); err != nil {
+		for _, res := range batch.resources {
+			res.Error = err.Error()
+		}
+		return
+	}
+
+	// The same resource can be queued twice; CreateTags wants each ID once
+	ids := []string{}
+	seen := make(map[string]bool)
+	for _, res := range batch.resources {
+		if !seen[res.ID] {
+			seen[res.ID] = true
+			ids = append(ids, res.ID)
+		}
+	}
+
+	_, err := batch.client.CreateTags(ctx, &ec2.CreateTagsInput{
+		Resources: ids,
+		Tags:      batch.tags,
+	})
+	if err == nil {
+		for _, res := range batch.resources {
+			e.applied(res)
+		}
+		return
+	}
+	if len(ids) == 1 || !isResourceError(err) {
+		for _, res := range batch.resources {
+			res.Error = err.Error()
+		}
+		return
+	}
+
+	for _, res := range batch.resources {
+		_, err := batch.client.CreateTags(ctx, &ec2.CreateTagsInput{
+			Resources: []string{res.ID},
+			Tags:      batch.tags,
+		})
+		if err != nil {
+			res.Error = err.Error()
+			continue
+		}
+		e.applied(res)
+	}
+}
+
+// isResourceError reports whether a CreateTags error is caused by one of
+// the resources rather than by the request, such as an unknown or deleted ID
+func isResourceError(err error) bool {
+	var apiErr smithy.APIError
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+	code := apiErr.ErrorCode()
+	return code == "InvalidID" || strings.HasSuffix(code, ".NotFound") || strings.HasSuffix(code, ".Malformed")
+}
+
+// applied marks the planned tags of res as written and journals them
+func (e *Engine) applied(res *ResourceResult) {
+	res.AppliedTags = res.PlannedTags
+	e.record(res, res.AppliedTags)
+}
@@ -0,0 +1,268 @@
+package tagging
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/aws/aws-sdk-go-v2/service/efs"
+	"github.com/aws/aws-sdk-go-v2/service/fsx"
+)
+
+// taggedFunc is called with every resource walked and its current tags
+type taggedFunc func(rr *RegionReport, resourceType, id string, tags map[string]string)
+
+// cleanupTargets are the keys removed by cleanup mode
+type cleanupTargets struct {
+	// patterns match keys to remove from every resource
+	patterns []*regexp.Regexp
+	// journaled are the keys journals recorded the tool adding, with the
+	// value written, by account, region and resource ID
+	journaled map[string]map[string]string
+}
+
+// globRegexp compiles a key pattern where '*' matches any run of characters
+// and '?' a single one
+func globRegexp(pattern string) *regexp.Regexp {
+	quoted := regexp.QuoteMeta(pattern)
+	quoted = strings.ReplaceAll(quoted, `\*`, ".*")
+	quoted = strings.ReplaceAll(quoted, `\?`, ".")
+	return regexp.MustCompile("^" + quoted + "$")
+}
+
+// loadCleanupTargets reads the key patterns and journals of the options.
+// Name is never taken from a journal: it is the one key the tool writes
+// that people rely on.
+func loadCleanupTargets(opts Options) (*cleanupTargets, error) {
+	targets := &cleanupTargets{journaled: make(map[string]map[string]string)}
+	for _, pattern := range opts.CleanupKeys {
+		targets.patterns = append(targets.patterns, globRegexp(pattern))
+	}
+
+	for _, path := range opts.FromJournals {
+		entries, err := ReadJournal(path)
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			if entry.Key == "Name" {
+				continue
+			}
+			ref := entry.Account + "|" + entry.Region + "|" + entry.ID
+			keys, ok := targets.journaled[ref]
+			if !ok {
+				keys = make(map[string]string)
+				targets.journaled[ref] = keys
+			}
+			// Only keys the tool created; the latest write is what should
+			// remain, and a key removed since has nothing left to clean up
+			if entry.Removed {
+				delete(keys, entry.Key)
+			} else if _, seen := keys[entry.Key]; seen || entry.Previous == nil {
+				keys[entry.Key] = entry.Value
+			}
+		}
+	}
+
+	if len(targets.patterns) == 0 && len(opts.FromJournals) == 0 {
+		return nil, fmt.Errorf("cleanup needs --keys or --from-journal to know which tags to remove")
+	}
+	return targets, nil
+}
+
+// keys returns the tags of a resource to remove, in sorted order. Journaled
+// keys are only removed while they still have the value the tool wrote.
+func (t *cleanupTargets) keys(account, region, id string, tags map[string]string) []string {
+	journaled := t.journaled[account+"|"+region+"|"+id]
+	remove := []string{}
+	for _, key := range sortedKeys(tags) {
+		if written, ok := journaled[key]; ok && tags[key] == written {
+			remove = append(remove, key)
+			continue
+		}
+		for _, re := range t.patterns {
+			if re.MatchString(key) {
+				remove = append(remove, key)
+				break
+			}
+		}
+	}
+	return remove
+}
+
+// runCleanup removes tool-managed tags, chosen by key pattern or from apply
+// journals, from EC2, EBS, EFS and FSx resources
+func (e *Engine) runCleanup(ctx context.Context, regions []string) error {
+	targets, err := loadCleanupTargets(e.opts)
+	if err != nil {
+		return err
+	}
+
+	clean := func(rr *RegionReport, resourceType, id string, tags map[string]string) {
+		if !e.selected(tags, id) {
+			return
+		}
+		if keys := targets.keys(rr.Account, rr.Region, id, tags); len(keys) > 0 {
+			e.removeOrPlan(ctx, rr.add(resourceType, id, tags), keys, tags)
+		}
+	}
+	e.forEachRegion(ctx, regions,
+		func(ctx context.Context, rr *RegionReport) { e.walkEC2(ctx, rr, clean) },
+		func(ctx context.Context, rr *RegionReport) { e.walkEFS(ctx, rr, clean) },
+		func(ctx context.Context, rr *RegionReport) { e.walkFSx(ctx, rr, clean) },
+	)
+	return nil
+}
+
+// walkEC2 calls fn with every instance, volume, snapshot and AMI of a region
+func (e *Engine) walkEC2(ctx context.Context, rr *RegionReport, fn taggedFunc) {
+	client := e.clients.EC2(rr.Region)
+
+	instances := ec2.NewDescribeInstancesPaginator(client, &ec2.DescribeInstancesInput{})
+	for instances.HasMorePages() {
+		page, err := instances.NextPage(ctx)
+		if err != nil {
+			rr.warn(fmt.Sprintf("Failed to describe instances in %s: %v", rr.Region, err))
+			break
+		}
+		for _, reservation := range page.Reservations {
+			for _, instance := range reservation.Instances {
+				if instance.State != nil && instance.State.Name == types.InstanceStateNameTerminated {
+					continue
+				}
+				fn(rr, "EC2 Instance", aws.ToString(instance.InstanceId), ec2TagMap(instance.Tags))
+			}
+		}
+	}
+
+	volumes := ec2.NewDescribeVolumesPaginator(client, &ec2.DescribeVolumesInput{})
+	for volumes.HasMorePages() {
+		page, err := volumes.NextPage(ctx)
+		if err != nil {
+			rr.warn(fmt.Sprintf("Failed to describe volumes: %v", err))
+			break
+		}
+		for _, volume := range page.Volumes {
+			fn(rr, "Volume", aws.ToString(volume.VolumeId), ec2TagMap(volume.Tags))
+		}
+	}
+
+	for _, snapshot := range e.snapshotIndex(ctx, client, rr).snapshots {
+		fn(rr, "Snapshot", aws.ToString(snapshot.SnapshotId), ec2TagMap(snapshot.Tags))
+	}
+	for _, image := range e.imageIndex(ctx, client, rr).images {
+		fn(rr, "AMI", aws.ToString(image.ImageId), ec2TagMap(image.Tags))
+	}
+}
+
+// walkEFS calls fn with every EFS file system and access point of a region
+func (e *Engine) walkEFS(ctx context.Context, rr *RegionReport, fn taggedFunc) {
+	client := e.clients.EFS(rr.Region)
+
+	fileSystems := efs.NewDescribeFileSystemsPaginator(client, &efs.DescribeFileSystemsInput{})
+	for fileSystems.HasMorePages() {
+		page, err := fileSystems.NextPage(ctx)
+		if err != nil {
+			rr.warn(fmt.Sprintf("Failed to describe EFS file systems: %v", err))
+			return
+		}
+		for _, fs := range page.FileSystems {
+			fsID := aws.ToString(fs.FileSystemId)
+			if tags, err := e.listTagsEFS(ctx, client, fsID); err != nil {
+				e.readFailed(rr, "EFS FileSystem", fsID, err)
+			} else {
+				fn(rr, "EFS FileSystem", fsID, tags)
+			}
+			e.walkAccessPoints(ctx, rr, client, fsID, fn)
+		}
+	}
+}
+
+// walkAccessPoints calls fn with every access point of an EFS file system
+func (e *Engine) walkAccessPoints(ctx context.Context, rr *RegionReport, client EFSAPI, fsID string, fn taggedFunc) {
+	accessPoints := efs.NewDescribeAccessPointsPaginator(client, &efs.DescribeAccessPointsInput{FileSystemId: aws.String(fsID)})
+	for accessPoints.HasMorePages() {
+		page, err := accessPoints.NextPage(ctx)
+		if err != nil {
+			rr.warn(fmt.Sprintf("Failed to describe EFS access points of %s: %v", fsID, err))
+			return
+		}
+		for _, ap := range page.AccessPoints {
+			apID := aws.ToString(ap.AccessPointId)
+			if tags, err := e.listTagsEFS(ctx, client, apID); err != nil {
+				e.readFailed(rr, "EFS AccessPoint", apID, err)
+			} else {
+				fn(rr, "EFS AccessPoint", apID, tags)
+			}
+		}
+	}
+}
+
+// walkFSx calls fn with every FSx file system, backup and volume of a region
+func (e *Engine) walkFSx(ctx context.Context, rr *RegionReport, fn taggedFunc) {
+	client := e.clients.FSx(rr.Region)
+
+	var arns []string
+	var resourceTypes []string
+	add := func(resourceType string, arn *string) {
+		arns = append(arns, aws.ToString(arn))
+		resourceTypes = append(resourceTypes, resourceType)
+	}
+
+	fileSystems := fsx.NewDescribeFileSystemsPaginator(client, &fsx.DescribeFileSystemsInput{})
+	for fileSystems.HasMorePages() {
+		page, err := fileSystems.NextPage(ctx)
+		if err != nil {
+			rr.warn(fmt.Sprintf("Failed to describe FSx file systems: %v", err))
+			break
+		}
+		for _, fs := range page.FileSystems {
+			add("FSx FileSystem", fs.ResourceARN)
+		}
+	}
+	backups := fsx.NewDescribeBackupsPaginator(client, &fsx.DescribeBackupsInput{})
+	for backups.HasMorePages() {
+		page, err := backups.NextPage(ctx)
+		if err != nil {
+			rr.warn(fmt.Sprintf("Failed to describe FSx backups: %v", err))
+			break
+		}
+		for _, backup := range page.Backups {
+			add("FSx Backup", backup.ResourceARN)
+		}
+	}
+	volumes := fsx.NewDescribeVolumesPaginator(client, &fsx.DescribeVolumesInput{})
+	for volumes.HasMorePages() {
+		page, err := volumes.NextPage(ctx)
+		if err != nil {
+			rr.warn(fmt.Sprintf("Failed to describe FSx volumes: %v", err))
+			break
+		}
+		for _, volume := range page.Volumes {
+			add("FSx Volume", volume.ResourceARN)
+		}
+	}
+
+	for i, arn := range arns {
+		if tags, err := e.listTagsFSx(ctx, client, arn); err != nil {
+			e.readFailed(rr, resourceTypes[i], arn, err)
+		} else {
+			fn(rr, resourceTypes[i], arn, tags)
+		}
+	}
+}
+
+// sortedKeys returns the keys of a tag map in sorted order
+func sortedKeys(tags map[string]string) []string {
+	keys := make([]string, 0, len(tags))
+	for key := range tags {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,194 @@
+package tagging
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
+	"github.com/aws/aws-sdk-go-v2/service/costexplorer"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/efs"
+	"github.com/aws/aws-sdk-go-v2/service/fsx"
+	"github.com/aws/aws-sdk-go-v2/service/organizations"
+	"github.com/aws/aws-sdk-go-v2/service/resourcegroupstaggingapi"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+)
+
+// EC2API is the subset of the EC2 API used by the engine
+type EC2API interface {
+	DescribeInstances(ctx context.Context, params *ec2.DescribeInstancesInput, optFns ...func(*ec2.Options)) (*ec2.DescribeInstancesOutput, error)
+	DescribeVolumes(ctx context.Context, params *ec2.DescribeVolumesInput, optFns ...func(*ec2.Options)) (*ec2.DescribeVolumesOutput, error)
+	DescribeSnapshots(ctx context.Context, params *ec2.DescribeSnapshotsInput, optFns ...func(*ec2.Options)) (*ec2.DescribeSnapshotsOutput, error)
+	DescribeImages(ctx context.Context, params *ec2.DescribeImagesInput, optFns ...func(*ec2.Options)) (*ec2.DescribeImagesOutput, error)
+	DescribeTags(ctx context.Context, params *ec2.DescribeTagsInput, optFns ...func(*ec2.Options)) (*ec2.DescribeTagsOutput, error)
+	DescribeRegions(ctx context.Context, params *ec2.DescribeRegionsInput, optFns ...func(*ec2.Options)) (*ec2.DescribeRegionsOutput, error)
+	CreateTags(ctx context.Context, params *ec2.CreateTagsInput, optFns ...func(*ec2.Options)) (*ec2.CreateTagsOutput, error)
+	DeleteTags(ctx context.Context, params *ec2.DeleteTagsInput, optFns ...func(*ec2.Options)) (*ec2.DeleteTagsOutput, error)
+}
+
+// EFSAPI is the subset of the EFS API used by the engine
+type EFSAPI interface {
+	DescribeFileSystems(ctx context.Context, params *efs.DescribeFileSystemsInput, optFns ...func(*efs.Options)) (*efs.DescribeFileSystemsOutput, error)
+	DescribeAccessPoints(ctx context.Context, params *efs.DescribeAccessPointsInput, optFns ...func(*efs.Options)) (*efs.DescribeAccessPointsOutput, error)
+	ListTagsForResource(ctx context.Context, params *efs.ListTagsForResourceInput, optFns ...func(*efs.Options)) (*efs.ListTagsForResourceOutput, error)
+	TagResource(ctx context.Context, params *efs.TagResourceInput, optFns ...func(*efs.Options)) (*efs.TagResourceOutput, error)
+	UntagResource(ctx context.Context, params *efs.UntagResourceInput, optFns ...func(*efs.Options)) (*efs.UntagResourceOutput, error)
+}
+
+// FSxAPI is the subset of the FSx API used by the engine
+type FSxAPI interface {
+	DescribeFileSystems(ctx context.Context, params *fsx.DescribeFileSystemsInput, optFns ...func(*fsx.Options)) (*fsx.DescribeFileSystemsOutput, error)
+	DescribeBackups(ctx context.Context, params *fsx.DescribeBackupsInput, optFns ...func(*fsx.Options)) (*fsx.DescribeBackupsOutput, error)
+	DescribeVolumes(ctx context.Context, params *fsx.DescribeVolumesInput, optFns ...func(*fsx.Options)) (*fsx.DescribeVolumesOutput, error)
+	ListTagsForResource(ctx context.Context, params *fsx.ListTagsForResourceInput, optFns ...func(*fsx.Options)) (*fsx.ListTagsForResourceOutput, error)
+	TagResource(ctx context.Context, params *fsx.TagResourceInput, optFns ...func(*fsx.Options)) (*fsx.TagResourceOutput, error)
+	UntagResource(ctx context.Context, params *fsx.UntagResourceInput, optFns ...func(*fsx.Options)) (*fsx.UntagResourceOutput, error)
+}
+
+// CostExplorerAPI is the subset of the Cost Explorer API used by the engine
+type CostExplorerAPI interface {
+	ListCostAllocationTags(ctx context.Context, params *costexplorer.ListCostAllocationTagsInput, optFns ...func(*costexplorer.Options)) (*costexplorer.ListCostAllocationTagsOutput, error)
+	UpdateCostAllocationTagsStatus(ctx context.Context, params *costexplorer.UpdateCostAllocationTagsStatusInput, optFns ...func(*costexplorer.Options)) (*costexplorer.UpdateCostAllocationTagsStatusOutput, error)
+}
+
+// TaggingAPI is the subset of the Resource Groups Tagging API used by the engine
+type TaggingAPI interface {
+	GetTagKeys(ctx context.Context, params *resourcegroupstaggingapi.GetTagKeysInput, optFns ...func(*resourcegroupstaggingapi.Options)) (*resourcegroupstaggingapi.GetTagKeysOutput, error)
+}
+
+// OrganizationsAPI is the subset of the Organizations API used by the engine
+type OrganizationsAPI interface {
+	ListAccounts(ctx context.Context, params *organizations.ListAccountsInput, optFns ...func(*organizations.Options)) (*organizations.ListAccountsOutput, error)
+}
+
+// ClientFactory builds service clients for a given region.
+// An empty region means the default region of the underlying configuration,
+// or FallbackRegion when it has none.
+type ClientFactory interface {
+	EC2(region string) EC2API
+	EFS(region string) EFSAPI
+	FSx(region string) FSxAPI
+	Tagging(region string) TaggingAPI
+	CostExplorer() CostExplorerAPI
+	Organizations() OrganizationsAPI
+}
+
+// LoadConfig loads the AWS configuration of the options: the shared config
+// Profile, with RoleARN assumed on top of it, and every service sent to
+// EndpointURL when set, such as a LocalStack endpoint. MFA codes for
+// MFASerial, or for profiles with an mfa_serial, are read from stdin.
+func LoadConfig(ctx context.Context, opts Options) (aws.Config, error) {
+	loadOpts := []func(*config.LoadOptions) error{
+		config.WithAssumeRoleCredentialOptions(func(o *stscreds.AssumeRoleOptions) {
+			o.TokenProvider = stscreds.StdinTokenProvider
+		}),
+	}
+	if opts.Profile != "" {
+		loadOpts = append(loadOpts, config.WithSharedConfigProfile(opts.Profile))
+	}
+	cfg, err := config.LoadDefaultConfig(ctx, loadOpts...)
+	if err != nil {
+		return aws.Config{}, fmt.Errorf("failed to load AWS config: %w", err)
+	}
+	if opts.EndpointURL != "" {
+		cfg.BaseEndpoint = aws.String(opts.EndpointURL)
+	}
+
+	if opts.RoleARN != "" {
+		provider := stscreds.NewAssumeRoleProvider(sts.NewFromConfig(cfg), opts.RoleARN, func(o *stscreds.AssumeRoleOptions) {
+			o.RoleSessionName = roleSessionName
+			if opts.MFASerial != "" {
+				o.SerialNumber = aws.String(opts.MFASerial)
+				o.TokenProvider = stscreds.StdinTokenProvider
+			}
+		})
+		cfg.Credentials = aws.NewCredentialsCache(provider)
+	}
+	return cfg, nil
+}
+
+// DefaultMaxAttempts is the number of attempts made for each AWS call,
+// including the first one
+const DefaultMaxAttempts = 5
+
+// FallbackRegion is the region of clients built for no region when the
+// configuration has none, such as the one discovering regions
+const FallbackRegion = "us-east-1"
+
+// awsClientFactory builds real AWS SDK clients from a loaded config
+type awsClientFactory struct {
+	cfg         aws.Config
+	maxAttempts int
+
+	mu       sync.Mutex
+	retryers map[string]aws.Retryer
+}
+
+// NewClientFactory returns a ClientFactory backed by the AWS SDK. Calls are
+// retried up to maxAttempts times with backoff, and throttling slows down
+// every later call to the same service and region.
+func NewClientFactory(cfg aws.Config, maxAttempts int) ClientFactory {
+	if maxAttempts < 1 {
+		maxAttempts = DefaultMaxAttempts
+	}
+	return &awsClientFactory{cfg: cfg, maxAttempts: maxAttempts, retryers: make(map[string]aws.Retryer)}
+}
+
+// retryer returns the retryer shared by the clients of a service in a
+// region, so that its adaptive rate limit sees all of their throttling
+func (f *awsClientFactory) retryer(service, region string) aws.Retryer {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	key := service + "|" + region
+	r, ok := f.retryers[key]
+	if !ok {
+		r = retry.NewAdaptiveMode(func(o *retry.AdaptiveModeOptions) {
+			o.StandardOptions = append(o.StandardOptions, func(so *retry.StandardOptions) {
+				so.MaxAttempts = f.maxAttempts
+			})
+		})
+		f.retryers[key] = r
+	}
+	return r
+}
+
+func (f *awsClientFactory) serviceConfig(service, region string) aws.Config {
+	cfg := f.cfg.Copy()
+	if region != "" {
+		cfg.Region = region
+	} else if cfg.Region == "" {
+		cfg.Region = FallbackRegion
+	}
+	r := f.retryer(service, cfg.Region)
+	cfg.Retryer = func() aws.Retryer { return r }
+	return cfg
+}
+
+func (f *awsClientFactory) EC2(region string) EC2API {
+	return ec2.NewFromConfig(f.serviceConfig("ec2", region))
+}
+
+func (f *awsClientFactory) EFS(region string) EFSAPI {
+	return efs.NewFromConfig(f.serviceConfig("efs", region))
+}
+
+func (f *awsClientFactory) FSx(region string) FSxAPI {
+	return fsx.NewFromConfig(f.serviceConfig("fsx", region))
+}
+
+func (f *awsClientFactory) Tagging(region string) TaggingAPI {
+	return resourcegroupstaggingapi.NewFromConfig(f.serviceConfig("tagging", region))
+}
+
+func (f *awsClientFactory) CostExplorer() CostExplorerAPI {
+	return costexplorer.NewFromConfig(f.serviceConfig("ce", ""))
+}
+
+func (f *awsClientFactory) Organizations() OrganizationsAPI {
+	return organizations.NewFromConfig(f.serviceConfig("organizations", ""))
+}
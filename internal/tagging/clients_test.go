@@ -0,0 +1,384 @@
+package tagging
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/costexplorer"
+	ceTypes "github.com/aws/aws-sdk-go-v2/service/costexplorer/types"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	ec2types "github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/aws/aws-sdk-go-v2/service/efs"
+	efstypes "github.com/aws/aws-sdk-go-v2/service/efs/types"
+	"github.com/aws/aws-sdk-go-v2/service/fsx"
+	fsxtypes "github.com/aws/aws-sdk-go-v2/service/fsx/types"
+	"github.com/aws/aws-sdk-go-v2/service/resourcegroupstaggingapi"
+	"github.com/aws/smithy-go"
+)
+
+// stubEC2 records CreateTags calls; unused methods panic via the embedded interface.
+// CreateTags fails for any call that includes a rejected resource.
+type stubEC2 struct {
+	EC2API
+	created  []*ec2.CreateTagsInput
+	rejected map[string]bool
+}
+
+func (s *stubEC2) DescribeSnapshots(ctx context.Context, params *ec2.DescribeSnapshotsInput, optFns ...func(*ec2.Options)) (*ec2.DescribeSnapshotsOutput, error) {
+	return &ec2.DescribeSnapshotsOutput{}, nil
+}
+
+func (s *stubEC2) CreateTags(ctx context.Context, params *ec2.CreateTagsInput, optFns ...func(*ec2.Options)) (*ec2.CreateTagsOutput, error) {
+	s.created = append(s.created, params)
+	for _, id := range params.Resources {
+		if s.rejected[id] {
+			return nil, &smithy.GenericAPIError{Code: "InvalidID", Message: id}
+		}
+	}
+	return &ec2.CreateTagsOutput{}, nil
+}
+
+type stubEFS struct {
+	EFSAPI
+	tagged []*efs.TagResourceInput
+}
+
+func (s *stubEFS) DescribeFileSystems(ctx context.Context, params *efs.DescribeFileSystemsInput, optFns ...func(*efs.Options)) (*efs.DescribeFileSystemsOutput, error) {
+	return &efs.DescribeFileSystemsOutput{
+		FileSystems: []efstypes.FileSystemDescription{
+			{FileSystemId: aws.String("fs-1"), Name: aws.String("shared data")},
+		},
+	}, nil
+}
+
+func (s *stubEFS) DescribeAccessPoints(ctx context.Context, params *efs.DescribeAccessPointsInput, optFns ...func(*efs.Options)) (*efs.DescribeAccessPointsOutput, error) {
+	return &efs.DescribeAccessPointsOutput{}, nil
+}
+
+func (s *stubEFS) ListTagsForResource(ctx context.Context, params *efs.ListTagsForResourceInput, optFns ...func(*efs.Options)) (*efs.ListTagsForResourceOutput, error) {
+	return &efs.ListTagsForResourceOutput{}, nil
+}
+
+func (s *stubEFS) TagResource(ctx context.Context, params *efs.TagResourceInput, optFns ...func(*efs.Options)) (*efs.TagResourceOutput, error) {
+	s.tagged = append(s.tagged, params)
+	return &efs.TagResourceOutput{}, nil
+}
+
+type stubFSx struct {
+	FSxAPI
+	tagged []*fsx.TagResourceInput
+}
+
+func (s *stubFSx) DescribeFileSystems(ctx context.Context, params *fsx.DescribeFileSystemsInput, optFns ...func(*fsx.Options)) (*fsx.DescribeFileSystemsOutput, error) {
+	return &fsx.DescribeFileSystemsOutput{
+		FileSystems: []fsxtypes.FileSystem{
+			{FileSystemId: aws.String("fs-0abc"), ResourceARN: aws.String("arn:aws:fsx:us-east-1:123456789012:file-system/fs-0abc")},
+		},
+	}, nil
+}
+
+func (s *stubFSx) DescribeBackups(ctx context.Context, params *fsx.DescribeBackupsInput, optFns ...func(*fsx.Options)) (*fsx.DescribeBackupsOutput, error) {
+	return &fsx.DescribeBackupsOutput{}, nil
+}
+
+func (s *stubFSx) DescribeVolumes(ctx context.Context, params *fsx.DescribeVolumesInput, optFns ...func(*fsx.Options)) (*fsx.DescribeVolumesOutput, error) {
+	return &fsx.DescribeVolumesOutput{}, nil
+}
+
+func (s *stubFSx) ListTagsForResource(ctx context.Context, params *fsx.ListTagsForResourceInput, optFns ...func(*fsx.Options)) (*fsx.ListTagsForResourceOutput, error) {
+	return &fsx.ListTagsForResourceOutput{}, nil
+}
+
+func (s *stubFSx) TagResource(ctx context.Context, params *fsx.TagResourceInput, optFns ...func(*fsx.Options)) (*fsx.TagResourceOutput, error) {
+	s.tagged = append(s.tagged, params)
+	return &fsx.TagResourceOutput{}, nil
+}
+
+type stubCostExplorer struct {
+	CostExplorerAPI
+	active  []string
+	updated []*costexplorer.UpdateCostAllocationTagsStatusInput
+}
+
+func (s *stubCostExplorer) ListCostAllocationTags(ctx context.Context, params *costexplorer.ListCostAllocationTagsInput, optFns ...func(*costexplorer.Options)) (*costexplorer.ListCostAllocationTagsOutput, error) {
+	out := &costexplorer.ListCostAllocationTagsOutput{}
+	for _, key := range s.active {
+		out.CostAllocationTags = append(out.CostAllocationTags, ceTypes.CostAllocationTag{
+			TagKey: aws.String(key),
+			Status: ceTypes.CostAllocationTagStatusActive,
+		})
+	}
+	return out, nil
+}
+
+func (s *stubCostExplorer) UpdateCostAllocationTagsStatus(ctx context.Context, params *costexplorer.UpdateCostAllocationTagsStatusInput, optFns ...func(*costexplorer.Options)) (*costexplorer.UpdateCostAllocationTagsStatusOutput, error) {
+	s.updated = append(s.updated, params)
+	return &costexplorer.UpdateCostAllocationTagsStatusOutput{}, nil
+}
+
+// stubTagging lists fixed tag keys
+type stubTagging struct {
+	keys []string
+}
+
+func (s *stubTagging) GetTagKeys(ctx context.Context, params *resourcegroupstaggingapi.GetTagKeysInput, optFns ...func(*resourcegroupstaggingapi.Options)) (*resourcegroupstaggingapi.GetTagKeysOutput, error) {
+	return &resourcegroupstaggingapi.GetTagKeysOutput{TagKeys: s.keys}, nil
+}
+
+type stubFactory struct {
+	ec2     *stubEC2
+	efs     *stubEFS
+	fsx     *stubFSx
+	tagging *stubTagging
+	ce      *stubCostExplorer
+}
+
+func (f *stubFactory) EC2(region string) EC2API         { return f.ec2 }
+func (f *stubFactory) EFS(region string) EFSAPI         { return f.efs }
+func (f *stubFactory) FSx(region string) FSxAPI         { return f.fsx }
+func (f *stubFactory) Tagging(region string) TaggingAPI { return f.tagging }
+func (f *stubFactory) CostExplorer() CostExplorerAPI    { return f.ce }
+func (f *stubFactory) Organizations() OrganizationsAPI  { return nil }
+
+func tagMap(tags []ec2types.Tag) map[string]string {
+	m := make(map[string]string)
+	for _, tag := range tags {
+		m[aws.ToString(tag.Key)] = aws.ToString(tag.Value)
+	}
+	return m
+}
+
+func TestProcessInstance_AppliesNameAndMachineKey(t *testing.T) {
+	client := &stubEC2{}
+	e := NewEngine(Options{Apply: true, TagInstances: true}, &stubFactory{ec2: client})
+	e.report = &Report{}
+	rr := e.report.region("", "us-east-1")
+
+	e.processInstance(context.Background(), client, rr, ec2types.Instance{
+		InstanceId: aws.String("i-0123"),
+		State:      &ec2types.InstanceState{Name: ec2types.InstanceStateNameRunning},
+		Tags: []ec2types.Tag{
+			{Key: aws.String("Name"), Value: aws.String("web server")},
+		},
+	}, nil)
+	e.flushTags(context.Background(), "")
+
+	if len(client.created) != 1 {
+		t.Fatalf("expected 1 CreateTags call, got %d", len(client.created))
+	}
+	call := client.created[0]
+	if len(call.Resources) != 1 || call.Resources[0] != "i-0123" {
+		t.Errorf("unexpected resources: %#v", call.Resources)
+	}
+	tags := tagMap(call.Tags)
+	if len(tags) != 1 {
+		t.Fatalf("expected only the machine key to be added, got %#v", tags)
+	}
+	if v, ok := tags["web-server"]; !ok || v != "" {
+		t.Errorf("expected machine key 'web-server' with empty value, got %#v", tags)
+	}
+
+	if len(rr.Resources) != 1 {
+		t.Fatalf("expected 1 resource in the report, got %d", len(rr.Resources))
+	}
+	res := rr.Resources[0]
+	if res.MachineKey != "web-server" || len(res.AppliedTags) != 1 || res.Error != "" {
+		t.Errorf("unexpected report entry: %#v", res)
+	}
+}
+
+func TestProcessInstance_DryRunDoesNotCreateTags(t *testing.T) {
+	client := &stubEC2{}
+	e := NewEngine(Options{TagInstances: true}, &stubFactory{ec2: client})
+	e.report = &Report{}
+	rr := e.report.region("", "us-east-1")
+
+	e.processInstance(context.Background(), client, rr, ec2types.Instance{
+		InstanceId: aws.String("i-0123"),
+		State:      &ec2types.InstanceState{Name: ec2types.InstanceStateNameRunning},
+	}, nil)
+
+	if len(client.created) != 0 {
+		t.Fatalf("expected no CreateTags calls in dry-run, got %d", len(client.created))
+	}
+	if s := rr.Summary(); s.Planned != 1 || s.Applied != 0 {
+		t.Errorf("expected 1 planned and 0 applied resources, got %+v", s)
+	}
+}
+
+func TestProcessEFS_TagsFileSystem(t *testing.T) {
+	client := &stubEFS{}
+	e := NewEngine(Options{Apply: true}, &stubFactory{efs: client})
+	e.report = &Report{}
+
+	e.processEFS(context.Background(), e.report.region("", "us-east-1"))
+
+	if len(client.tagged) != 1 {
+		t.Fatalf("expected 1 TagResource call, got %d", len(client.tagged))
+	}
+	if got := aws.ToString(client.tagged[0].ResourceId); got != "fs-1" {
+		t.Errorf("expected fs-1 to be tagged, got %q", got)
+	}
+	if n := len(client.tagged[0].Tags); n != 2 {
+		t.Errorf("expected Name and machine key tags, got %d tags", n)
+	}
+}
+
+func TestProcessFSx_TagsFileSystemByARN(t *testing.T) {
+	client := &stubFSx{}
+	e := NewEngine(Options{Apply: true}, &stubFactory{fsx: client})
+	e.report = &Report{}
+
+	e.processFSx(context.Background(), e.report.region("", "us-east-1"))
+
+	if len(client.tagged) != 1 {
+		t.Fatalf("expected 1 TagResource call, got %d", len(client.tagged))
+	}
+	want := "arn:aws:fsx:us-east-1:123456789012:file-system/fs-0abc"
+	if got := aws.ToString(client.tagged[0].ResourceARN); got != want {
+		t.Errorf("expected %s to be tagged, got %q", want, got)
+	}
+}
+
+func TestRunActivate_ActivatesOnlyInactiveKeys(t *testing.T) {
+	ce := &stubCostExplorer{active: []string{"Name"}}
+	e := NewEngine(Options{Apply: true}, &stubFactory{tagging: &stubTagging{keys: []string{"Name", "web"}}, ce: ce})
+	e.report = &Report{}
+
+	if err := e.runActivate(context.Background(), []string{"us-east-1"}); err != nil {
+		t.Fatalf("runActivate returned error: %v", err)
+	}
+
+	if len(ce.updated) != 1 {
+		t.Fatalf("expected 1 UpdateCostAllocationTagsStatus call, got %d", len(ce.updated))
+	}
+	entries := ce.updated[0].CostAllocationTagsStatus
+	if len(entries) != 1 || aws.ToString(entries[0].TagKey) != "web" {
+		t.Errorf("expected only 'web' to be activated, got %#v", entries)
+	}
+}
+
+func TestFlushTags_ReportsFailuresPerResource(t *testing.T) {
+	client := &stubEC2{rejected: map[string]bool{"vol-2": true}}
+	e := NewEngine(Options{Apply: true}, &stubFactory{ec2: client})
+	e.report = &Report{}
+	rr := e.report.region("", "us-east-1")
+
+	tags := []ec2types.Tag{{Key: aws.String("Owner"), Value: aws.String("ops")}}
+	for _, id := range []string{"vol-1", "vol-2", "vol-3"} {
+		e.planOrApply(context.Background(), client, rr.add("Volume", id, map[string]string{}), tags)
+	}
+	if len(client.created) != 0 {
+		t.Fatalf("expected tags to be queued until flushed, got %d calls", len(client.created))
+	}
+	e.flushTags(context.Background(), "us-east-1")
+
+	// One batch call, then one retry per resource after it failed
+	if len(client.created) != 4 || len(client.created[0].Resources) != 3 {
+		t.Fatalf("expected a batch of 3 followed by 3 retries, got %d calls", len(client.created))
+	}
+	for _, res := range rr.Resources {
+		failed := res.ID == "vol-2"
+		if (res.Error != "") != failed || (len(res.AppliedTags) == 0) != failed {
+			t.Errorf("%s: unexpected result %+v", res.ID, res)
+		}
+	}
+}
+
+func TestClientFactory_SharesRetryerPerServiceAndRegion(t *testing.T) {
+	f := NewClientFactory(aws.Config{Region: "us-east-1"}, 3).(*awsClientFactory)
+
+	ec2East := f.serviceConfig("ec2", "").Retryer()
+	if ec2East != f.serviceConfig("ec2", "us-east-1").Retryer() {
+		t.Error("expected clients of the same service and region to share a retryer")
+	}
+	if ec2East == f.serviceConfig("ec2", "eu-west-1").Retryer() || ec2East == f.serviceConfig("efs", "us-east-1").Retryer() {
+		t.Error("expected other services and regions to get their own retryer")
+	}
+	if n := ec2East.MaxAttempts(); n != 3 {
+		t.Errorf("expected 3 attempts, got %d", n)
+	}
+	if n := NewClientFactory(aws.Config{}, 0).(*awsClientFactory).retryer("ce", "").MaxAttempts(); n != DefaultMaxAttempts {
+		t.Errorf("expected %d attempts by default, got %d", DefaultMaxAttempts, n)
+	}
+}
+
+func TestDiscoverRegions_WithoutDefaultRegion(t *testing.T) {
+	var authorization string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorization = r.Header.Get("Authorization")
+		fmt.Fprint(w, `<DescribeRegionsResponse xmlns="http://ec2.amazonaws.com/doc/2016-11-15/"><regionInfo>`+
+			`<item><regionName>eu-west-1</regionName><optInStatus>opt-in-not-required</optInStatus></item>`+
+			`</regionInfo></DescribeRegionsResponse>`)
+	}))
+	defer server.Close()
+
+	cfg := aws.Config{
+		Credentials:  credentials.NewStaticCredentialsProvider("test", "test", ""),
+		BaseEndpoint: aws.String(server.URL),
+	}
+	opts := DefaultOptions()
+	opts.RegionDiscovery = RegionsAuto
+	opts.RegionCacheFile = ""
+	e := NewEngine(opts, NewClientFactory(cfg, 1))
+
+	// Regions are discovered through us-east-1 when the config has none
+	if got := e.discoverRegions(context.Background()); len(got) != 1 || got[0] != "eu-west-1" {
+		t.Errorf("expected the discovered regions, got %v (warnings %v)", got, e.report.Warnings)
+	}
+	if !strings.Contains(authorization, "/us-east-1/ec2/") {
+		t.Errorf("expected DescribeRegions to be signed for us-east-1, got %q", authorization)
+	}
+}
+
+func TestLoadConfig_ProfileEndpointAndRole(t *testing.T) {
+	dir := t.TempDir()
+	configFile := filepath.Join(dir, "config")
+	credentialsFile := filepath.Join(dir, "credentials")
+	if err := os.WriteFile(configFile, []byte("[profile local]\nregion = eu-west-3\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(credentialsFile, []byte("[local]\naws_access_key_id = test\naws_secret_access_key = test\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("AWS_CONFIG_FILE", configFile)
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", credentialsFile)
+	t.Setenv("AWS_REGION", "")
+	t.Setenv("AWS_PROFILE", "")
+
+	opts := DefaultOptions()
+	opts.Profile = "local"
+	opts.EndpointURL = "http://localhost:4566"
+	cfg, err := LoadConfig(context.Background(), opts)
+	if err != nil {
+		t.Fatalf("LoadConfig: %v", err)
+	}
+	if cfg.Region != "eu-west-3" || aws.ToString(cfg.BaseEndpoint) != "http://localhost:4566" {
+		t.Errorf("unexpected config: region %q, endpoint %q", cfg.Region, aws.ToString(cfg.BaseEndpoint))
+	}
+	creds, err := cfg.Credentials.Retrieve(context.Background())
+	if err != nil || creds.AccessKeyID != "test" {
+		t.Errorf("expected the profile's credentials, got %+v (%v)", creds, err)
+	}
+
+	opts.RoleARN = "arn:aws:iam::111111111111:role/Tagging"
+	if cfg, err = LoadConfig(context.Background(), opts); err != nil {
+		t.Fatalf("LoadConfig: %v", err)
+	}
+	if _, ok := cfg.Credentials.(*aws.CredentialsCache); !ok {
+		t.Errorf("expected assumed role credentials, got %T", cfg.Credentials)
+	}
+
+	opts.Profile = "missing"
+	if _, err := LoadConfig(context.Background(), opts); err == nil {
+		t.Error("expected an unknown profile to be rejected")
+	}
+}
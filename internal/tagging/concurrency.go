@@ -0,0 +1,69 @@
+package tagging
+
+import (
+	"context"
+	"sync"
+)
+
+// regionFunc processes one resource family of a region, recording into rr
+type regionFunc func(ctx context.Context, rr *RegionReport)
+
+// forEachRegion runs the given resource families for every region. Up to
+// Options.Concurrency regions are processed at once, and with more than one
+// worker the families of a region run in parallel too.
+//
+// Each family records into its own buffer, merged into the region report in
+// the order the families were given, so the report reads the same whatever
+// the scheduling.
+func (e *Engine) forEachRegion(ctx context.Context, regions []string, families ...regionFunc) {
+	// Create the region reports up front so they keep the requested order
+	reports := make([]*RegionReport, len(regions))
+	for i, region := range regions {
+		reports[i] = e.report.region(e.account, region)
+	}
+
+	workers := e.opts.Concurrency
+	if workers < 1 {
+		workers = 1
+	}
+
+	sem := make(chan struct{}, workers)
+	var wg sync.WaitGroup
+	for _, rr := range reports {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(rr *RegionReport) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			e.runFamilies(ctx, rr, families, workers > 1)
+			e.flushTags(ctx, rr.Region)
+		}(rr)
+	}
+	wg.Wait()
+}
+
+// runFamilies runs the resource families of one region, in parallel when asked
+func (e *Engine) runFamilies(ctx context.Context, rr *RegionReport, families []regionFunc, parallel bool) {
+	if !parallel || len(families) == 1 {
+		for _, fn := range families {
+			fn(ctx, rr)
+		}
+		return
+	}
+
+	buffers := make([]*RegionReport, len(families))
+	var wg sync.WaitGroup
+	for i, fn := range families {
+		buffers[i] = &RegionReport{Account: rr.Account, Region: rr.Region}
+		wg.Add(1)
+		go func(fn regionFunc, buf *RegionReport) {
+			defer wg.Done()
+			fn(ctx, buf)
+		}(fn, buffers[i])
+	}
+	wg.Wait()
+
+	for _, buf := range buffers {
+		rr.merge(buf)
+	}
+}
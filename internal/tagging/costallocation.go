@@ -0,0 +1,266 @@
+package tagging
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/costexplorer"
+	types2 "github.com/aws/aws-sdk-go-v2/service/costexplorer/types"
+	orgtypes "github.com/aws/aws-sdk-go-v2/service/organizations/types"
+	"github.com/aws/aws-sdk-go-v2/service/resourcegroupstaggingapi"
+)
+
+// costAllocationBatchSize is the most tag keys UpdateCostAllocationTagsStatus
+// accepts in one call
+const costAllocationBatchSize = 20
+
+// CostAllocationKeyError is a tag key Cost Explorer refused to update
+type CostAllocationKeyError struct {
+	Key     string `json:"key"`
+	Code    string `json:"code,omitempty"`
+	Message string `json:"message"`
+}
+
+// runActivate activates the cost allocation tags of the keys found on the
+// resources of any service, as listed by GetTagKeys in the account and in
+// the member accounts of Accounts, selected by IncludeKeys and ExcludeKeys.
+// With Reconcile, the selected keys are the desired set: active
+// user-defined keys outside it are deactivated, and so are those in it that
+// no resource holds anymore, such as the machine keys of renamed instances.
+// Keys are updated in batches of the API limit, and keys Cost Explorer
+// refuses are reported without stopping the others.
+func (e *Engine) runActivate(ctx context.Context, regions []string) error {
+	result := &CostAllocationResult{Eligible: []string{}, Reconcile: e.opts.Reconcile}
+	e.report.CostAllocation = result
+
+	// A key is only held by no resource if every region was searched
+	if e.opts.Reconcile {
+		reason := ""
+		switch {
+		case e.opts.RegionDiscovery != "" && !e.discovered:
+			reason = "the regions could not be discovered, see the warnings"
+		case !e.discovered || len(e.opts.IncludeRegions) > 0 || len(e.opts.ExcludeRegions) > 0:
+			reason = "the tag keys of every region are needed, use --regions auto or all without --include-regions or --exclude-regions"
+		}
+		if reason != "" {
+			result.Error = "cannot reconcile Cost Allocation Tags: " + reason
+			return errors.New(result.Error)
+		}
+	}
+
+	ceClient := e.clients.CostExplorer()
+
+	active, err := listActiveCostAllocationTags(ctx, ceClient)
+	if err != nil {
+		result.Error = err.Error()
+		return fmt.Errorf("cannot list Cost Allocation Tags: %w", err)
+	}
+	activeKeys := make(map[string]bool)
+	for _, tag := range active {
+		activeKeys[aws.ToString(tag.TagKey)] = true
+	}
+	result.ActiveKeys = len(activeKeys)
+
+	// Collect all tag keys from all regions, of every account
+	allKeys := make(map[string]bool)
+	incomplete := e.collectTagKeys(ctx, regions, allKeys)
+	if len(e.opts.Accounts) > 0 {
+		accounts, err := e.memberAccounts(ctx)
+		if err != nil {
+			result.Error = err.Error()
+			return err
+		}
+		for _, account := range accounts {
+			if uerr := e.useAccount(ctx, account.ID); uerr != nil {
+				e.report.Warnings = append(e.report.Warnings, uerr.Error())
+				incomplete++
+				continue
+			}
+			e.report.Accounts = append(e.report.Accounts, account.ID)
+			incomplete += e.collectTagKeys(ctx, regions, allKeys)
+		}
+		if err := e.useAccount(ctx, ""); err != nil {
+			return err
+		}
+	}
+	result.DiscoveredKeys = len(allKeys)
+	if e.opts.Reconcile {
+		if err := e.reconcilable(ctx, incomplete); err != nil {
+			result.Error = "cannot reconcile Cost Allocation Tags: " + err.Error()
+			return errors.New(result.Error)
+		}
+	}
+
+	// Find eligible keys
+	for key := range allKeys {
+		if activeKeys[key] {
+			continue
+		}
+		if e.selectedKey(key) {
+			result.Eligible = append(result.Eligible, key)
+		} else {
+			result.Skipped++
+		}
+	}
+	sort.Strings(result.Eligible)
+
+	if e.opts.Reconcile {
+		for _, tag := range active {
+			key := aws.ToString(tag.TagKey)
+			if tag.Type == types2.CostAllocationTagTypeUserDefined && (!e.selectedKey(key) || !allKeys[key]) {
+				result.Deactivate = append(result.Deactivate, key)
+			}
+		}
+		sort.Strings(result.Deactivate)
+	}
+
+	if !e.opts.Apply {
+		return nil
+	}
+
+	result.Activated, result.Failed = updateCostAllocationTags(ctx, ceClient, result.Eligible, types2.CostAllocationTagStatusActive)
+	var failed []CostAllocationKeyError
+	result.Deactivated, failed = updateCostAllocationTags(ctx, ceClient, result.Deactivate, types2.CostAllocationTagStatusInactive)
+	result.Failed = append(result.Failed, failed...)
+	if n := len(result.Failed); n > 0 {
+		return fmt.Errorf("failed to update %d of %d Cost Allocation Tags", n, len(result.Eligible)+len(result.Deactivate))
+	}
+	return nil
+}
+
+// collectTagKeys adds the tag keys of the current account in regions to
+// keys, and returns the number of regions they could not all be listed in
+func (e *Engine) collectTagKeys(ctx context.Context, regions []string, keys map[string]bool) int {
+	failed := 0
+	for _, region := range regions {
+		rr := e.report.region(e.account, region)
+		paginator := resourcegroupstaggingapi.NewGetTagKeysPaginator(e.clients.Tagging(region), &resourcegroupstaggingapi.GetTagKeysInput{})
+		for paginator.HasMorePages() {
+			page, err := paginator.NextPage(ctx)
+			if err != nil {
+				rr.warn(fmt.Sprintf("Failed to list tag keys: %v", err))
+				failed++
+				break
+			}
+			for _, key := range page.TagKeys {
+				if key != "" {
+					keys[key] = true
+				}
+			}
+		}
+	}
+	return failed
+}
+
+// reconcilable returns why reconcile cannot tell that no resource holds a
+// key: the keys could not be listed in some regions or accounts, or some
+// accounts of the organization were not searched. Accounts outside of an
+// organization have no others to search.
+func (e *Engine) reconcilable(ctx context.Context, incomplete int) error {
+	if incomplete > 0 {
+		return fmt.Errorf("%d tag key listings failed, see the warnings", incomplete)
+	}
+	members, err := e.organizationAccounts(ctx)
+	var notInUse *orgtypes.AWSOrganizationsNotInUseException
+	if err != nil && !errors.As(err, &notInUse) {
+		return err
+	}
+	var missing []string
+	for _, account := range members {
+		if !contains(e.report.Accounts, account.ID) {
+			missing = append(missing, account.ID)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("accounts %s of the organization may hold the keys, search them with --accounts (org for all)", strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// selectedKey reports whether activate works on a key: it is not reserved
+// by AWS, matches IncludeKeys when given, and matches none of ExcludeKeys
+func (e *Engine) selectedKey(key string) bool {
+	if strings.HasPrefix(key, "aws:") {
+		return false
+	}
+	if len(e.opts.IncludeKeys) > 0 && !matchesAnyGlob(key, e.opts.IncludeKeys) {
+		return false
+	}
+	return !matchesAnyGlob(key, e.opts.ExcludeKeys)
+}
+
+// matchesAnyGlob reports whether s matches any of the '*' and '?' patterns
+func matchesAnyGlob(s string, patterns []string) bool {
+	for _, p := range patterns {
+		if globRegexp(p).MatchString(s) {
+			return true
+		}
+	}
+	return false
+}
+
+// listActiveCostAllocationTags returns the active cost allocation tags,
+// reading every page
+func listActiveCostAllocationTags(ctx context.Context, client CostExplorerAPI) ([]types2.CostAllocationTag, error) {
+	active := []types2.CostAllocationTag{}
+	input := &costexplorer.ListCostAllocationTagsInput{Status: types2.CostAllocationTagStatusActive}
+	for {
+		page, err := client.ListCostAllocationTags(ctx, input)
+		if err != nil {
+			return nil, err
+		}
+		active = append(active, page.CostAllocationTags...)
+		if aws.ToString(page.NextToken) == "" {
+			return active, nil
+		}
+		input.NextToken = page.NextToken
+	}
+}
+
+// updateCostAllocationTags sets the status of keys, costAllocationBatchSize
+// at a time. It returns the keys updated and those that failed, either
+// because their batch failed or because Cost Explorer refused them.
+func updateCostAllocationTags(ctx context.Context, client CostExplorerAPI, keys []string, status types2.CostAllocationTagStatus) ([]string, []CostAllocationKeyError) {
+	updated := []string{}
+	failed := []CostAllocationKeyError{}
+	for start := 0; start < len(keys); start += costAllocationBatchSize {
+		batch := keys[start:min(start+costAllocationBatchSize, len(keys))]
+		out, err := client.UpdateCostAllocationTagsStatus(ctx, &costexplorer.UpdateCostAllocationTagsStatusInput{
+			CostAllocationTagsStatus: buildCostAllocationTagStatus(batch, status),
+		})
+		if err != nil {
+			for _, key := range batch {
+				failed = append(failed, CostAllocationKeyError{Key: key, Message: err.Error()})
+			}
+			continue
+		}
+
+		refused := make(map[string]bool)
+		for _, keyErr := range out.Errors {
+			key := aws.ToString(keyErr.TagKey)
+			refused[key] = true
+			failed = append(failed, CostAllocationKeyError{Key: key, Code: aws.ToString(keyErr.Code), Message: aws.ToString(keyErr.Message)})
+		}
+		for _, key := range batch {
+			if !refused[key] {
+				updated = append(updated, key)
+			}
+		}
+	}
+	return updated, failed
+}
+
+func buildCostAllocationTagStatus(keys []string, status types2.CostAllocationTagStatus) []types2.CostAllocationTagStatusEntry {
+	entries := make([]types2.CostAllocationTagStatusEntry, len(keys))
+	for i, key := range keys {
+		entries[i] = types2.CostAllocationTagStatusEntry{
+			TagKey: aws.String(key),
+			Status: status,
+		}
+	}
+	return entries
+}
@@ -2,14 +2,12 @@ package tagging
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"regexp"
 	"strings"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
-	"github.com/aws/aws-sdk-go-v2/config"
-	"github.com/aws/aws-sdk-go-v2/service/costexplorer"
-	types2 "github.com/aws/aws-sdk-go-v2/service/costexplorer/types"
 	"github.com/aws/aws-sdk-go-v2/service/ec2"
 	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
 	"github.com/aws/aws-sdk-go-v2/service/efs"
@@ -30,31 +28,157 @@ var TargetRegions = []string{
 
 // Engine is the main tagging engine
 type Engine struct {
-	opts Options
-	cfg  aws.Config
+	opts    Options
+	clients ClientFactory
+	report  *Report
+	journal *journal
+	policy  *Policy
+	batcher *tagBatcher
+	// excluded are the resource IDs and ARNs of the exclusion file
+	excluded map[string]bool
+
+	// account is the member account clients currently point at, "" for the
+	// account the engine was started with, whose clients are rootClients
+	account        string
+	rootClients    ClientFactory
+	accountClients map[string]ClientFactory
+	// snapshots indexes the snapshots of each region for the whole run
+	snapshots *snapshotIndexes
+	// written are the writes recorded by the journals of FromJournals, the
+	// evidence that a stale machine key was written by the tool
+	written map[string]*journalWrites
+	// discovered is set when the regions are every region DescribeRegions found
+	discovered bool
 }
 
-// NewEngine creates a new tagging engine with the given options
-func NewEngine(opts Options) *Engine {
-	return &Engine{opts: opts}
+// NewEngine creates a new tagging engine with the given options.
+// If clients is nil, AWS clients are built from the default config when Run is called.
+func NewEngine(opts Options, clients ClientFactory) *Engine {
+	return &Engine{opts: opts, clients: clients, batcher: newTagBatcher(), snapshots: newSnapshotIndexes()}
 }
 
-// Run executes the tagging operation based on the configured mode
-func (e *Engine) Run(ctx context.Context) error {
+// Run executes the tagging operation based on the configured mode and
+// returns a report of every resource it planned or tagged. The report is
+// returned even when err is non-nil, as long as processing started.
+func (e *Engine) Run(ctx context.Context) (*Report, error) {
 	// Load AWS config
-	cfg, err := config.LoadDefaultConfig(ctx)
-	if err != nil {
-		return fmt.Errorf("failed to load AWS config: %w", err)
+	if e.clients == nil {
+		cfg, err := LoadConfig(ctx, e.opts)
+		if err != nil {
+			return nil, err
+		}
+		e.clients = NewClientFactory(cfg, e.opts.MaxAttempts)
+	}
+	e.rootClients = e.clients
+	e.accountClients = map[string]ClientFactory{"": e.clients}
+
+	e.report = &Report{Mode: e.opts.Mode, Regions: []*RegionReport{}}
+
+	if e.opts.Journal != "" {
+		j, err := openJournal(e.opts.Journal)
+		if err != nil {
+			return nil, err
+		}
+		defer j.Close()
+		e.journal = j
+		e.report.Journal = e.opts.Journal
+	}
+
+	if e.opts.ExcludeFile != "" {
+		excluded, err := LoadExclusions(e.opts.ExcludeFile)
+		if err != nil {
+			return e.report, err
+		}
+		e.excluded = excluded
+	}
+
+	// Saved plans and journals carry their own regions
+	if e.opts.Mode == ModeApplyPlan || e.opts.Mode == ModeUndo {
+		var err error
+		if e.opts.Mode == ModeApplyPlan {
+			err = e.runApplyPlan(ctx)
+		} else {
+			err = e.runUndo(ctx)
+		}
+		e.flushTags(ctx, "")
+		if err == nil {
+			err = e.journalError()
+		}
+		e.report.Apply = e.opts.Apply
+		e.report.TagStorage = e.opts.TagStorage
+		return e.report, err
+	}
+
+	if e.opts.PolicyFile != "" {
+		policy, err := LoadPolicy(e.opts.PolicyFile)
+		if err != nil {
+			return e.report, err
+		}
+		e.policy = policy
+	}
+
+	if len(e.opts.FromJournals) > 0 && e.opts.Mode != ModeCleanup {
+		written, err := loadJournalWrites(e.opts.FromJournals)
+		if err != nil {
+			return e.report, err
+		}
+		e.written = written
 	}
-	e.cfg = cfg
 
 	// Determine regions to process
 	regions := e.resolveRegions(ctx)
 	if len(regions) == 0 {
-		return fmt.Errorf("no regions to process")
+		return e.report, fmt.Errorf("no regions to process")
 	}
 
-	// Execute based on mode
+	// Execute based on mode, in each member account when given. Activate
+	// only reads the tag keys of member accounts.
+	var err error
+	if len(e.opts.Accounts) > 0 && e.opts.Mode != ModeActivate {
+		err = e.runAccounts(ctx, regions)
+	} else {
+		err = e.runMode(ctx, regions)
+	}
+	if errors.Is(err, errUnknownMode) {
+		return nil, err
+	}
+	e.flushTags(ctx, "")
+	if err == nil && e.opts.Mode == ModeAudit {
+		err = e.summarizeAudit()
+	}
+
+	e.report.Apply = e.opts.Apply
+	e.report.TagStorage = e.opts.TagStorage
+	e.report.FixOrphans = e.opts.FixOrphans
+	if err == nil {
+		err = e.journalError()
+	}
+
+	// Save the dry-run plan for a later apply-plan
+	if err == nil && e.opts.PlanOut != "" {
+		if werr := WritePlan(e.opts.PlanOut, NewPlan(e.report)); werr != nil {
+			err = fmt.Errorf("failed to write plan: %w", werr)
+		}
+	}
+
+	// Save the tags that could not be written, even when others failed too
+	if e.opts.FailedOut != "" && e.opts.Apply {
+		if plan := NewFailedPlan(e.report); len(plan.Changes) > 0 {
+			if werr := WritePlan(e.opts.FailedOut, plan); werr != nil && err == nil {
+				err = fmt.Errorf("failed to write failed plan: %w", werr)
+			} else if werr == nil {
+				e.report.FailedPlan = e.opts.FailedOut
+			}
+		}
+	}
+	return e.report, err
+}
+
+// errUnknownMode is returned for a mode the engine does not implement
+var errUnknownMode = errors.New("unknown mode")
+
+// runMode runs the mode in every region, with the current account's clients
+func (e *Engine) runMode(ctx context.Context, regions []string) error {
 	switch e.opts.Mode {
 	case ModeShow:
 		return e.runShow(ctx, regions)
@@ -74,39 +198,12 @@ func (e *Engine) Run(ctx context.Context) error {
 		return e.runEFSOnly(ctx, regions)
 	case ModeAll, ModeSet, ModeDryRun:
 		return e.runAllResources(ctx, regions)
-	default:
-		return fmt.Errorf("unknown mode: %s", e.opts.Mode)
-	}
-}
-
-// resolveRegions determines which regions to operate on
-func (e *Engine) resolveRegions(ctx context.Context) []string {
-	if len(e.opts.Regions) > 0 {
-		return e.opts.Regions
-	}
-
-	if e.opts.Region != "" {
-		return []string{e.opts.Region}
-	}
-
-	// Use target regions
-	if len(TargetRegions) > 0 {
-		return TargetRegions
-	}
-
-	// Fallback: describe all regions
-	ec2Client := ec2.NewFromConfig(e.cfg)
-	result, err := ec2Client.DescribeRegions(ctx, &ec2.DescribeRegionsInput{})
-	if err != nil {
-		fmt.Printf("[WARN] Failed to describe regions: %v\n", err)
-		return []string{"us-east-1"} // Fallback
-	}
-
-	regions := make([]string, len(result.Regions))
-	for i, r := range result.Regions {
-		regions[i] = aws.ToString(r.RegionName)
+	case ModeAudit:
+		return e.runAudit(ctx, regions)
+	case ModeCleanup:
+		return e.runCleanup(ctx, regions)
 	}
-	return regions
+	return fmt.Errorf("%w: %s", errUnknownMode, e.opts.Mode)
 }
 
 // runShow lists resources without modifying anything
@@ -118,15 +215,10 @@ func (e *Engine) runShow(ctx context.Context, regions []string) error {
 }
 
 func (e *Engine) showRegion(ctx context.Context, region string) {
-	fmt.Printf("\n%s\n", strings.Repeat("=", 80))
-	fmt.Printf("[SHOW] REGION: %s\n", strings.ToUpper(region))
-	fmt.Printf("%s\n", strings.Repeat("=", 80))
-
-	regionCfg := e.cfg.Copy()
-	regionCfg.Region = region
+	rr := e.report.region(e.account, region)
 
 	// EC2 instances
-	ec2Client := ec2.NewFromConfig(regionCfg)
+	ec2Client := e.clients.EC2(region)
 	instances, err := ec2Client.DescribeInstances(ctx, &ec2.DescribeInstancesInput{
 		Filters: []types.Filter{
 			{
@@ -140,153 +232,37 @@ func (e *Engine) showRegion(ctx context.Context, region string) {
 		for _, res := range instances.Reservations {
 			count += len(res.Instances)
 		}
-		fmt.Printf("[EC2] Instances: %d\n", count)
+		rr.Inventory = append(rr.Inventory, InventoryCount{Service: "EC2", Resource: "Instances", Count: count})
+	} else {
+		rr.Inventory = append(rr.Inventory, InventoryCount{Service: "EC2", Resource: "Instances", Error: err.Error()})
 	}
 
 	// EFS
-	efsClient := efs.NewFromConfig(regionCfg)
+	efsClient := e.clients.EFS(region)
 	fsResult, err := efsClient.DescribeFileSystems(ctx, &efs.DescribeFileSystemsInput{})
 	if err == nil {
-		fmt.Printf("[EFS] FileSystems: %d\n", len(fsResult.FileSystems))
+		rr.Inventory = append(rr.Inventory, InventoryCount{Service: "EFS", Resource: "FileSystems", Count: len(fsResult.FileSystems)})
 	} else {
-		fmt.Println("[EFS] Not accessible or no EFS in this region")
+		rr.Inventory = append(rr.Inventory, InventoryCount{Service: "EFS", Resource: "FileSystems", Error: err.Error()})
 	}
 
 	// FSx
-	fsxClient := fsx.NewFromConfig(regionCfg)
+	fsxClient := e.clients.FSx(region)
 	fsxResult, err := fsxClient.DescribeFileSystems(ctx, &fsx.DescribeFileSystemsInput{})
 	if err == nil {
-		fmt.Printf("[FSx] FileSystems: %d\n", len(fsxResult.FileSystems))
+		rr.Inventory = append(rr.Inventory, InventoryCount{Service: "FSx", Resource: "FileSystems", Count: len(fsxResult.FileSystems)})
 	} else {
-		fmt.Println("[FSx] Not accessible or no FSx in this region")
+		rr.Inventory = append(rr.Inventory, InventoryCount{Service: "FSx", Resource: "FileSystems", Error: err.Error()})
 	}
 }
 
-// runActivate activates cost allocation tags
-func (e *Engine) runActivate(ctx context.Context, regions []string) error {
-	mode := "DRY-RUN"
-	if e.opts.Apply {
-		mode = "APPLY"
-	}
-
-	fmt.Printf("\n[COST ALLOCATION TAGS] Activating eligible tag keys\n")
-	fmt.Printf("Regions: %s\n", strings.Join(regions, ", "))
-	fmt.Printf("Mode: %s\n\n", mode)
-
-	ceClient := costexplorer.NewFromConfig(e.cfg)
-
-	// List current cost allocation tags
-	current, err := ceClient.ListCostAllocationTags(ctx, &costexplorer.ListCostAllocationTagsInput{})
-	if err != nil {
-		return fmt.Errorf("cannot list Cost Allocation Tags: %w", err)
-	}
-
-	activeKeys := make(map[string]bool)
-	for _, tag := range current.CostAllocationTags {
-		activeKeys[aws.ToString(tag.TagKey)] = true
-	}
-	fmt.Printf("Currently active Cost Allocation Tags: %d\n", len(activeKeys))
-
-	// Collect all tag keys from all regions
-	allKeys := make(map[string]bool)
-	for _, region := range regions {
-		fmt.Printf("  Scanning region %s...\n", strings.ToUpper(region))
-		regionCfg := e.cfg.Copy()
-		regionCfg.Region = region
-		ec2Client := ec2.NewFromConfig(regionCfg)
-
-		result, err := ec2Client.DescribeTags(ctx, &ec2.DescribeTagsInput{})
-		if err == nil {
-			for _, tag := range result.Tags {
-				key := aws.ToString(tag.Key)
-				if key != "" {
-					allKeys[key] = true
-				}
-			}
-		}
-	}
-
-	// Find eligible keys
-	eligible := []string{}
-	for key := range allKeys {
-		if !activeKeys[key] {
-			eligible = append(eligible, key)
-		}
-	}
-
-	fmt.Printf("\nFound %d unique tag keys → %d eligible for activation\n", len(allKeys), len(eligible))
-
-	if len(eligible) == 0 {
-		fmt.Println("No new Cost Allocation Tags to activate.")
-		return nil
-	}
-
-	fmt.Println("\nTag keys to activate:")
-	status := "PLAN"
-	if e.opts.Apply {
-		status = "APPLY"
-	}
-	for _, key := range eligible {
-		fmt.Printf("    [%s] %s\n", status, key)
-	}
-
-	if !e.opts.Apply {
-		fmt.Println("\nDRY-RUN: No changes made. Use --apply to activate.")
-		return nil
-	}
-
-	// Activate tags
-	_, err = ceClient.UpdateCostAllocationTagsStatus(ctx, &costexplorer.UpdateCostAllocationTagsStatusInput{
-		CostAllocationTagsStatus: buildCostAllocationTagStatus(eligible),
-	})
-	if err != nil {
-		return fmt.Errorf("failed to activate Cost Allocation Tags: %w", err)
-	}
-
-	fmt.Printf("\nSUCCESS: %d Cost Allocation Tags activated!\n", len(eligible))
-	fmt.Println("Cost Explorer will reflect these tags within 24-48 hours.")
-	return nil
-}
-
-func buildCostAllocationTagStatus(keys []string) []types2.CostAllocationTagStatusEntry {
-	entries := make([]types2.CostAllocationTagStatusEntry, len(keys))
-	for i, key := range keys {
-		entries[i] = types2.CostAllocationTagStatusEntry{
-			TagKey: aws.String(key),
-			Status: types2.CostAllocationTagStatusActive,
-		}
-	}
-	return entries
-}
-
 // runAllResources processes EC2 instances and optionally storage resources
 func (e *Engine) runAllResources(ctx context.Context, regions []string) error {
-	mode := "DRY-RUN MODE"
-	if e.opts.Apply {
-		mode = "APPLY MODE – REAL CHANGES!"
-	}
-
-	fmt.Printf("\n%s\n", mode)
-	fmt.Printf("Action: %s\n", e.opts.Mode)
-	fmt.Printf("Target regions: %s\n\n", strings.Join(regions, ", "))
-
-	for _, region := range regions {
-		if e.opts.FixOrphans {
-			e.fixOrphanedSnapshots(ctx, region)
-		} else {
-			e.processRegion(ctx, region)
-		}
-	}
-
-	fmt.Printf("\n%s\n", strings.Repeat("═", 80))
-	fmt.Println("TAG PROPAGATION COMPLETED!")
-	if e.opts.TagStorage {
-		fmt.Println("EC2 + EFS + FSx resources were processed.")
-	} else {
-		fmt.Println("EC2 resources were processed. Use --tag-storage to include EFS/FSx.")
+	if e.opts.FixOrphans {
+		e.forEachRegion(ctx, regions, e.fixOrphanedSnapshots)
+		return nil
 	}
-	fmt.Printf("%s\n", strings.Repeat("═", 80))
-
+	e.forEachRegion(ctx, regions, e.regionFamilies()...)
 	return nil
 }
 
@@ -301,94 +277,154 @@ func (e *Engine) runEC2(ctx context.Context, regions []string) error {
 
 // runEBS processes only EBS volumes and snapshots
 func (e *Engine) runEBS(ctx context.Context, regions []string) error {
-	for _, region := range regions {
-		e.processAllVolumes(ctx, region)
-		e.processAllSnapshots(ctx, region)
-	}
+	e.forEachRegion(ctx, regions, e.processAllVolumes, e.processAllSnapshots)
 	return nil
 }
 
 // runVolumes processes only EBS volumes
 func (e *Engine) runVolumes(ctx context.Context, regions []string) error {
-	for _, region := range regions {
-		e.processAllVolumes(ctx, region)
-	}
+	e.forEachRegion(ctx, regions, e.processAllVolumes)
 	return nil
 }
 
 // runSnapshots processes only EBS snapshots
 func (e *Engine) runSnapshots(ctx context.Context, regions []string) error {
-	for _, region := range regions {
-		e.processAllSnapshots(ctx, region)
-	}
+	e.forEachRegion(ctx, regions, e.processAllSnapshots)
 	return nil
 }
 
 // runFSx processes only FSx resources
 func (e *Engine) runFSx(ctx context.Context, regions []string) error {
-	for _, region := range regions {
-		e.processFSx(ctx, region)
-	}
+	e.forEachRegion(ctx, regions, e.processFSx)
 	return nil
 }
 
 // runEFSOnly processes only EFS resources
 func (e *Engine) runEFSOnly(ctx context.Context, regions []string) error {
-	for _, region := range regions {
-		e.processEFS(ctx, region)
-	}
+	e.forEachRegion(ctx, regions, e.processEFS)
 	return nil
 }
 
-// processRegion processes all resources in a single region
-func (e *Engine) processRegion(ctx context.Context, region string) {
-	mode := "DRY-RUN"
-	if e.opts.Apply {
-		mode = "APPLY"
+// regionFamilies returns the resource families processed for a region:
+// EC2 instances, plus EFS and FSx with --tag-storage
+func (e *Engine) regionFamilies() []regionFunc {
+	families := []regionFunc{e.processInstances}
+	if e.opts.TagStorage {
+		families = append(families, e.processEFS, e.processFSx)
 	}
+	return families
+}
 
-	fmt.Printf("\n%s\n", strings.Repeat("=", 80))
-	fmt.Printf("REGION: %s | Mode: %s\n", strings.ToUpper(region), mode)
-	fmt.Printf("%s\n", strings.Repeat("=", 80))
-
-	regionCfg := e.cfg.Copy()
-	regionCfg.Region = region
-
-	ec2Client := ec2.NewFromConfig(regionCfg)
+// processInstances processes the EC2 instances of a region, with their volumes and snapshots
+func (e *Engine) processInstances(ctx context.Context, rr *RegionReport) {
+	region := rr.Region
+	ec2Client := e.clients.EC2(region)
 
-	// Process EC2 instances
-	result, err := ec2Client.DescribeInstances(ctx, &ec2.DescribeInstancesInput{
-		Filters: []types.Filter{
+	// List the selected instances, then read the tags of all their volumes at once
+	instances := []types.Instance{}
+	paginator := ec2.NewDescribeInstancesPaginator(ec2Client, &ec2.DescribeInstancesInput{
+		Filters: append([]types.Filter{
 			{
 				Name:   aws.String("instance-state-name"),
 				Values: []string{"running", "stopped"},
 			},
-		},
+		}, e.ec2Filters("instance-id")...),
 	})
-	if err != nil {
-		fmt.Printf("[ERROR] Failed to describe instances in %s: %v\n", region, err)
-		return
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			rr.warn(fmt.Sprintf("Failed to describe instances in %s: %v", region, err))
+			return
+		}
+		for _, reservation := range page.Reservations {
+			for _, instance := range reservation.Instances {
+				if e.selected(ec2TagMap(instance.Tags), aws.ToString(instance.InstanceId)) {
+					instances = append(instances, instance)
+				}
+			}
+		}
 	}
 
-	count := 0
-	for _, reservation := range result.Reservations {
-		for _, instance := range reservation.Instances {
-			e.processInstance(ctx, ec2Client, instance)
-			count++
+	var volumes map[string]volumeRead
+	if e.opts.TagVolumes {
+		volumeIDs := []string{}
+		for _, instance := range instances {
+			volumeIDs = append(volumeIDs, instanceVolumeIDs(instance)...)
 		}
+		volumes = readVolumeTags(ctx, ec2Client, volumeIDs)
 	}
 
-	fmt.Printf("[SUMMARY] %s → %d instances processed\n", region, count)
+	for _, instance := range instances {
+		e.processInstance(ctx, ec2Client, rr, instance, volumes)
+	}
+	if e.opts.TagSnapshots {
+		e.tagDetachedSnapshots(ctx, ec2Client, rr, instances)
+	}
+	e.migrateStaleKeys(ctx, rr)
+}
 
-	// Process storage if requested
-	if e.opts.TagStorage {
-		e.processEFS(ctx, region)
-		e.processFSx(ctx, region)
+// describeVolumesBatchSize is the most volume IDs read in one DescribeVolumes
+// call, the limit of values in an EC2 filter
+const describeVolumesBatchSize = 200
+
+// volumeRead is the result of reading the tags of one volume
+type volumeRead struct {
+	tags map[string]string
+	err  error
+}
+
+// readVolumeTags reads the tags of volumes in batches. Volumes are matched
+// with a filter rather than by ID, so that a volume deleted since the
+// instances were listed is left out instead of failing its whole batch.
+func readVolumeTags(ctx context.Context, client EC2API, volumeIDs []string) map[string]volumeRead {
+	volumes := make(map[string]volumeRead)
+	for start := 0; start < len(volumeIDs); start += describeVolumesBatchSize {
+		batch := volumeIDs[start:min(start+describeVolumesBatchSize, len(volumeIDs))]
+		paginator := ec2.NewDescribeVolumesPaginator(client, &ec2.DescribeVolumesInput{
+			Filters: []types.Filter{
+				{Name: aws.String("volume-id"), Values: batch},
+			},
+		})
+		for paginator.HasMorePages() {
+			page, err := paginator.NextPage(ctx)
+			if err != nil {
+				for _, id := range batch {
+					if _, ok := volumes[id]; !ok {
+						volumes[id] = volumeRead{err: err}
+					}
+				}
+				break
+			}
+			for _, volume := range page.Volumes {
+				volumes[aws.ToString(volume.VolumeId)] = volumeRead{tags: ec2TagMap(volume.Tags)}
+			}
+		}
+	}
+	return volumes
+}
+
+// instanceVolumeIDs returns the EBS volumes attached to an instance
+func instanceVolumeIDs(instance types.Instance) []string {
+	ids := []string{}
+	for _, mapping := range instance.BlockDeviceMappings {
+		if mapping.Ebs != nil && mapping.Ebs.VolumeId != nil {
+			ids = append(ids, aws.ToString(mapping.Ebs.VolumeId))
+		}
+	}
+	return ids
+}
+
+// ec2TagMap converts EC2 tags to a map
+func ec2TagMap(tags []types.Tag) map[string]string {
+	m := make(map[string]string, len(tags))
+	for _, tag := range tags {
+		m[aws.ToString(tag.Key)] = aws.ToString(tag.Value)
 	}
+	return m
 }
 
 // processInstance processes a single EC2 instance and its volumes/snapshots
-func (e *Engine) processInstance(ctx context.Context, client *ec2.Client, instance types.Instance) {
+func (e *Engine) processInstance(ctx context.Context, client EC2API, rr *RegionReport, instance types.Instance, volumes map[string]volumeRead) {
 	if instance.State.Name == types.InstanceStateNameTerminated {
 		return
 	}
@@ -399,34 +435,33 @@ func (e *Engine) processInstance(ctx context.Context, client *ec2.Client, instan
 		nameValue = aws.ToString(instance.InstanceId)
 	}
 
-	display := nameValue
-	if nameValue != aws.ToString(instance.InstanceId) {
-		display = fmt.Sprintf("%s (%s)", nameValue, aws.ToString(instance.InstanceId))
+	currentTags := make(map[string]string)
+	for _, tag := range instance.Tags {
+		currentTags[aws.ToString(tag.Key)] = aws.ToString(tag.Value)
 	}
 
-	fmt.Printf("\n[PROCESSING] %s → Using tag key: '%s'\n", display, machineKey)
+	res := rr.add("EC2 Instance", aws.ToString(instance.InstanceId), currentTags)
+	res.Name = nameValue
+	res.MachineKey = machineKey
+	stale := e.staleMachineKeys(currentTags, rr.Region, machineKey, aws.ToString(instance.InstanceId))
+	res.StaleKeys, res.PossiblyStaleKeys = e.splitStaleKeys(rr, stale, res.ID)
 
 	// Tag instance itself
 	if e.opts.TagInstances {
-		currentTags := make(map[string]string)
-		for _, tag := range instance.Tags {
-			currentTags[aws.ToString(tag.Key)] = aws.ToString(tag.Value)
-		}
-
 		tagsToAdd := []types.Tag{}
 		if _, exists := currentTags["Name"]; !exists {
 			tagsToAdd = append(tagsToAdd, types.Tag{Key: aws.String("Name"), Value: aws.String(nameValue)})
 		}
-		if _, exists := currentTags[machineKey]; !exists {
-			tagsToAdd = append(tagsToAdd, types.Tag{Key: aws.String(machineKey), Value: aws.String("")})
+		if value, ok := e.machineKeyTag(currentTags, rr.Region, machineKey, nameValue, aws.ToString(instance.InstanceId)); ok {
+			tagsToAdd = append(tagsToAdd, types.Tag{Key: aws.String(machineKey), Value: aws.String(value)})
 		}
 
-		e.planOrApply(ctx, client, aws.ToString(instance.InstanceId), tagsToAdd, "EC2 Instance")
+		e.planOrApply(ctx, client, res, tagsToAdd)
 	}
 
 	// Tag volumes and snapshots
 	if e.opts.TagVolumes || e.opts.TagSnapshots {
-		e.tagVolumesAndSnapshots(ctx, client, instance, machineKey, nameValue)
+		e.tagVolumesAndSnapshots(ctx, client, rr, instance, volumes, machineKey, nameValue, e.propagatedTags(instance), stale)
 	}
 }
 
@@ -462,18 +497,22 @@ func normalizeKey(s string) string {
 	return strings.ReplaceAll(s, " ", "-")
 }
 
-// tagVolumesAndSnapshots tags volumes and snapshots associated with an instance
-func (e *Engine) tagVolumesAndSnapshots(ctx context.Context, client *ec2.Client, instance types.Instance, machineKey, nameValue string) {
-	volumeIDs := []string{}
-
-	// Collect volume IDs
-	for _, mapping := range instance.BlockDeviceMappings {
-		if mapping.Ebs != nil && mapping.Ebs.VolumeId != nil {
-			volID := aws.ToString(mapping.Ebs.VolumeId)
-			volumeIDs = append(volumeIDs, volID)
-
-			if e.opts.TagVolumes {
-				e.processResource(ctx, client, volID, machineKey, nameValue, "Volume")
+// tagVolumesAndSnapshots tags volumes and snapshots associated with an
+// instance. stale are the instance's stale machine keys, looked for on them.
+func (e *Engine) tagVolumesAndSnapshots(ctx context.Context, client EC2API, rr *RegionReport, instance types.Instance, volumes map[string]volumeRead, machineKey, nameValue string, propagated []types.Tag, stale []string) {
+	instanceID := aws.ToString(instance.InstanceId)
+	volumeIDs := instanceVolumeIDs(instance)
+
+	if e.opts.TagVolumes {
+		for _, volID := range volumeIDs {
+			volume, ok := volumes[volID]
+			switch {
+			case !ok:
+				// Deleted since the instance was listed
+			case volume.err != nil:
+				e.readFailed(rr, "Volume", volID, volume.err)
+			default:
+				e.processResource(ctx, client, rr, volID, volume.tags, machineKey, nameValue, instanceID, "Volume", propagated, stale)
 			}
 		}
 	}
@@ -482,151 +521,142 @@ func (e *Engine) tagVolumesAndSnapshots(ctx context.Context, client *ec2.Client,
 		return
 	}
 
-	// Tag snapshots from volumes
-	if len(volumeIDs) > 0 {
-		paginator := ec2.NewDescribeSnapshotsPaginator(client, &ec2.DescribeSnapshotsInput{
-			OwnerIds: []string{"self"},
-			Filters: []types.Filter{
-				{Name: aws.String("volume-id"), Values: volumeIDs},
-			},
-		})
-
-		for paginator.HasMorePages() {
-			page, err := paginator.NextPage(ctx)
-			if err != nil {
-				fmt.Printf("    [WARN] Failed to describe snapshots: %v\n", err)
-				break
-			}
-			for _, snapshot := range page.Snapshots {
-				e.processResource(ctx, client, aws.ToString(snapshot.SnapshotId), machineKey, nameValue, "Snapshot")
-			}
+	// Tag snapshots of the volumes, then the AMIs created from the instance
+	// with the snapshots backing them. A snapshot can match several lookups,
+	// and is only processed once.
+	snapshots := e.snapshotIndex(ctx, client, rr)
+	images := e.imageIndex(ctx, client, rr)
+	seen := make(map[string]bool)
+	tagSnapshot := func(snapshot types.Snapshot) {
+		snapshotID := aws.ToString(snapshot.SnapshotId)
+		if seen[snapshotID] {
+			return
 		}
+		seen[snapshotID] = true
+		e.processResource(ctx, client, rr, snapshotID, ec2TagMap(snapshot.Tags), machineKey, nameValue, instanceID, "Snapshot", propagated, stale)
 	}
 
-	// Tag AMI snapshots (by instance ID in description)
-	instanceID := aws.ToString(instance.InstanceId)
-	paginator := ec2.NewDescribeSnapshotsPaginator(client, &ec2.DescribeSnapshotsInput{
-		OwnerIds: []string{"self"},
-	})
-
-	for paginator.HasMorePages() {
-		page, err := paginator.NextPage(ctx)
-		if err != nil {
-			break
-		}
-		for _, snapshot := range page.Snapshots {
-			desc := aws.ToString(snapshot.Description)
-			if strings.Contains(desc, instanceID) {
-				e.processResource(ctx, client, aws.ToString(snapshot.SnapshotId), machineKey, nameValue, "Snapshot")
+	for _, snapshot := range snapshots.ofVolumes(volumeIDs) {
+		tagSnapshot(snapshot)
+	}
+	for _, image := range images.ofInstance(instanceID) {
+		e.processResource(ctx, client, rr, aws.ToString(image.ImageId), ec2TagMap(image.Tags), machineKey, nameValue, instanceID, "AMI", propagated, stale)
+		for _, snapshotID := range imageSnapshotIDs(image) {
+			if snapshot, owned := snapshots.get(snapshotID); owned {
+				tagSnapshot(snapshot)
 			}
 		}
 	}
-}
-
-// processResource processes a single EC2 resource (volume or snapshot)
-func (e *Engine) processResource(ctx context.Context, client *ec2.Client, resourceID, machineKey, nameValue, resourceType string) {
-	var currentTags map[string]string
 
-	if resourceType == "Volume" {
-		result, err := client.DescribeVolumes(ctx, &ec2.DescribeVolumesInput{
-			VolumeIds: []string{resourceID},
-		})
-		if err != nil || len(result.Volumes) == 0 {
-			return
-		}
-		currentTags = make(map[string]string)
-		for _, tag := range result.Volumes[0].Tags {
-			currentTags[aws.ToString(tag.Key)] = aws.ToString(tag.Value)
-		}
-	} else {
-		result, err := client.DescribeSnapshots(ctx, &ec2.DescribeSnapshotsInput{
-			SnapshotIds: []string{resourceID},
-		})
-		if err != nil || len(result.Snapshots) == 0 {
-			return
-		}
-		currentTags = make(map[string]string)
-		for _, tag := range result.Snapshots[0].Tags {
-			currentTags[aws.ToString(tag.Key)] = aws.ToString(tag.Value)
+	// Snapshots left behind by a deregistered AMI are only known by their
+	// description. Those backing a registered AMI belong to its source
+	// instance, which may be another one for copies.
+	for _, snapshot := range snapshots.ofInstance(instanceID) {
+		if _, registered := images.ofSnapshot(aws.ToString(snapshot.SnapshotId)); !registered {
+			tagSnapshot(snapshot)
 		}
 	}
+}
 
+// processResource processes a single EC2 resource of an instance (volume,
+// snapshot or AMI) whose current tags were read in bulk, copying the
+// propagated instance tags onto it and recording the stale machine keys
+// of the instance it holds
+func (e *Engine) processResource(ctx context.Context, client EC2API, rr *RegionReport, resourceID string, currentTags map[string]string, machineKey, nameValue, instanceID, resourceType string, propagated []types.Tag, stale []string) {
 	tagsToAdd := []types.Tag{}
 	if _, exists := currentTags["Name"]; !exists {
 		tagsToAdd = append(tagsToAdd, types.Tag{Key: aws.String("Name"), Value: aws.String(nameValue)})
 	}
-	if _, exists := currentTags[machineKey]; !exists {
-		tagsToAdd = append(tagsToAdd, types.Tag{Key: aws.String(machineKey), Value: aws.String("")})
+	if value, ok := e.machineKeyTag(currentTags, rr.Region, machineKey, nameValue, instanceID); ok {
+		tagsToAdd = append(tagsToAdd, types.Tag{Key: aws.String(machineKey), Value: aws.String(value)})
+	}
+
+	res := rr.add(resourceType, resourceID, currentTags)
+	found := []string{}
+	for _, key := range stale {
+		if value, ok := currentTags[key]; ok && e.isMachineKeyValue(value, rr.Region, key, instanceID) {
+			found = append(found, key)
+		}
+	}
+	res.StaleKeys, res.PossiblyStaleKeys = e.splitStaleKeys(rr, found, resourceID, instanceID)
+	tagsToAdd, err := e.addPropagatedTags(rr, res, propagated, tagsToAdd)
+	if err != nil {
+		res.Error = err.Error()
+		return
 	}
+	e.planOrApply(ctx, client, res, tagsToAdd)
+}
 
-	e.planOrApply(ctx, client, resourceID, tagsToAdd, resourceType)
+// readFailed records a resource whose current tags could not be read. It
+// is not tagged, and is reported as failed so it can be re-run.
+func (e *Engine) readFailed(rr *RegionReport, resourceType, id string, err error) {
+	res := rr.add(resourceType, id, nil)
+	res.Error = fmt.Sprintf("cannot read tags: %v", err)
 }
 
-// planOrApply either plans or applies tags to a resource
-func (e *Engine) planOrApply(ctx context.Context, client *ec2.Client, resourceID string, tags []types.Tag, resourceType string) {
-	if len(tags) == 0 {
+// planOrApply either plans tags on a resource or queues them for a batched
+// CreateTags; results are set on res once the batch is flushed
+func (e *Engine) planOrApply(ctx context.Context, client EC2API, res *ResourceResult, tags []types.Tag) {
+	// Audit mode only reads tags
+	if e.opts.Mode == ModeAudit {
 		return
 	}
-
-	action := "PLAN"
-	if e.opts.Apply {
-		action = "APPLY"
+	// Protected resources are reported, never changed
+	if e.skipProtected(res) {
+		return
 	}
 
 	for _, tag := range tags {
-		value := aws.ToString(tag.Value)
-		if value == "" {
-			value = "(empty)"
-		}
-		fmt.Printf("    [%s] %s %s → %s = %s\n", action, resourceType, resourceID, aws.ToString(tag.Key), value)
+		res.PlannedTags = append(res.PlannedTags, Tag{Key: aws.ToString(tag.Key), Value: aws.ToString(tag.Value)})
+	}
+	for _, tag := range e.policyTags(res) {
+		res.PlannedTags = append(res.PlannedTags, tag)
+		tags = append(tags, types.Tag{Key: aws.String(tag.Key), Value: aws.String(tag.Value)})
 	}
 
-	if !e.opts.Apply {
+	if len(tags) == 0 {
 		return
 	}
 
-	_, err := client.CreateTags(ctx, &ec2.CreateTagsInput{
-		Resources: []string{resourceID},
-		Tags:      tags,
-	})
-	if err != nil {
-		fmt.Printf("    [ERROR] %s %s: %v\n", resourceType, resourceID, err)
+	if !e.opts.Apply {
+		return
 	}
-}
 
-// processAllVolumes processes all EBS volumes in a region
-func (e *Engine) processAllVolumes(ctx context.Context, region string) {
-	mode := "DRY-RUN"
-	if e.opts.Apply {
-		mode = "APPLY"
+	if err := e.journalError(); err != nil {
+		res.Error = err.Error()
+		return
 	}
 
-	fmt.Printf("\n%s\n", strings.Repeat("=", 80))
-	fmt.Printf("REGION: %s | Mode: %s\n", strings.ToUpper(region), mode)
-	fmt.Printf("%s\n", strings.Repeat("=", 80))
+	// Written in batches with other resources getting the same tags
+	e.queueTags(ctx, client, res, tags)
+}
 
-	regionCfg := e.cfg.Copy()
-	regionCfg.Region = region
-	client := ec2.NewFromConfig(regionCfg)
+// processAllVolumes processes all EBS volumes in a region
+func (e *Engine) processAllVolumes(ctx context.Context, rr *RegionReport) {
+	region := rr.Region
+	client := e.clients.EC2(region)
 
-	fmt.Println("\n[VOLUMES MODE] Processing all EBS volumes...")
-	paginator := ec2.NewDescribeVolumesPaginator(client, &ec2.DescribeVolumesInput{})
+	paginator := ec2.NewDescribeVolumesPaginator(client, &ec2.DescribeVolumesInput{
+		Filters: e.ec2Filters("volume-id"),
+	})
 
-	count := 0
 	for paginator.HasMorePages() {
 		page, err := paginator.NextPage(ctx)
 		if err != nil {
-			fmt.Printf("[ERROR] Failed to describe volumes: %v\n", err)
+			rr.warn(fmt.Sprintf("Failed to describe volumes: %v", err))
 			break
 		}
 
 		for _, volume := range page.Volumes {
 			volumeID := aws.ToString(volume.VolumeId)
-			
+
 			currentTags := make(map[string]string)
 			for _, tag := range volume.Tags {
 				currentTags[aws.ToString(tag.Key)] = aws.ToString(tag.Value)
 			}
+			if !e.selected(currentTags, volumeID) {
+				continue
+			}
 
 			tagsToAdd := []types.Tag{}
 			nameValue := currentTags["Name"]
@@ -639,53 +669,43 @@ func (e *Engine) processAllVolumes(ctx context.Context, region string) {
 			if machineKey == "" {
 				machineKey = volumeID
 			}
-			if _, exists := currentTags[machineKey]; !exists {
-				tagsToAdd = append(tagsToAdd, types.Tag{Key: aws.String(machineKey), Value: aws.String("")})
+			if value, ok := e.machineKeyTag(currentTags, region, machineKey, nameValue, volumeID); ok {
+				tagsToAdd = append(tagsToAdd, types.Tag{Key: aws.String(machineKey), Value: aws.String(value)})
 			}
 
-			e.planOrApply(ctx, client, volumeID, tagsToAdd, "Volume")
-			count++
+			res := rr.add("Volume", volumeID, currentTags)
+			e.planOrApply(ctx, client, res, tagsToAdd)
 		}
 	}
-
-	fmt.Printf("\n[SUMMARY] %s → %d volumes processed\n", region, count)
 }
 
 // processAllSnapshots processes all EBS snapshots in a region
-func (e *Engine) processAllSnapshots(ctx context.Context, region string) {
-	mode := "DRY-RUN"
-	if e.opts.Apply {
-		mode = "APPLY"
-	}
+func (e *Engine) processAllSnapshots(ctx context.Context, rr *RegionReport) {
+	region := rr.Region
+	client := e.clients.EC2(region)
 
-	fmt.Printf("\n%s\n", strings.Repeat("=", 80))
-	fmt.Printf("REGION: %s | Mode: %s\n", strings.ToUpper(region), mode)
-	fmt.Printf("%s\n", strings.Repeat("=", 80))
-
-	regionCfg := e.cfg.Copy()
-	regionCfg.Region = region
-	client := ec2.NewFromConfig(regionCfg)
-
-	fmt.Println("\n[SNAPSHOTS MODE] Processing all EBS snapshots...")
 	paginator := ec2.NewDescribeSnapshotsPaginator(client, &ec2.DescribeSnapshotsInput{
 		OwnerIds: []string{"self"},
+		Filters:  e.ec2Filters("snapshot-id"),
 	})
 
-	count := 0
 	for paginator.HasMorePages() {
 		page, err := paginator.NextPage(ctx)
 		if err != nil {
-			fmt.Printf("[ERROR] Failed to describe snapshots: %v\n", err)
+			rr.warn(fmt.Sprintf("Failed to describe snapshots: %v", err))
 			break
 		}
 
 		for _, snapshot := range page.Snapshots {
 			snapshotID := aws.ToString(snapshot.SnapshotId)
-			
+
 			currentTags := make(map[string]string)
 			for _, tag := range snapshot.Tags {
 				currentTags[aws.ToString(tag.Key)] = aws.ToString(tag.Value)
 			}
+			if !e.selected(currentTags, snapshotID) {
+				continue
+			}
 
 			tagsToAdd := []types.Tag{}
 			nameValue := currentTags["Name"]
@@ -698,87 +718,76 @@ func (e *Engine) processAllSnapshots(ctx context.Context, region string) {
 			if machineKey == "" {
 				machineKey = snapshotID
 			}
-			if _, exists := currentTags[machineKey]; !exists {
-				tagsToAdd = append(tagsToAdd, types.Tag{Key: aws.String(machineKey), Value: aws.String("")})
+			if value, ok := e.machineKeyTag(currentTags, region, machineKey, nameValue, snapshotID); ok {
+				tagsToAdd = append(tagsToAdd, types.Tag{Key: aws.String(machineKey), Value: aws.String(value)})
 			}
 
-			e.planOrApply(ctx, client, snapshotID, tagsToAdd, "Snapshot")
-			count++
+			res := rr.add("Snapshot", snapshotID, currentTags)
+			e.planOrApply(ctx, client, res, tagsToAdd)
 		}
 	}
-
-	fmt.Printf("\n[SUMMARY] %s → %d snapshots processed\n", region, count)
 }
 
-// fixOrphanedSnapshots fixes orphaned AMI snapshots
-func (e *Engine) fixOrphanedSnapshots(ctx context.Context, region string) {
-	fmt.Println("\n[ORPHAN MODE] Fixing orphaned AMI snapshots that have no Name tag...")
-	
-	regionCfg := e.cfg.Copy()
-	regionCfg.Region = region
-	client := ec2.NewFromConfig(regionCfg)
-
-	paginator := ec2.NewDescribeSnapshotsPaginator(client, &ec2.DescribeSnapshotsInput{
-		OwnerIds: []string{"self"},
-	})
+// fixOrphanedSnapshots names the AMI snapshots that have no Name tag after
+// the AMI they back. Snapshots of deregistered AMIs, only recognisable by
+// their CreateImage description, get a generic name.
+func (e *Engine) fixOrphanedSnapshots(ctx context.Context, rr *RegionReport) {
+	client := e.clients.EC2(rr.Region)
+	images := e.imageIndex(ctx, client, rr)
 
-	fixed := 0
-	for paginator.HasMorePages() {
-		page, err := paginator.NextPage(ctx)
-		if err != nil {
-			fmt.Printf("[ERROR] Failed to describe snapshots: %v\n", err)
-			break
+	for _, snapshot := range e.snapshotIndex(ctx, client, rr).snapshots {
+		snapshotID := aws.ToString(snapshot.SnapshotId)
+		currentTags := make(map[string]string)
+		for _, tag := range snapshot.Tags {
+			currentTags[aws.ToString(tag.Key)] = aws.ToString(tag.Value)
+		}
+		if _, hasName := currentTags["Name"]; hasName || !e.selected(currentTags, snapshotID) {
+			continue
 		}
 
-		for _, snapshot := range page.Snapshots {
-			hasName := false
-			for _, tag := range snapshot.Tags {
-				if aws.ToString(tag.Key) == "Name" {
-					hasName = true
-					break
-				}
-			}
+		nameValue := ""
+		image, registered := images.ofSnapshot(snapshotID)
+		switch {
+		case registered && aws.ToString(image.Name) != "":
+			nameValue = aws.ToString(image.Name)
+		case registered || strings.Contains(aws.ToString(snapshot.Description), "Created by CreateImage"):
+			nameValue = fmt.Sprintf("AMI-Snapshot-%s", snapshotID)
+		default:
+			continue
+		}
 
-			if !hasName {
-				desc := aws.ToString(snapshot.Description)
-				if strings.Contains(desc, "Created by CreateImage") {
-					nameValue := fmt.Sprintf("AMI-Snapshot-%s", aws.ToString(snapshot.SnapshotId))
-					tags := []types.Tag{
-						{Key: aws.String("Name"), Value: aws.String(nameValue)},
-					}
-					e.planOrApply(ctx, client, aws.ToString(snapshot.SnapshotId), tags, "Orphaned Snapshot")
-					fixed++
-				}
-			}
+		tags := []types.Tag{
+			{Key: aws.String("Name"), Value: aws.String(nameValue)},
 		}
+		res := rr.add("Orphaned Snapshot", snapshotID, currentTags)
+		e.planOrApply(ctx, client, res, tags)
 	}
-
-	fmt.Printf("\n[ORPHAN MODE] Completed → %d orphaned AMI snapshots fixed!\n", fixed)
 }
 
 // processEFS processes EFS resources in a region
-func (e *Engine) processEFS(ctx context.Context, region string) {
-	mode := "DRY-RUN"
-	if e.opts.Apply {
-		mode = "APPLY"
-	}
-
-	fmt.Printf("\n[EFS] Processing EFS resources in %s (%s)\n", strings.ToUpper(region), mode)
-
-	regionCfg := e.cfg.Copy()
-	regionCfg.Region = region
-	client := efs.NewFromConfig(regionCfg)
+func (e *Engine) processEFS(ctx context.Context, rr *RegionReport) {
+	region := rr.Region
+	client := e.clients.EFS(region)
 
 	// File Systems
-	fsResult, err := client.DescribeFileSystems(ctx, &efs.DescribeFileSystemsInput{})
-	if err != nil {
-		fmt.Printf("[WARN] Failed to describe EFS file systems: %v\n", err)
-		return
+	var fileSystems []efstypes.FileSystemDescription
+	fsPages := efs.NewDescribeFileSystemsPaginator(client, &efs.DescribeFileSystemsInput{})
+	for fsPages.HasMorePages() {
+		page, err := fsPages.NextPage(ctx)
+		if err != nil {
+			rr.warn(fmt.Sprintf("Failed to describe EFS file systems: %v", err))
+			break
+		}
+		fileSystems = append(fileSystems, page.FileSystems...)
 	}
 
-	for _, fs := range fsResult.FileSystems {
+	for _, fs := range fileSystems {
 		fsID := aws.ToString(fs.FileSystemId)
-		currentTags := e.getCurrentTagsEFS(ctx, client, fsID)
+		currentTags, err := e.listTagsEFS(ctx, client, fsID)
+		if err != nil {
+			e.readFailed(rr, "EFS FileSystem", fsID, err)
+			continue
+		}
 
 		nameValue := currentTags["Name"]
 		if nameValue == "" {
@@ -797,121 +806,153 @@ func (e *Engine) processEFS(ctx context.Context, region string) {
 		if _, exists := currentTags["Name"]; !exists {
 			tagsToAdd = append(tagsToAdd, efstypes.Tag{Key: aws.String("Name"), Value: aws.String(nameValue)})
 		}
-		if _, exists := currentTags[machineKey]; !exists {
-			tagsToAdd = append(tagsToAdd, efstypes.Tag{Key: aws.String(machineKey), Value: aws.String("")})
+		if value, ok := e.machineKeyTag(currentTags, region, machineKey, nameValue, fsID); ok {
+			tagsToAdd = append(tagsToAdd, efstypes.Tag{Key: aws.String(machineKey), Value: aws.String(value)})
 		}
 
-		e.planOrApplyEFS(ctx, client, fsID, tagsToAdd, "EFS FileSystem")
+		// Access points are selected on their own, whether or not their file system is
+		if e.selected(currentTags, fsID) {
+			res := rr.add("EFS FileSystem", fsID, currentTags)
+			e.planOrApplyEFS(ctx, client, res, tagsToAdd)
+		}
 
 		// Access Points
-		apResult, err := client.DescribeAccessPoints(ctx, &efs.DescribeAccessPointsInput{
+		var accessPoints []efstypes.AccessPointDescription
+		apPages := efs.NewDescribeAccessPointsPaginator(client, &efs.DescribeAccessPointsInput{
 			FileSystemId: fs.FileSystemId,
 		})
-		if err == nil {
-			for _, ap := range apResult.AccessPoints {
-				apID := aws.ToString(ap.AccessPointId)
-				apTags := e.getCurrentTagsEFS(ctx, client, apID)
-
-				apName := apTags["Name"]
-				if apName == "" {
-					apName = fmt.Sprintf("%s-ap", nameValue)
-				}
+		for apPages.HasMorePages() {
+			page, err := apPages.NextPage(ctx)
+			if err != nil {
+				rr.warn(fmt.Sprintf("Failed to describe EFS access points of %s: %v", fsID, err))
+				break
+			}
+			accessPoints = append(accessPoints, page.AccessPoints...)
+		}
+		for _, ap := range accessPoints {
+			apID := aws.ToString(ap.AccessPointId)
+			apTags, err := e.listTagsEFS(ctx, client, apID)
+			if err != nil {
+				e.readFailed(rr, "EFS AccessPoint", apID, err)
+				continue
+			}
+			if !e.selected(apTags, apID) {
+				continue
+			}
 
-				apKey := normalizeKey(apName)
-				if apKey == "" {
-					apKey = apID
-				}
+			apName := apTags["Name"]
+			if apName == "" {
+				apName = fmt.Sprintf("%s-ap", nameValue)
+			}
 
-				apTagsToAdd := []efstypes.Tag{}
-				if _, exists := apTags["Name"]; !exists {
-					apTagsToAdd = append(apTagsToAdd, efstypes.Tag{Key: aws.String("Name"), Value: aws.String(apName)})
-				}
-				if _, exists := apTags[apKey]; !exists {
-					apTagsToAdd = append(apTagsToAdd, efstypes.Tag{Key: aws.String(apKey), Value: aws.String("")})
-				}
+			apKey := normalizeKey(apName)
+			if apKey == "" {
+				apKey = apID
+			}
 
-				e.planOrApplyEFS(ctx, client, apID, apTagsToAdd, "EFS AccessPoint")
+			apTagsToAdd := []efstypes.Tag{}
+			if _, exists := apTags["Name"]; !exists {
+				apTagsToAdd = append(apTagsToAdd, efstypes.Tag{Key: aws.String("Name"), Value: aws.String(apName)})
 			}
+			if value, ok := e.machineKeyTag(apTags, region, apKey, apName, apID); ok {
+				apTagsToAdd = append(apTagsToAdd, efstypes.Tag{Key: aws.String(apKey), Value: aws.String(value)})
+			}
+
+			apRes := rr.add("EFS AccessPoint", apID, apTags)
+			e.planOrApplyEFS(ctx, client, apRes, apTagsToAdd)
 		}
 	}
 }
 
-// getCurrentTagsEFS gets current tags for an EFS resource
-func (e *Engine) getCurrentTagsEFS(ctx context.Context, client *efs.Client, resourceID string) map[string]string {
-	result, err := client.ListTagsForResource(ctx, &efs.ListTagsForResourceInput{
+// listTagsEFS lists the tags of an EFS resource
+func (e *Engine) listTagsEFS(ctx context.Context, client EFSAPI, resourceID string) (map[string]string, error) {
+	tags := make(map[string]string)
+	paginator := efs.NewListTagsForResourcePaginator(client, &efs.ListTagsForResourceInput{
 		ResourceId: aws.String(resourceID),
 	})
-	if err != nil {
-		return make(map[string]string)
-	}
-
-	tags := make(map[string]string)
-	for _, tag := range result.Tags {
-		tags[aws.ToString(tag.Key)] = aws.ToString(tag.Value)
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, tag := range page.Tags {
+			tags[aws.ToString(tag.Key)] = aws.ToString(tag.Value)
+		}
 	}
-	return tags
+	return tags, nil
 }
 
 // planOrApplyEFS applies tags to EFS resources
-func (e *Engine) planOrApplyEFS(ctx context.Context, client *efs.Client, resourceID string, tags []efstypes.Tag, resourceType string) {
-	if len(tags) == 0 {
+func (e *Engine) planOrApplyEFS(ctx context.Context, client EFSAPI, res *ResourceResult, tags []efstypes.Tag) {
+	// Audit mode only reads tags
+	if e.opts.Mode == ModeAudit {
 		return
 	}
-
-	action := "PLAN"
-	if e.opts.Apply {
-		action = "APPLY"
+	// Protected resources are reported, never changed
+	if e.skipProtected(res) {
+		return
 	}
 
-	shortID := resourceID
-	if idx := strings.LastIndex(resourceID, "/"); idx >= 0 {
-		shortID = resourceID[idx+1:]
+	for _, tag := range tags {
+		res.PlannedTags = append(res.PlannedTags, Tag{Key: aws.ToString(tag.Key), Value: aws.ToString(tag.Value)})
+	}
+	for _, tag := range e.policyTags(res) {
+		res.PlannedTags = append(res.PlannedTags, tag)
+		tags = append(tags, efstypes.Tag{Key: aws.String(tag.Key), Value: aws.String(tag.Value)})
 	}
 
-	for _, tag := range tags {
-		value := aws.ToString(tag.Value)
-		if value == "" {
-			value = "(empty)"
-		}
-		fmt.Printf("    [%s] %s %s → %s = %s\n", action, resourceType, shortID, aws.ToString(tag.Key), value)
+	if len(tags) == 0 {
+		return
 	}
 
 	if !e.opts.Apply {
 		return
 	}
 
+	if err := e.journalError(); err != nil {
+		res.Error = err.Error()
+		return
+	}
+
 	_, err := client.TagResource(ctx, &efs.TagResourceInput{
-		ResourceId: aws.String(resourceID),
+		ResourceId: aws.String(res.ID),
 		Tags:       tags,
 	})
 	if err != nil {
-		fmt.Printf("    [ERROR] %s %s: %v\n", resourceType, shortID, err)
+		res.Error = err.Error()
+		return
 	}
+	res.AppliedTags = res.PlannedTags
+	e.record(res, res.AppliedTags)
 }
 
 // processFSx processes FSx resources in a region
-func (e *Engine) processFSx(ctx context.Context, region string) {
-	mode := "DRY-RUN"
-	if e.opts.Apply {
-		mode = "APPLY"
-	}
-
-	fmt.Printf("\n[FSx] Processing FSx resources in %s (%s)\n", strings.ToUpper(region), mode)
-
-	regionCfg := e.cfg.Copy()
-	regionCfg.Region = region
-	client := fsx.NewFromConfig(regionCfg)
+func (e *Engine) processFSx(ctx context.Context, rr *RegionReport) {
+	region := rr.Region
+	client := e.clients.FSx(region)
 
 	// File Systems
-	fsResult, err := client.DescribeFileSystems(ctx, &fsx.DescribeFileSystemsInput{})
-	if err != nil {
-		fmt.Printf("[WARN] Failed to describe FSx file systems: %v\n", err)
-		return
+	var fileSystems []fsxtypes.FileSystem
+	fsPages := fsx.NewDescribeFileSystemsPaginator(client, &fsx.DescribeFileSystemsInput{})
+	for fsPages.HasMorePages() {
+		page, err := fsPages.NextPage(ctx)
+		if err != nil {
+			rr.warn(fmt.Sprintf("Failed to describe FSx file systems: %v", err))
+			break
+		}
+		fileSystems = append(fileSystems, page.FileSystems...)
 	}
 
-	for _, fs := range fsResult.FileSystems {
+	for _, fs := range fileSystems {
 		fsARN := aws.ToString(fs.ResourceARN)
-		currentTags := e.getCurrentTagsFSx(ctx, client, fsARN)
+		currentTags, err := e.listTagsFSx(ctx, client, fsARN)
+		if err != nil {
+			e.readFailed(rr, "FSx FileSystem", fsARN, err)
+			continue
+		}
+		if !e.selected(currentTags, fsARN) {
+			continue
+		}
 
 		nameValue := currentTags["Name"]
 		if nameValue == "" {
@@ -927,121 +968,161 @@ func (e *Engine) processFSx(ctx context.Context, region string) {
 		if _, exists := currentTags["Name"]; !exists {
 			tagsToAdd = append(tagsToAdd, fsxtypes.Tag{Key: aws.String("Name"), Value: aws.String(nameValue)})
 		}
-		if _, exists := currentTags[machineKey]; !exists {
-			tagsToAdd = append(tagsToAdd, fsxtypes.Tag{Key: aws.String(machineKey), Value: aws.String("")})
+		if value, ok := e.machineKeyTag(currentTags, region, machineKey, nameValue, aws.ToString(fs.FileSystemId)); ok {
+			tagsToAdd = append(tagsToAdd, fsxtypes.Tag{Key: aws.String(machineKey), Value: aws.String(value)})
 		}
 
-		e.planOrApplyFSx(ctx, client, fsARN, tagsToAdd, "FSx FileSystem")
+		res := rr.add("FSx FileSystem", fsARN, currentTags)
+		e.planOrApplyFSx(ctx, client, res, tagsToAdd)
 	}
 
 	// Backups
-	backupResult, err := client.DescribeBackups(ctx, &fsx.DescribeBackupsInput{})
-	if err == nil {
-		for _, backup := range backupResult.Backups {
-			backupARN := aws.ToString(backup.ResourceARN)
-			currentTags := e.getCurrentTagsFSx(ctx, client, backupARN)
-
-			nameValue := currentTags["Name"]
-			if nameValue == "" {
-				nameValue = aws.ToString(backup.BackupId)
-			}
+	var backups []fsxtypes.Backup
+	backupPages := fsx.NewDescribeBackupsPaginator(client, &fsx.DescribeBackupsInput{})
+	for backupPages.HasMorePages() {
+		page, err := backupPages.NextPage(ctx)
+		if err != nil {
+			rr.warn(fmt.Sprintf("Failed to describe FSx backups: %v", err))
+			break
+		}
+		backups = append(backups, page.Backups...)
+	}
+	for _, backup := range backups {
+		backupARN := aws.ToString(backup.ResourceARN)
+		currentTags, err := e.listTagsFSx(ctx, client, backupARN)
+		if err != nil {
+			e.readFailed(rr, "FSx Backup", backupARN, err)
+			continue
+		}
+		if !e.selected(currentTags, backupARN) {
+			continue
+		}
 
-			machineKey := normalizeKey(nameValue)
-			if machineKey == "" {
-				machineKey = aws.ToString(backup.BackupId)
-			}
+		nameValue := currentTags["Name"]
+		if nameValue == "" {
+			nameValue = aws.ToString(backup.BackupId)
+		}
 
-			tagsToAdd := []fsxtypes.Tag{}
-			if _, exists := currentTags["Name"]; !exists {
-				tagsToAdd = append(tagsToAdd, fsxtypes.Tag{Key: aws.String("Name"), Value: aws.String(nameValue)})
-			}
-			if _, exists := currentTags[machineKey]; !exists {
-				tagsToAdd = append(tagsToAdd, fsxtypes.Tag{Key: aws.String(machineKey), Value: aws.String("")})
-			}
+		machineKey := normalizeKey(nameValue)
+		if machineKey == "" {
+			machineKey = aws.ToString(backup.BackupId)
+		}
 
-			e.planOrApplyFSx(ctx, client, backupARN, tagsToAdd, "FSx Backup")
+		tagsToAdd := []fsxtypes.Tag{}
+		if _, exists := currentTags["Name"]; !exists {
+			tagsToAdd = append(tagsToAdd, fsxtypes.Tag{Key: aws.String("Name"), Value: aws.String(nameValue)})
 		}
+		if value, ok := e.machineKeyTag(currentTags, region, machineKey, nameValue, aws.ToString(backup.BackupId)); ok {
+			tagsToAdd = append(tagsToAdd, fsxtypes.Tag{Key: aws.String(machineKey), Value: aws.String(value)})
+		}
+
+		res := rr.add("FSx Backup", backupARN, currentTags)
+		e.planOrApplyFSx(ctx, client, res, tagsToAdd)
 	}
 
 	// Volumes
-	volumeResult, err := client.DescribeVolumes(ctx, &fsx.DescribeVolumesInput{})
-	if err == nil {
-		for _, volume := range volumeResult.Volumes {
-			volumeARN := aws.ToString(volume.ResourceARN)
-			currentTags := e.getCurrentTagsFSx(ctx, client, volumeARN)
-
-			nameValue := currentTags["Name"]
-			if nameValue == "" {
-				nameValue = aws.ToString(volume.VolumeId)
-			}
+	var volumes []fsxtypes.Volume
+	volumePages := fsx.NewDescribeVolumesPaginator(client, &fsx.DescribeVolumesInput{})
+	for volumePages.HasMorePages() {
+		page, err := volumePages.NextPage(ctx)
+		if err != nil {
+			rr.warn(fmt.Sprintf("Failed to describe FSx volumes: %v", err))
+			break
+		}
+		volumes = append(volumes, page.Volumes...)
+	}
+	for _, volume := range volumes {
+		volumeARN := aws.ToString(volume.ResourceARN)
+		currentTags, err := e.listTagsFSx(ctx, client, volumeARN)
+		if err != nil {
+			e.readFailed(rr, "FSx Volume", volumeARN, err)
+			continue
+		}
+		if !e.selected(currentTags, volumeARN) {
+			continue
+		}
 
-			machineKey := normalizeKey(nameValue)
-			if machineKey == "" {
-				machineKey = aws.ToString(volume.VolumeId)
-			}
+		nameValue := currentTags["Name"]
+		if nameValue == "" {
+			nameValue = aws.ToString(volume.VolumeId)
+		}
 
-			tagsToAdd := []fsxtypes.Tag{}
-			if _, exists := currentTags["Name"]; !exists {
-				tagsToAdd = append(tagsToAdd, fsxtypes.Tag{Key: aws.String("Name"), Value: aws.String(nameValue)})
-			}
-			if _, exists := currentTags[machineKey]; !exists {
-				tagsToAdd = append(tagsToAdd, fsxtypes.Tag{Key: aws.String(machineKey), Value: aws.String("")})
-			}
+		machineKey := normalizeKey(nameValue)
+		if machineKey == "" {
+			machineKey = aws.ToString(volume.VolumeId)
+		}
 
-			e.planOrApplyFSx(ctx, client, volumeARN, tagsToAdd, "FSx Volume")
+		tagsToAdd := []fsxtypes.Tag{}
+		if _, exists := currentTags["Name"]; !exists {
+			tagsToAdd = append(tagsToAdd, fsxtypes.Tag{Key: aws.String("Name"), Value: aws.String(nameValue)})
+		}
+		if value, ok := e.machineKeyTag(currentTags, region, machineKey, nameValue, aws.ToString(volume.VolumeId)); ok {
+			tagsToAdd = append(tagsToAdd, fsxtypes.Tag{Key: aws.String(machineKey), Value: aws.String(value)})
 		}
+
+		res := rr.add("FSx Volume", volumeARN, currentTags)
+		e.planOrApplyFSx(ctx, client, res, tagsToAdd)
 	}
 }
 
-// getCurrentTagsFSx gets current tags for an FSx resource
-func (e *Engine) getCurrentTagsFSx(ctx context.Context, client *fsx.Client, resourceARN string) map[string]string {
-	result, err := client.ListTagsForResource(ctx, &fsx.ListTagsForResourceInput{
+// listTagsFSx lists the tags of an FSx resource
+func (e *Engine) listTagsFSx(ctx context.Context, client FSxAPI, resourceARN string) (map[string]string, error) {
+	tags := make(map[string]string)
+	paginator := fsx.NewListTagsForResourcePaginator(client, &fsx.ListTagsForResourceInput{
 		ResourceARN: aws.String(resourceARN),
 	})
-	if err != nil {
-		return make(map[string]string)
-	}
-
-	tags := make(map[string]string)
-	for _, tag := range result.Tags {
-		tags[aws.ToString(tag.Key)] = aws.ToString(tag.Value)
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, tag := range page.Tags {
+			tags[aws.ToString(tag.Key)] = aws.ToString(tag.Value)
+		}
 	}
-	return tags
+	return tags, nil
 }
 
 // planOrApplyFSx applies tags to FSx resources
-func (e *Engine) planOrApplyFSx(ctx context.Context, client *fsx.Client, resourceARN string, tags []fsxtypes.Tag, resourceType string) {
-	if len(tags) == 0 {
+func (e *Engine) planOrApplyFSx(ctx context.Context, client FSxAPI, res *ResourceResult, tags []fsxtypes.Tag) {
+	// Audit mode only reads tags
+	if e.opts.Mode == ModeAudit {
 		return
 	}
-
-	action := "PLAN"
-	if e.opts.Apply {
-		action = "APPLY"
+	// Protected resources are reported, never changed
+	if e.skipProtected(res) {
+		return
 	}
 
-	shortID := resourceARN
-	if idx := strings.LastIndex(resourceARN, "/"); idx >= 0 {
-		shortID = resourceARN[idx+1:]
+	for _, tag := range tags {
+		res.PlannedTags = append(res.PlannedTags, Tag{Key: aws.ToString(tag.Key), Value: aws.ToString(tag.Value)})
+	}
+	for _, tag := range e.policyTags(res) {
+		res.PlannedTags = append(res.PlannedTags, tag)
+		tags = append(tags, fsxtypes.Tag{Key: aws.String(tag.Key), Value: aws.String(tag.Value)})
 	}
 
-	for _, tag := range tags {
-		value := aws.ToString(tag.Value)
-		if value == "" {
-			value = "(empty)"
-		}
-		fmt.Printf("    [%s] %s %s → %s = %s\n", action, resourceType, shortID, aws.ToString(tag.Key), value)
+	if len(tags) == 0 {
+		return
 	}
 
 	if !e.opts.Apply {
 		return
 	}
 
+	if err := e.journalError(); err != nil {
+		res.Error = err.Error()
+		return
+	}
+
 	_, err := client.TagResource(ctx, &fsx.TagResourceInput{
-		ResourceARN: aws.String(resourceARN),
+		ResourceARN: aws.String(res.ID),
 		Tags:        tags,
 	})
 	if err != nil {
-		fmt.Printf("    [ERROR] %s %s: %v\n", resourceType, shortID, err)
+		res.Error = err.Error()
+		return
 	}
+	res.AppliedTags = res.PlannedTags
+	e.record(res, res.AppliedTags)
 }
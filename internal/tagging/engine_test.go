@@ -140,7 +140,7 @@ func TestGetMachineKey_FallsBackToInstanceID(t *testing.T) {
 func TestBuildCostAllocationTagStatus(t *testing.T) {
 	keys := []string{"Name", "Machine", "Env"}
 
-	entries := buildCostAllocationTagStatus(keys)
+	entries := buildCostAllocationTagStatus(keys, ceTypes.CostAllocationTagStatusActive)
 	if len(entries) != len(keys) {
 		t.Fatalf("expected %d entries, got %d", len(keys), len(entries))
 	}
@@ -0,0 +1,490 @@
+// Package fakeaws provides an in-memory AWS backend for exercising the
+// tagging engine offline. It simulates the EC2, EFS, FSx and Cost Explorer
+// operations the engine uses, including pagination and tag mutation.
+package fakeaws
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	cetypes "github.com/aws/aws-sdk-go-v2/service/costexplorer/types"
+	ec2types "github.com/aws/aws-sdk-go-v2/service/ec2/types"
+
+	"github.com/Th3Mayar/aws-cost-optimization-tools/internal/tagging"
+)
+
+// AccountID is the account of backends returned by New, and the one used
+// when building ARNs
+const AccountID = "123456789012"
+
+// DefaultPageSize is the page size used when Backend.PageSize is zero
+const DefaultPageSize = 50
+
+// Instance describes a seeded EC2 instance
+type Instance struct {
+	ID        string
+	State     ec2types.InstanceStateName
+	ImageID   string
+	Tags      map[string]string
+	VolumeIDs []string
+}
+
+// Volume describes a seeded EBS volume
+type Volume struct {
+	ID         string
+	InstanceID string
+	Tags       map[string]string
+}
+
+// Snapshot describes a seeded EBS snapshot
+type Snapshot struct {
+	ID          string
+	VolumeID    string
+	Description string
+	Tags        map[string]string
+}
+
+// Image describes a seeded AMI owned by the account. SnapshotIDs back its
+// block device mappings, in device order.
+type Image struct {
+	ID          string
+	Name        string
+	Description string
+	SnapshotIDs []string
+	// SourceInstanceID is the instance the AMI was created from, if any
+	SourceInstanceID string
+	Tags             map[string]string
+}
+
+// EFSFileSystem describes a seeded EFS file system
+type EFSFileSystem struct {
+	ID   string
+	Name string
+	Tags map[string]string
+}
+
+// EFSAccessPoint describes a seeded EFS access point
+type EFSAccessPoint struct {
+	ID           string
+	FileSystemID string
+	Name         string
+	Tags         map[string]string
+}
+
+// FSxFileSystem describes a seeded FSx file system
+type FSxFileSystem struct {
+	ID   string
+	Tags map[string]string
+}
+
+// FSxBackup describes a seeded FSx backup
+type FSxBackup struct {
+	ID   string
+	Tags map[string]string
+}
+
+// FSxVolume describes a seeded FSx volume
+type FSxVolume struct {
+	ID           string
+	FileSystemID string
+	Name         string
+	Tags         map[string]string
+}
+
+// Call records a single API operation issued against the backend
+type Call struct {
+	Service   string
+	Region    string
+	Operation string
+}
+
+type failure struct {
+	err   error
+	times int
+}
+
+type regionState struct {
+	instances       []Instance
+	volumes         []Volume
+	snapshots       []Snapshot
+	images          []Image
+	efsFileSystems  []EFSFileSystem
+	efsAccessPoints []EFSAccessPoint
+	fsxFileSystems  []FSxFileSystem
+	fsxBackups      []FSxBackup
+	fsxVolumes      []FSxVolume
+
+	// optIn is the opt-in status reported by DescribeRegions
+	optIn string
+
+	// tags holds the current tags of every resource, keyed by ID (EC2, EFS) or ARN (FSx)
+	tags map[string]map[string]string
+}
+
+// Backend is an in-memory, multi-region AWS account. It is safe for concurrent use.
+type Backend struct {
+	// PageSize limits the number of items returned per page by paginated operations
+	PageSize int
+
+	mu       sync.Mutex
+	regions  map[string]*regionState
+	costTags map[string]cetypes.CostAllocationTagStatus
+	refused  map[string]cetypes.UpdateCostAllocationTagsStatusError
+	failures map[string]*failure
+	calls    []Call
+
+	// accounts are the member accounts roles can be assumed into, denied
+	// those whose role cannot be, and suspended those ListAccounts reports
+	// as suspended
+	accounts  map[string]*Backend
+	denied    map[string]bool
+	suspended map[string]bool
+
+	// id is the account the backend's credentials belong to
+	id string
+}
+
+// New returns an empty backend
+func New() *Backend {
+	return &Backend{
+		id:        AccountID,
+		regions:   make(map[string]*regionState),
+		costTags:  make(map[string]cetypes.CostAllocationTagStatus),
+		refused:   make(map[string]cetypes.UpdateCostAllocationTagsStatusError),
+		failures:  make(map[string]*failure),
+		accounts:  make(map[string]*Backend),
+		denied:    make(map[string]bool),
+		suspended: make(map[string]bool),
+	}
+}
+
+var (
+	_ tagging.AccountClientFactory  = (*Backend)(nil)
+	_ tagging.IdentityClientFactory = (*Backend)(nil)
+)
+
+// EC2 returns an EC2 client bound to region
+func (b *Backend) EC2(region string) tagging.EC2API {
+	return &ec2Client{b: b, region: region}
+}
+
+// EFS returns an EFS client bound to region
+func (b *Backend) EFS(region string) tagging.EFSAPI {
+	return &efsClient{b: b, region: region}
+}
+
+// FSx returns an FSx client bound to region
+func (b *Backend) FSx(region string) tagging.FSxAPI {
+	return &fsxClient{b: b, region: region}
+}
+
+// CostExplorer returns a Cost Explorer client
+func (b *Backend) CostExplorer() tagging.CostExplorerAPI {
+	return &costExplorerClient{b: b}
+}
+
+// Tagging returns a Resource Groups Tagging API client bound to region
+func (b *Backend) Tagging(region string) tagging.TaggingAPI {
+	return &taggingClient{b: b, region: region}
+}
+
+// Organizations returns an Organizations client
+func (b *Backend) Organizations() tagging.OrganizationsAPI {
+	return &organizationsClient{b: b}
+}
+
+// Account returns the backend of a member account, creating it on first use.
+// Its resources are seeded and inspected like those of b.
+func (b *Backend) Account(id string) *Backend {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	account, ok := b.accounts[id]
+	if !ok {
+		account = New()
+		account.id = id
+		account.PageSize = b.PageSize
+		b.accounts[id] = account
+	}
+	return account
+}
+
+// DenyAccount makes assuming a role into the account fail, as when the role
+// does not exist or does not trust the caller
+func (b *Backend) DenyAccount(id string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.denied[id] = true
+}
+
+// SuspendAccount marks a member account as suspended in the organization
+func (b *Backend) SuspendAccount(id string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.suspended[id] = true
+}
+
+// ForAccount assumes the role of a member account, returning its backend.
+// The call is recorded as sts:AssumeRole.
+func (b *Backend) ForAccount(_ context.Context, account tagging.Account) (tagging.ClientFactory, error) {
+	b.mu.Lock()
+	b.calls = append(b.calls, Call{Service: "sts", Operation: "AssumeRole"})
+	denied := b.denied[account.ID]
+	b.mu.Unlock()
+	if denied {
+		return nil, fmt.Errorf("AccessDenied: not authorized to perform sts:AssumeRole on %s", account.RoleARN)
+	}
+	return b.Account(account.ID), nil
+}
+
+// CallerAccount returns the account of the backend, recorded as
+// sts:GetCallerIdentity
+func (b *Backend) CallerAccount(_ context.Context) (string, error) {
+	_, err := b.begin("sts", "", "GetCallerIdentity")
+	defer b.mu.Unlock()
+	if err != nil {
+		return "", err
+	}
+	return b.id, nil
+}
+
+// region returns the state for name, creating it if needed. Callers must hold b.mu.
+func (b *Backend) region(name string) *regionState {
+	rs, ok := b.regions[name]
+	if !ok {
+		rs = &regionState{tags: make(map[string]map[string]string)}
+		b.regions[name] = rs
+	}
+	return rs
+}
+
+// AddRegion registers an empty region so that it is reported by DescribeRegions
+func (b *Backend) AddRegion(region string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.region(region)
+}
+
+// SetRegionOptIn sets the opt-in status of a region, such as "not-opted-in";
+// regions are "opt-in-not-required" by default
+func (b *Backend) SetRegionOptIn(region, status string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.region(region).optIn = status
+}
+
+// AddInstance seeds an EC2 instance
+func (b *Backend) AddInstance(region string, inst Instance) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if inst.State == "" {
+		inst.State = ec2types.InstanceStateNameRunning
+	}
+	rs := b.region(region)
+	rs.instances = append(rs.instances, inst)
+	rs.tags[inst.ID] = copyTags(inst.Tags)
+}
+
+// AddVolume seeds an EBS volume
+func (b *Backend) AddVolume(region string, vol Volume) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	rs := b.region(region)
+	rs.volumes = append(rs.volumes, vol)
+	rs.tags[vol.ID] = copyTags(vol.Tags)
+}
+
+// AddSnapshot seeds an EBS snapshot owned by the account
+func (b *Backend) AddSnapshot(region string, snap Snapshot) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	rs := b.region(region)
+	rs.snapshots = append(rs.snapshots, snap)
+	rs.tags[snap.ID] = copyTags(snap.Tags)
+}
+
+// AddImage seeds an AMI owned by the account
+func (b *Backend) AddImage(region string, img Image) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	rs := b.region(region)
+	rs.images = append(rs.images, img)
+	rs.tags[img.ID] = copyTags(img.Tags)
+}
+
+// AddEFSFileSystem seeds an EFS file system
+func (b *Backend) AddEFSFileSystem(region string, fs EFSFileSystem) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	rs := b.region(region)
+	rs.efsFileSystems = append(rs.efsFileSystems, fs)
+	rs.tags[fs.ID] = copyTags(fs.Tags)
+}
+
+// AddEFSAccessPoint seeds an EFS access point
+func (b *Backend) AddEFSAccessPoint(region string, ap EFSAccessPoint) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	rs := b.region(region)
+	rs.efsAccessPoints = append(rs.efsAccessPoints, ap)
+	rs.tags[ap.ID] = copyTags(ap.Tags)
+}
+
+// AddFSxFileSystem seeds an FSx file system
+func (b *Backend) AddFSxFileSystem(region string, fs FSxFileSystem) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	rs := b.region(region)
+	rs.fsxFileSystems = append(rs.fsxFileSystems, fs)
+	rs.tags[FSxARN(region, "file-system", fs.ID)] = copyTags(fs.Tags)
+}
+
+// AddFSxBackup seeds an FSx backup
+func (b *Backend) AddFSxBackup(region string, backup FSxBackup) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	rs := b.region(region)
+	rs.fsxBackups = append(rs.fsxBackups, backup)
+	rs.tags[FSxARN(region, "backup", backup.ID)] = copyTags(backup.Tags)
+}
+
+// AddFSxVolume seeds an FSx volume
+func (b *Backend) AddFSxVolume(region string, vol FSxVolume) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	rs := b.region(region)
+	rs.fsxVolumes = append(rs.fsxVolumes, vol)
+	rs.tags[FSxARN(region, "volume", vol.ID)] = copyTags(vol.Tags)
+}
+
+// SetCostAllocationTag registers a cost allocation tag key with the given status
+func (b *Backend) SetCostAllocationTag(key string, status cetypes.CostAllocationTagStatus) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.costTags[key] = status
+}
+
+// RefuseCostAllocationTag makes UpdateCostAllocationTagsStatus report key in
+// its Errors, with code and message, instead of updating it
+func (b *Backend) RefuseCostAllocationTag(key, code, message string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refused[key] = cetypes.UpdateCostAllocationTagsStatusError{
+		TagKey:  aws.String(key),
+		Code:    aws.String(code),
+		Message: aws.String(message),
+	}
+}
+
+// CostAllocationTagStatus returns the status of a cost allocation tag key, or "" if unknown
+func (b *Backend) CostAllocationTagStatus(key string) cetypes.CostAllocationTagStatus {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.costTags[key]
+}
+
+// Tags returns a copy of the current tags of a resource (ID for EC2/EFS, ARN for FSx)
+func (b *Backend) Tags(region, resource string) map[string]string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return copyTags(b.region(region).tags[resource])
+}
+
+// Fail makes the next times calls to operation (e.g. "ec2:CreateTags") return err.
+// A negative times fails every call until Fail is called again with a nil error.
+func (b *Backend) Fail(operation string, times int, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err == nil {
+		delete(b.failures, operation)
+		return
+	}
+	b.failures[operation] = &failure{err: err, times: times}
+}
+
+// Calls returns every operation issued against the backend, in order
+func (b *Backend) Calls() []Call {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]Call(nil), b.calls...)
+}
+
+// CallCount returns how many times operation (e.g. "ec2:CreateTags") was issued
+func (b *Backend) CallCount(operation string) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	n := 0
+	for _, c := range b.calls {
+		if c.Service+":"+c.Operation == operation {
+			n++
+		}
+	}
+	return n
+}
+
+// begin records a call and returns the region state plus any injected failure.
+// It acquires b.mu; callers must release it with b.mu.Unlock.
+func (b *Backend) begin(service, region, operation string) (*regionState, error) {
+	b.mu.Lock()
+	b.calls = append(b.calls, Call{Service: service, Region: region, Operation: operation})
+
+	key := service + ":" + operation
+	if f, ok := b.failures[key]; ok {
+		if f.times > 0 {
+			f.times--
+			if f.times == 0 {
+				delete(b.failures, key)
+			}
+		}
+		return nil, f.err
+	}
+	return b.region(region), nil
+}
+
+func (b *Backend) pageSize() int {
+	if b.PageSize > 0 {
+		return b.PageSize
+	}
+	return DefaultPageSize
+}
+
+// page returns the bounds of the page starting at token, and the token of the next page
+func (b *Backend) page(total int, token *string) (start, end int, next *string, err error) {
+	if token != nil && *token != "" {
+		start, err = strconv.Atoi(*token)
+		if err != nil || start < 0 || start > total {
+			return 0, 0, nil, fmt.Errorf("InvalidPaginationToken: %q", *token)
+		}
+	}
+	end = start + b.pageSize()
+	if end >= total {
+		return start, total, nil, nil
+	}
+	nextToken := strconv.Itoa(end)
+	return start, end, &nextToken, nil
+}
+
+// FSxARN builds the ARN the backend assigns to an FSx resource
+func FSxARN(region, kind, id string) string {
+	return fmt.Sprintf("arn:aws:fsx:%s:%s:%s/%s", region, AccountID, kind, id)
+}
+
+func copyTags(tags map[string]string) map[string]string {
+	out := make(map[string]string, len(tags))
+	for k, v := range tags {
+		out[k] = v
+	}
+	return out
+}
+
+func sortedKeys(tags map[string]string) []string {
+	keys := make([]string, 0, len(tags))
+	for k := range tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
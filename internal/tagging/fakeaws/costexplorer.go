@@ -0,0 +1,75 @@
+package fakeaws
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/costexplorer"
+	cetypes "github.com/aws/aws-sdk-go-v2/service/costexplorer/types"
+)
+
+// MaxCostAllocationTagUpdates is the per-call entry limit of UpdateCostAllocationTagsStatus
+const MaxCostAllocationTagUpdates = 20
+
+type costExplorerClient struct {
+	b *Backend
+}
+
+func (c *costExplorerClient) ListCostAllocationTags(ctx context.Context, params *costexplorer.ListCostAllocationTagsInput, optFns ...func(*costexplorer.Options)) (*costexplorer.ListCostAllocationTagsOutput, error) {
+	_, err := c.b.begin("ce", "", "ListCostAllocationTags")
+	defer c.b.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make([]string, 0, len(c.b.costTags))
+	for key, status := range c.b.costTags {
+		if params.Status != "" && params.Status != status {
+			continue
+		}
+		if len(params.TagKeys) > 0 && !contains(params.TagKeys, key) {
+			continue
+		}
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	start, end, next, err := c.b.page(len(keys), params.NextToken)
+	if err != nil {
+		return nil, err
+	}
+
+	out := &costexplorer.ListCostAllocationTagsOutput{NextToken: next}
+	for _, key := range keys[start:end] {
+		out.CostAllocationTags = append(out.CostAllocationTags, cetypes.CostAllocationTag{
+			TagKey: aws.String(key),
+			Type:   cetypes.CostAllocationTagTypeUserDefined,
+			Status: c.b.costTags[key],
+		})
+	}
+	return out, nil
+}
+
+func (c *costExplorerClient) UpdateCostAllocationTagsStatus(ctx context.Context, params *costexplorer.UpdateCostAllocationTagsStatusInput, optFns ...func(*costexplorer.Options)) (*costexplorer.UpdateCostAllocationTagsStatusOutput, error) {
+	_, err := c.b.begin("ce", "", "UpdateCostAllocationTagsStatus")
+	defer c.b.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	if n := len(params.CostAllocationTagsStatus); n > MaxCostAllocationTagUpdates {
+		return nil, fmt.Errorf("ValidationException: at most %d tag keys may be updated per call, got %d", MaxCostAllocationTagUpdates, n)
+	}
+	out := &costexplorer.UpdateCostAllocationTagsStatusOutput{}
+	for _, entry := range params.CostAllocationTagsStatus {
+		key := aws.ToString(entry.TagKey)
+		if keyErr, ok := c.b.refused[key]; ok {
+			out.Errors = append(out.Errors, keyErr)
+			continue
+		}
+		c.b.costTags[key] = entry.Status
+	}
+	return out, nil
+}
@@ -0,0 +1,496 @@
+package fakeaws
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	ec2types "github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/aws/smithy-go"
+)
+
+type ec2Client struct {
+	b      *Backend
+	region string
+}
+
+func ec2Tags(tags map[string]string) []ec2types.Tag {
+	out := make([]ec2types.Tag, 0, len(tags))
+	for _, k := range sortedKeys(tags) {
+		out = append(out, ec2types.Tag{Key: aws.String(k), Value: aws.String(tags[k])})
+	}
+	return out
+}
+
+// matchFilters reports whether a resource matches every EC2 filter.
+// attr returns the values of a named attribute; tag filters are resolved from tags.
+func matchFilters(filters []ec2types.Filter, tags map[string]string, attr func(name string) []string) bool {
+	for _, f := range filters {
+		name := aws.ToString(f.Name)
+		var values []string
+		switch {
+		case strings.HasPrefix(name, "tag:"):
+			if v, ok := tags[strings.TrimPrefix(name, "tag:")]; ok {
+				values = []string{v}
+			}
+		case name == "tag-key":
+			values = sortedKeys(tags)
+		default:
+			values = attr(name)
+		}
+		if !anyMatch(f.Values, values) {
+			return false
+		}
+	}
+	return true
+}
+
+func anyMatch(patterns, values []string) bool {
+	for _, p := range patterns {
+		for _, v := range values {
+			if globMatch(p, v) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// globMatch implements the '*' and '?' wildcards supported by EC2 filters
+func globMatch(pattern, s string) bool {
+	for pattern != "" {
+		switch pattern[0] {
+		case '*':
+			for i := len(s); i >= 0; i-- {
+				if globMatch(pattern[1:], s[i:]) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			if s == "" {
+				return false
+			}
+		default:
+			if s == "" || s[0] != pattern[0] {
+				return false
+			}
+		}
+		pattern, s = pattern[1:], s[1:]
+	}
+	return s == ""
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *ec2Client) DescribeInstances(ctx context.Context, params *ec2.DescribeInstancesInput, optFns ...func(*ec2.Options)) (*ec2.DescribeInstancesOutput, error) {
+	rs, err := c.b.begin("ec2", c.region, "DescribeInstances")
+	defer c.b.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	matched := []Instance{}
+	for _, inst := range rs.instances {
+		if len(params.InstanceIds) > 0 && !contains(params.InstanceIds, inst.ID) {
+			continue
+		}
+		inst := inst
+		ok := matchFilters(params.Filters, rs.tags[inst.ID], func(name string) []string {
+			switch name {
+			case "instance-state-name":
+				return []string{string(inst.State)}
+			case "instance-id":
+				return []string{inst.ID}
+			case "image-id":
+				return []string{inst.ImageID}
+			}
+			return nil
+		})
+		if ok {
+			matched = append(matched, inst)
+		}
+	}
+
+	start, end, next, err := c.b.page(len(matched), params.NextToken)
+	if err != nil {
+		return nil, err
+	}
+
+	out := &ec2.DescribeInstancesOutput{NextToken: next}
+	for _, inst := range matched[start:end] {
+		instance := ec2types.Instance{
+			InstanceId: aws.String(inst.ID),
+			State:      &ec2types.InstanceState{Name: inst.State},
+			Tags:       ec2Tags(rs.tags[inst.ID]),
+		}
+		if inst.ImageID != "" {
+			instance.ImageId = aws.String(inst.ImageID)
+		}
+		for i, volID := range inst.VolumeIDs {
+			instance.BlockDeviceMappings = append(instance.BlockDeviceMappings, ec2types.InstanceBlockDeviceMapping{
+				DeviceName: aws.String(fmt.Sprintf("/dev/xvd%c", 'a'+i)),
+				Ebs:        &ec2types.EbsInstanceBlockDevice{VolumeId: aws.String(volID)},
+			})
+		}
+		out.Reservations = append(out.Reservations, ec2types.Reservation{Instances: []ec2types.Instance{instance}})
+	}
+	return out, nil
+}
+
+func (c *ec2Client) DescribeVolumes(ctx context.Context, params *ec2.DescribeVolumesInput, optFns ...func(*ec2.Options)) (*ec2.DescribeVolumesOutput, error) {
+	rs, err := c.b.begin("ec2", c.region, "DescribeVolumes")
+	defer c.b.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, id := range params.VolumeIds {
+		if !rs.hasVolume(id) {
+			return nil, fmt.Errorf("InvalidVolume.NotFound: The volume '%s' does not exist.", id)
+		}
+	}
+
+	matched := []Volume{}
+	for _, vol := range rs.volumes {
+		if len(params.VolumeIds) > 0 && !contains(params.VolumeIds, vol.ID) {
+			continue
+		}
+		vol := vol
+		ok := matchFilters(params.Filters, rs.tags[vol.ID], func(name string) []string {
+			switch name {
+			case "volume-id":
+				return []string{vol.ID}
+			case "attachment.instance-id":
+				return []string{vol.InstanceID}
+			}
+			return nil
+		})
+		if ok {
+			matched = append(matched, vol)
+		}
+	}
+
+	start, end, next, err := c.b.page(len(matched), params.NextToken)
+	if err != nil {
+		return nil, err
+	}
+
+	out := &ec2.DescribeVolumesOutput{NextToken: next}
+	for _, vol := range matched[start:end] {
+		volume := ec2types.Volume{
+			VolumeId: aws.String(vol.ID),
+			Tags:     ec2Tags(rs.tags[vol.ID]),
+		}
+		if vol.InstanceID != "" {
+			volume.Attachments = []ec2types.VolumeAttachment{
+				{InstanceId: aws.String(vol.InstanceID), VolumeId: aws.String(vol.ID)},
+			}
+		}
+		out.Volumes = append(out.Volumes, volume)
+	}
+	return out, nil
+}
+
+func (c *ec2Client) DescribeSnapshots(ctx context.Context, params *ec2.DescribeSnapshotsInput, optFns ...func(*ec2.Options)) (*ec2.DescribeSnapshotsOutput, error) {
+	rs, err := c.b.begin("ec2", c.region, "DescribeSnapshots")
+	defer c.b.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, id := range params.SnapshotIds {
+		if !rs.hasSnapshot(id) {
+			return nil, fmt.Errorf("InvalidSnapshot.NotFound: The snapshot '%s' does not exist.", id)
+		}
+	}
+
+	matched := []Snapshot{}
+	for _, snap := range rs.snapshots {
+		if len(params.SnapshotIds) > 0 && !contains(params.SnapshotIds, snap.ID) {
+			continue
+		}
+		snap := snap
+		ok := matchFilters(params.Filters, rs.tags[snap.ID], func(name string) []string {
+			switch name {
+			case "snapshot-id":
+				return []string{snap.ID}
+			case "volume-id":
+				return []string{snap.VolumeID}
+			case "description":
+				return []string{snap.Description}
+			}
+			return nil
+		})
+		if ok {
+			matched = append(matched, snap)
+		}
+	}
+
+	start, end, next, err := c.b.page(len(matched), params.NextToken)
+	if err != nil {
+		return nil, err
+	}
+
+	out := &ec2.DescribeSnapshotsOutput{NextToken: next}
+	for _, snap := range matched[start:end] {
+		out.Snapshots = append(out.Snapshots, ec2types.Snapshot{
+			SnapshotId:  aws.String(snap.ID),
+			VolumeId:    aws.String(snap.VolumeID),
+			Description: aws.String(snap.Description),
+			OwnerId:     aws.String(AccountID),
+			Tags:        ec2Tags(rs.tags[snap.ID]),
+		})
+	}
+	return out, nil
+}
+
+func (c *ec2Client) DescribeTags(ctx context.Context, params *ec2.DescribeTagsInput, optFns ...func(*ec2.Options)) (*ec2.DescribeTagsOutput, error) {
+	rs, err := c.b.begin("ec2", c.region, "DescribeTags")
+	defer c.b.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	type resource struct {
+		id  string
+		typ ec2types.ResourceType
+	}
+	resources := []resource{}
+	for _, inst := range rs.instances {
+		resources = append(resources, resource{inst.ID, ec2types.ResourceTypeInstance})
+	}
+	for _, vol := range rs.volumes {
+		resources = append(resources, resource{vol.ID, ec2types.ResourceTypeVolume})
+	}
+	for _, snap := range rs.snapshots {
+		resources = append(resources, resource{snap.ID, ec2types.ResourceTypeSnapshot})
+	}
+	for _, img := range rs.images {
+		resources = append(resources, resource{img.ID, ec2types.ResourceTypeImage})
+	}
+	sort.SliceStable(resources, func(i, j int) bool { return resources[i].id < resources[j].id })
+
+	matched := []ec2types.TagDescription{}
+	for _, r := range resources {
+		tags := rs.tags[r.id]
+		for _, key := range sortedKeys(tags) {
+			r, key := r, key
+			ok := matchFilters(params.Filters, nil, func(name string) []string {
+				switch name {
+				case "resource-id":
+					return []string{r.id}
+				case "resource-type":
+					return []string{string(r.typ)}
+				case "key":
+					return []string{key}
+				case "value":
+					return []string{tags[key]}
+				}
+				return nil
+			})
+			if ok {
+				matched = append(matched, ec2types.TagDescription{
+					Key:          aws.String(key),
+					Value:        aws.String(tags[key]),
+					ResourceId:   aws.String(r.id),
+					ResourceType: r.typ,
+				})
+			}
+		}
+	}
+
+	start, end, next, err := c.b.page(len(matched), params.NextToken)
+	if err != nil {
+		return nil, err
+	}
+	return &ec2.DescribeTagsOutput{Tags: matched[start:end], NextToken: next}, nil
+}
+
+func (c *ec2Client) DescribeRegions(ctx context.Context, params *ec2.DescribeRegionsInput, optFns ...func(*ec2.Options)) (*ec2.DescribeRegionsOutput, error) {
+	_, err := c.b.begin("ec2", c.region, "DescribeRegions")
+	defer c.b.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	// The default region of clients built for "" is not a region of its own
+	names := make([]string, 0, len(c.b.regions))
+	for name := range c.b.regions {
+		if name != "" {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	// Like AWS, regions not opted in are only listed with AllRegions
+	out := &ec2.DescribeRegionsOutput{}
+	for _, name := range names {
+		optIn := c.b.regions[name].optIn
+		if optIn == "" {
+			optIn = "opt-in-not-required"
+		}
+		if optIn == "not-opted-in" && !aws.ToBool(params.AllRegions) {
+			continue
+		}
+		out.Regions = append(out.Regions, ec2types.Region{
+			RegionName:  aws.String(name),
+			OptInStatus: aws.String(optIn),
+		})
+	}
+	return out, nil
+}
+
+func (c *ec2Client) DescribeImages(ctx context.Context, params *ec2.DescribeImagesInput, optFns ...func(*ec2.Options)) (*ec2.DescribeImagesOutput, error) {
+	rs, err := c.b.begin("ec2", c.region, "DescribeImages")
+	defer c.b.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	matched := []Image{}
+	for _, img := range rs.images {
+		if len(params.ImageIds) > 0 && !contains(params.ImageIds, img.ID) {
+			continue
+		}
+		img := img
+		ok := matchFilters(params.Filters, rs.tags[img.ID], func(name string) []string {
+			switch name {
+			case "image-id":
+				return []string{img.ID}
+			case "name":
+				return []string{img.Name}
+			case "block-device-mapping.snapshot-id":
+				return img.SnapshotIDs
+			}
+			return nil
+		})
+		if ok {
+			matched = append(matched, img)
+		}
+	}
+
+	start, end, next, err := c.b.page(len(matched), params.NextToken)
+	if err != nil {
+		return nil, err
+	}
+
+	out := &ec2.DescribeImagesOutput{NextToken: next}
+	for _, img := range matched[start:end] {
+		image := ec2types.Image{
+			ImageId:     aws.String(img.ID),
+			Name:        aws.String(img.Name),
+			Description: aws.String(img.Description),
+			OwnerId:     aws.String(AccountID),
+			State:       ec2types.ImageStateAvailable,
+			Tags:        ec2Tags(rs.tags[img.ID]),
+		}
+		if img.SourceInstanceID != "" {
+			image.SourceInstanceId = aws.String(img.SourceInstanceID)
+		}
+		for i, snapID := range img.SnapshotIDs {
+			image.BlockDeviceMappings = append(image.BlockDeviceMappings, ec2types.BlockDeviceMapping{
+				DeviceName: aws.String(fmt.Sprintf("/dev/xvd%c", 'a'+i)),
+				Ebs:        &ec2types.EbsBlockDevice{SnapshotId: aws.String(snapID)},
+			})
+		}
+		out.Images = append(out.Images, image)
+	}
+	return out, nil
+}
+
+func (c *ec2Client) CreateTags(ctx context.Context, params *ec2.CreateTagsInput, optFns ...func(*ec2.Options)) (*ec2.CreateTagsOutput, error) {
+	rs, err := c.b.begin("ec2", c.region, "CreateTags")
+	defer c.b.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, id := range params.Resources {
+		if !rs.hasEC2Resource(id) {
+			return nil, &smithy.GenericAPIError{Code: "InvalidID", Message: fmt.Sprintf("The ID '%s' is not valid", id)}
+		}
+	}
+	for _, id := range params.Resources {
+		for _, tag := range params.Tags {
+			rs.tags[id][aws.ToString(tag.Key)] = aws.ToString(tag.Value)
+		}
+	}
+	return &ec2.CreateTagsOutput{}, nil
+}
+
+func (rs *regionState) hasInstance(id string) bool {
+	for _, inst := range rs.instances {
+		if inst.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
+func (rs *regionState) hasVolume(id string) bool {
+	for _, vol := range rs.volumes {
+		if vol.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
+func (rs *regionState) hasSnapshot(id string) bool {
+	for _, snap := range rs.snapshots {
+		if snap.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
+func (rs *regionState) hasImage(id string) bool {
+	for _, img := range rs.images {
+		if img.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
+// hasEC2Resource reports whether id is a taggable EC2 resource of the region
+func (rs *regionState) hasEC2Resource(id string) bool {
+	return rs.hasInstance(id) || rs.hasVolume(id) || rs.hasSnapshot(id) || rs.hasImage(id)
+}
+
+// DeleteTags removes tags; a tag with a value is only removed if the value matches
+func (c *ec2Client) DeleteTags(ctx context.Context, params *ec2.DeleteTagsInput, optFns ...func(*ec2.Options)) (*ec2.DeleteTagsOutput, error) {
+	rs, err := c.b.begin("ec2", c.region, "DeleteTags")
+	defer c.b.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, id := range params.Resources {
+		if !rs.hasEC2Resource(id) {
+			return nil, &smithy.GenericAPIError{Code: "InvalidID", Message: fmt.Sprintf("The ID '%s' is not valid", id)}
+		}
+	}
+	for _, id := range params.Resources {
+		for _, tag := range params.Tags {
+			key := aws.ToString(tag.Key)
+			if tag.Value != nil && rs.tags[id][key] != aws.ToString(tag.Value) {
+				continue
+			}
+			delete(rs.tags[id], key)
+		}
+	}
+	return &ec2.DeleteTagsOutput{}, nil
+}
@@ -0,0 +1,172 @@
+package fakeaws
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/efs"
+	efstypes "github.com/aws/aws-sdk-go-v2/service/efs/types"
+)
+
+type efsClient struct {
+	b      *Backend
+	region string
+}
+
+func efsTags(tags map[string]string) []efstypes.Tag {
+	out := make([]efstypes.Tag, 0, len(tags))
+	for _, k := range sortedKeys(tags) {
+		out = append(out, efstypes.Tag{Key: aws.String(k), Value: aws.String(tags[k])})
+	}
+	return out
+}
+
+func efsARN(region, kind, id string) string {
+	return fmt.Sprintf("arn:aws:elasticfilesystem:%s:%s:%s/%s", region, AccountID, kind, id)
+}
+
+func (c *efsClient) DescribeFileSystems(ctx context.Context, params *efs.DescribeFileSystemsInput, optFns ...func(*efs.Options)) (*efs.DescribeFileSystemsOutput, error) {
+	rs, err := c.b.begin("efs", c.region, "DescribeFileSystems")
+	defer c.b.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	matched := []EFSFileSystem{}
+	for _, fs := range rs.efsFileSystems {
+		if params.FileSystemId != nil && aws.ToString(params.FileSystemId) != fs.ID {
+			continue
+		}
+		matched = append(matched, fs)
+	}
+	if params.FileSystemId != nil && len(matched) == 0 {
+		return nil, fmt.Errorf("FileSystemNotFound: File system '%s' does not exist.", aws.ToString(params.FileSystemId))
+	}
+
+	start, end, next, err := c.b.page(len(matched), params.Marker)
+	if err != nil {
+		return nil, err
+	}
+
+	out := &efs.DescribeFileSystemsOutput{Marker: params.Marker, NextMarker: next}
+	for _, fs := range matched[start:end] {
+		desc := efstypes.FileSystemDescription{
+			FileSystemId:  aws.String(fs.ID),
+			FileSystemArn: aws.String(efsARN(c.region, "file-system", fs.ID)),
+			Tags:          efsTags(rs.tags[fs.ID]),
+		}
+		if fs.Name != "" {
+			desc.Name = aws.String(fs.Name)
+		}
+		out.FileSystems = append(out.FileSystems, desc)
+	}
+	return out, nil
+}
+
+func (c *efsClient) DescribeAccessPoints(ctx context.Context, params *efs.DescribeAccessPointsInput, optFns ...func(*efs.Options)) (*efs.DescribeAccessPointsOutput, error) {
+	rs, err := c.b.begin("efs", c.region, "DescribeAccessPoints")
+	defer c.b.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	matched := []EFSAccessPoint{}
+	for _, ap := range rs.efsAccessPoints {
+		if params.FileSystemId != nil && aws.ToString(params.FileSystemId) != ap.FileSystemID {
+			continue
+		}
+		if params.AccessPointId != nil && aws.ToString(params.AccessPointId) != ap.ID {
+			continue
+		}
+		matched = append(matched, ap)
+	}
+
+	start, end, next, err := c.b.page(len(matched), params.NextToken)
+	if err != nil {
+		return nil, err
+	}
+
+	out := &efs.DescribeAccessPointsOutput{NextToken: next}
+	for _, ap := range matched[start:end] {
+		desc := efstypes.AccessPointDescription{
+			AccessPointId:  aws.String(ap.ID),
+			AccessPointArn: aws.String(efsARN(c.region, "access-point", ap.ID)),
+			FileSystemId:   aws.String(ap.FileSystemID),
+			Tags:           efsTags(rs.tags[ap.ID]),
+		}
+		if ap.Name != "" {
+			desc.Name = aws.String(ap.Name)
+		}
+		out.AccessPoints = append(out.AccessPoints, desc)
+	}
+	return out, nil
+}
+
+func (c *efsClient) ListTagsForResource(ctx context.Context, params *efs.ListTagsForResourceInput, optFns ...func(*efs.Options)) (*efs.ListTagsForResourceOutput, error) {
+	rs, err := c.b.begin("efs", c.region, "ListTagsForResource")
+	defer c.b.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	tags, ok := rs.tags[aws.ToString(params.ResourceId)]
+	if !ok || !rs.isEFS(aws.ToString(params.ResourceId)) {
+		return nil, fmt.Errorf("ResourceNotFound: '%s' does not exist.", aws.ToString(params.ResourceId))
+	}
+
+	all := efsTags(tags)
+	start, end, next, err := c.b.page(len(all), params.NextToken)
+	if err != nil {
+		return nil, err
+	}
+	return &efs.ListTagsForResourceOutput{Tags: all[start:end], NextToken: next}, nil
+}
+
+func (c *efsClient) TagResource(ctx context.Context, params *efs.TagResourceInput, optFns ...func(*efs.Options)) (*efs.TagResourceOutput, error) {
+	rs, err := c.b.begin("efs", c.region, "TagResource")
+	defer c.b.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	id := aws.ToString(params.ResourceId)
+	if !rs.isEFS(id) {
+		return nil, fmt.Errorf("ResourceNotFound: '%s' does not exist.", id)
+	}
+	for _, tag := range params.Tags {
+		rs.tags[id][aws.ToString(tag.Key)] = aws.ToString(tag.Value)
+	}
+	return &efs.TagResourceOutput{}, nil
+}
+
+func (rs *regionState) isEFS(id string) bool {
+	for _, fs := range rs.efsFileSystems {
+		if fs.ID == id {
+			return true
+		}
+	}
+	for _, ap := range rs.efsAccessPoints {
+		if ap.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *efsClient) UntagResource(ctx context.Context, params *efs.UntagResourceInput, optFns ...func(*efs.Options)) (*efs.UntagResourceOutput, error) {
+	rs, err := c.b.begin("efs", c.region, "UntagResource")
+	defer c.b.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	id := aws.ToString(params.ResourceId)
+	if !rs.isEFS(id) {
+		return nil, fmt.Errorf("ResourceNotFound: '%s' does not exist.", id)
+	}
+	for _, key := range params.TagKeys {
+		delete(rs.tags[id], key)
+	}
+	return &efs.UntagResourceOutput{}, nil
+}
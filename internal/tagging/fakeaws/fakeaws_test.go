@@ -0,0 +1,98 @@
+package fakeaws
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	ec2types "github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+func TestDescribeSnapshots_Paginates(t *testing.T) {
+	b := New()
+	b.PageSize = 2
+	for _, id := range []string{"snap-1", "snap-2", "snap-3", "snap-4", "snap-5"} {
+		b.AddSnapshot("us-east-1", Snapshot{ID: id, VolumeID: "vol-1"})
+	}
+
+	paginator := ec2.NewDescribeSnapshotsPaginator(b.EC2("us-east-1"), &ec2.DescribeSnapshotsInput{})
+	pages, total := 0, 0
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(context.Background())
+		if err != nil {
+			t.Fatalf("NextPage: %v", err)
+		}
+		pages++
+		total += len(page.Snapshots)
+	}
+
+	if pages != 3 || total != 5 {
+		t.Errorf("expected 5 snapshots over 3 pages, got %d over %d", total, pages)
+	}
+}
+
+func TestDescribeInstances_FiltersByStateAndTag(t *testing.T) {
+	b := New()
+	b.AddInstance("us-east-1", Instance{ID: "i-1", Tags: map[string]string{"Env": "prod"}})
+	b.AddInstance("us-east-1", Instance{ID: "i-2", Tags: map[string]string{"Env": "dev"}})
+	b.AddInstance("us-east-1", Instance{ID: "i-3", State: ec2types.InstanceStateNameTerminated, Tags: map[string]string{"Env": "prod"}})
+
+	out, err := b.EC2("us-east-1").DescribeInstances(context.Background(), &ec2.DescribeInstancesInput{
+		Filters: []ec2types.Filter{
+			{Name: aws.String("instance-state-name"), Values: []string{"running", "stopped"}},
+			{Name: aws.String("tag:Env"), Values: []string{"pr*"}},
+		},
+	})
+	if err != nil {
+		t.Fatalf("DescribeInstances: %v", err)
+	}
+
+	if len(out.Reservations) != 1 || aws.ToString(out.Reservations[0].Instances[0].InstanceId) != "i-1" {
+		t.Errorf("expected only i-1 to match, got %#v", out.Reservations)
+	}
+}
+
+func TestCreateTags_MutatesTagsAndRecordsCalls(t *testing.T) {
+	b := New()
+	b.AddVolume("eu-west-1", Volume{ID: "vol-1", Tags: map[string]string{"Name": "data"}})
+
+	_, err := b.EC2("eu-west-1").CreateTags(context.Background(), &ec2.CreateTagsInput{
+		Resources: []string{"vol-1"},
+		Tags:      []ec2types.Tag{{Key: aws.String("data"), Value: aws.String("")}},
+	})
+	if err != nil {
+		t.Fatalf("CreateTags: %v", err)
+	}
+
+	tags := b.Tags("eu-west-1", "vol-1")
+	if _, ok := tags["data"]; !ok || tags["Name"] != "data" {
+		t.Errorf("unexpected tags after CreateTags: %v", tags)
+	}
+	if n := b.CallCount("ec2:CreateTags"); n != 1 {
+		t.Errorf("expected 1 CreateTags call, got %d", n)
+	}
+
+	_, err = b.EC2("eu-west-1").CreateTags(context.Background(), &ec2.CreateTagsInput{
+		Resources: []string{"vol-missing"},
+	})
+	if err == nil {
+		t.Errorf("expected error tagging an unknown resource")
+	}
+}
+
+func TestFail_InjectsErrorsForLimitedCalls(t *testing.T) {
+	b := New()
+	b.AddInstance("us-east-1", Instance{ID: "i-1"})
+	throttled := errors.New("RequestLimitExceeded")
+	b.Fail("ec2:DescribeInstances", 1, throttled)
+
+	client := b.EC2("us-east-1")
+	if _, err := client.DescribeInstances(context.Background(), &ec2.DescribeInstancesInput{}); !errors.Is(err, throttled) {
+		t.Fatalf("expected injected error, got %v", err)
+	}
+	if _, err := client.DescribeInstances(context.Background(), &ec2.DescribeInstancesInput{}); err != nil {
+		t.Fatalf("expected second call to succeed, got %v", err)
+	}
+}
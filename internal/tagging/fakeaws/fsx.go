@@ -0,0 +1,182 @@
+package fakeaws
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/fsx"
+	fsxtypes "github.com/aws/aws-sdk-go-v2/service/fsx/types"
+)
+
+type fsxClient struct {
+	b      *Backend
+	region string
+}
+
+func fsxTags(tags map[string]string) []fsxtypes.Tag {
+	out := make([]fsxtypes.Tag, 0, len(tags))
+	for _, k := range sortedKeys(tags) {
+		out = append(out, fsxtypes.Tag{Key: aws.String(k), Value: aws.String(tags[k])})
+	}
+	return out
+}
+
+func (c *fsxClient) DescribeFileSystems(ctx context.Context, params *fsx.DescribeFileSystemsInput, optFns ...func(*fsx.Options)) (*fsx.DescribeFileSystemsOutput, error) {
+	rs, err := c.b.begin("fsx", c.region, "DescribeFileSystems")
+	defer c.b.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	matched := []FSxFileSystem{}
+	for _, fs := range rs.fsxFileSystems {
+		if len(params.FileSystemIds) > 0 && !contains(params.FileSystemIds, fs.ID) {
+			continue
+		}
+		matched = append(matched, fs)
+	}
+
+	start, end, next, err := c.b.page(len(matched), params.NextToken)
+	if err != nil {
+		return nil, err
+	}
+
+	out := &fsx.DescribeFileSystemsOutput{NextToken: next}
+	for _, fs := range matched[start:end] {
+		arn := FSxARN(c.region, "file-system", fs.ID)
+		out.FileSystems = append(out.FileSystems, fsxtypes.FileSystem{
+			FileSystemId: aws.String(fs.ID),
+			ResourceARN:  aws.String(arn),
+			Tags:         fsxTags(rs.tags[arn]),
+		})
+	}
+	return out, nil
+}
+
+func (c *fsxClient) DescribeBackups(ctx context.Context, params *fsx.DescribeBackupsInput, optFns ...func(*fsx.Options)) (*fsx.DescribeBackupsOutput, error) {
+	rs, err := c.b.begin("fsx", c.region, "DescribeBackups")
+	defer c.b.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	matched := []FSxBackup{}
+	for _, backup := range rs.fsxBackups {
+		if len(params.BackupIds) > 0 && !contains(params.BackupIds, backup.ID) {
+			continue
+		}
+		matched = append(matched, backup)
+	}
+
+	start, end, next, err := c.b.page(len(matched), params.NextToken)
+	if err != nil {
+		return nil, err
+	}
+
+	out := &fsx.DescribeBackupsOutput{NextToken: next}
+	for _, backup := range matched[start:end] {
+		arn := FSxARN(c.region, "backup", backup.ID)
+		out.Backups = append(out.Backups, fsxtypes.Backup{
+			BackupId:    aws.String(backup.ID),
+			ResourceARN: aws.String(arn),
+			Tags:        fsxTags(rs.tags[arn]),
+		})
+	}
+	return out, nil
+}
+
+func (c *fsxClient) DescribeVolumes(ctx context.Context, params *fsx.DescribeVolumesInput, optFns ...func(*fsx.Options)) (*fsx.DescribeVolumesOutput, error) {
+	rs, err := c.b.begin("fsx", c.region, "DescribeVolumes")
+	defer c.b.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	matched := []FSxVolume{}
+	for _, vol := range rs.fsxVolumes {
+		if len(params.VolumeIds) > 0 && !contains(params.VolumeIds, vol.ID) {
+			continue
+		}
+		matched = append(matched, vol)
+	}
+
+	start, end, next, err := c.b.page(len(matched), params.NextToken)
+	if err != nil {
+		return nil, err
+	}
+
+	out := &fsx.DescribeVolumesOutput{NextToken: next}
+	for _, vol := range matched[start:end] {
+		arn := FSxARN(c.region, "volume", vol.ID)
+		volume := fsxtypes.Volume{
+			VolumeId:    aws.String(vol.ID),
+			ResourceARN: aws.String(arn),
+			Tags:        fsxTags(rs.tags[arn]),
+		}
+		if vol.FileSystemID != "" {
+			volume.FileSystemId = aws.String(vol.FileSystemID)
+		}
+		if vol.Name != "" {
+			volume.Name = aws.String(vol.Name)
+		}
+		out.Volumes = append(out.Volumes, volume)
+	}
+	return out, nil
+}
+
+func (c *fsxClient) ListTagsForResource(ctx context.Context, params *fsx.ListTagsForResourceInput, optFns ...func(*fsx.Options)) (*fsx.ListTagsForResourceOutput, error) {
+	rs, err := c.b.begin("fsx", c.region, "ListTagsForResource")
+	defer c.b.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	tags, ok := rs.tags[aws.ToString(params.ResourceARN)]
+	if !ok {
+		return nil, fmt.Errorf("ResourceNotFound: '%s' does not exist.", aws.ToString(params.ResourceARN))
+	}
+
+	all := fsxTags(tags)
+	start, end, next, err := c.b.page(len(all), params.NextToken)
+	if err != nil {
+		return nil, err
+	}
+	return &fsx.ListTagsForResourceOutput{Tags: all[start:end], NextToken: next}, nil
+}
+
+func (c *fsxClient) TagResource(ctx context.Context, params *fsx.TagResourceInput, optFns ...func(*fsx.Options)) (*fsx.TagResourceOutput, error) {
+	rs, err := c.b.begin("fsx", c.region, "TagResource")
+	defer c.b.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	arn := aws.ToString(params.ResourceARN)
+	tags, ok := rs.tags[arn]
+	if !ok {
+		return nil, fmt.Errorf("ResourceNotFound: '%s' does not exist.", arn)
+	}
+	for _, tag := range params.Tags {
+		tags[aws.ToString(tag.Key)] = aws.ToString(tag.Value)
+	}
+	return &fsx.TagResourceOutput{}, nil
+}
+
+func (c *fsxClient) UntagResource(ctx context.Context, params *fsx.UntagResourceInput, optFns ...func(*fsx.Options)) (*fsx.UntagResourceOutput, error) {
+	rs, err := c.b.begin("fsx", c.region, "UntagResource")
+	defer c.b.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	arn := aws.ToString(params.ResourceARN)
+	tags, ok := rs.tags[arn]
+	if !ok {
+		return nil, fmt.Errorf("ResourceNotFound: '%s' does not exist.", arn)
+	}
+	for _, key := range params.TagKeys {
+		delete(tags, key)
+	}
+	return &fsx.UntagResourceOutput{}, nil
+}
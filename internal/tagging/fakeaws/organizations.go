@@ -0,0 +1,45 @@
+package fakeaws
+
+import (
+	"context"
+	"sort"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/organizations"
+	orgtypes "github.com/aws/aws-sdk-go-v2/service/organizations/types"
+)
+
+type organizationsClient struct {
+	b *Backend
+}
+
+// ListAccounts lists the backend's own account and its member accounts, the
+// organization of the backend
+func (c *organizationsClient) ListAccounts(ctx context.Context, params *organizations.ListAccountsInput, optFns ...func(*organizations.Options)) (*organizations.ListAccountsOutput, error) {
+	_, err := c.b.begin("organizations", "", "ListAccounts")
+	defer c.b.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	ids := []string{c.b.id}
+	for id := range c.b.accounts {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	start, end, next, err := c.b.page(len(ids), params.NextToken)
+	if err != nil {
+		return nil, err
+	}
+
+	out := &organizations.ListAccountsOutput{NextToken: next}
+	for _, id := range ids[start:end] {
+		status := orgtypes.AccountStatusActive
+		if c.b.suspended[id] {
+			status = orgtypes.AccountStatusSuspended
+		}
+		out.Accounts = append(out.Accounts, orgtypes.Account{Id: aws.String(id), Status: status})
+	}
+	return out, nil
+}
@@ -0,0 +1,40 @@
+package fakeaws
+
+import (
+	"context"
+	"sort"
+
+	"github.com/aws/aws-sdk-go-v2/service/resourcegroupstaggingapi"
+)
+
+type taggingClient struct {
+	b      *Backend
+	region string
+}
+
+// GetTagKeys lists the keys on any resource of the region, of every service
+func (c *taggingClient) GetTagKeys(ctx context.Context, params *resourcegroupstaggingapi.GetTagKeysInput, optFns ...func(*resourcegroupstaggingapi.Options)) (*resourcegroupstaggingapi.GetTagKeysOutput, error) {
+	rs, err := c.b.begin("tagging", c.region, "GetTagKeys")
+	defer c.b.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]bool)
+	keys := []string{}
+	for _, tags := range rs.tags {
+		for key := range tags {
+			if !seen[key] {
+				seen[key] = true
+				keys = append(keys, key)
+			}
+		}
+	}
+	sort.Strings(keys)
+
+	start, end, next, err := c.b.page(len(keys), params.PaginationToken)
+	if err != nil {
+		return nil, err
+	}
+	return &resourcegroupstaggingapi.GetTagKeysOutput{TagKeys: keys[start:end], PaginationToken: next}, nil
+}
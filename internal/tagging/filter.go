@@ -0,0 +1,89 @@
+package tagging
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+// TagFilter selects resources by tag. Value may use the '*' and '?'
+// wildcards; with AnyValue, any resource holding Key matches.
+type TagFilter struct {
+	Key      string
+	Value    string
+	AnyValue bool
+}
+
+// ParseTagFilters parses a comma-separated --tag-filter or --exclude-tag
+// value. "Key=Value" matches a value and a bare "Key" any value.
+func ParseTagFilters(v string) ([]TagFilter, error) {
+	filters := []TagFilter{}
+	for _, item := range splitList(v) {
+		key, value, hasValue := strings.Cut(item, "=")
+		key = strings.TrimSpace(key)
+		if key == "" {
+			return nil, fmt.Errorf("invalid tag filter %q (expected Key=Value or Key)", item)
+		}
+		filters = append(filters, TagFilter{Key: key, Value: strings.TrimSpace(value), AnyValue: !hasValue})
+	}
+	return filters, nil
+}
+
+// matches reports whether a resource with tags matches the filter
+func (f TagFilter) matches(tags map[string]string) bool {
+	value, ok := tags[f.Key]
+	return ok && (f.AnyValue || globRegexp(f.Value).MatchString(value))
+}
+
+// selected reports whether a resource is in the selection of the options:
+// its ID is listed in FilterIDs, its Name matches FilterName, and it matches
+// every TagFilters and none of ExcludeTags. ARNs are also listed by their
+// last element, such as the file system ID of an FSx ARN.
+func (e *Engine) selected(tags map[string]string, id string) bool {
+	if len(e.opts.FilterIDs) > 0 {
+		short := id[strings.LastIndex(id, "/")+1:]
+		if !contains(e.opts.FilterIDs, id) && !contains(e.opts.FilterIDs, short) {
+			return false
+		}
+	}
+	if e.opts.FilterName != "" {
+		name, ok := tags["Name"]
+		if !ok || !globRegexp(e.opts.FilterName).MatchString(name) {
+			return false
+		}
+	}
+	for _, f := range e.opts.TagFilters {
+		if !f.matches(tags) {
+			return false
+		}
+	}
+	for _, f := range e.opts.ExcludeTags {
+		if f.matches(tags) {
+			return false
+		}
+	}
+	return true
+}
+
+// ec2Filters returns the server-side EC2 filters of the selection, with
+// idFilter the filter name matching the resource IDs, such as "instance-id".
+// Exclusions have no EC2 filter and are left to selected.
+func (e *Engine) ec2Filters(idFilter string) []types.Filter {
+	filters := []types.Filter{}
+	if len(e.opts.FilterIDs) > 0 {
+		filters = append(filters, types.Filter{Name: aws.String(idFilter), Values: e.opts.FilterIDs})
+	}
+	if e.opts.FilterName != "" {
+		filters = append(filters, types.Filter{Name: aws.String("tag:Name"), Values: []string{e.opts.FilterName}})
+	}
+	for _, f := range e.opts.TagFilters {
+		if f.AnyValue {
+			filters = append(filters, types.Filter{Name: aws.String("tag-key"), Values: []string{f.Key}})
+		} else {
+			filters = append(filters, types.Filter{Name: aws.String("tag:" + f.Key), Values: []string{f.Value}})
+		}
+	}
+	return filters
+}
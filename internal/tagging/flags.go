@@ -0,0 +1,298 @@
+package tagging
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// FlagUsage lists the flags accepted by ParseFlags
+const FlagUsage = "--apply, --tag-storage, --fix-orphans, --output text|json|ndjson|csv, --plan-out <file>, --journal <file>, --propagate-keys <k1,k2>, --propagate-conflict keep|overwrite|error, --policy <file>, --min-compliance <percent>, --machine-key-value empty|id|name|<template>, --migrate-machine-key, --migrate-stale-keys, --migrate-unjournaled-keys, --concurrency <n>, --max-attempts <n>, --failed-out <file>, --keys <pattern1,pattern2>, --from-journal <file1,file2>, --ids <id1,id2>, --name <glob>, --tag-filter <Key=Value,Key>, --exclude-tag <Key=Value,Key>, --exclude-file <file>, --accounts org|<id|role-arn,...>, --role-name <name>, --include-keys <glob1,glob2>, --exclude-keys <glob1,glob2>, --reconcile, --regions auto|all|<r1,r2>, --include-regions <glob1,glob2>, --exclude-regions <glob1,glob2>, --refresh-regions, --profile <name>, --role-arn <arn>, --mfa-serial <arn>, --endpoint-url <url>"
+
+// ParseFlags applies command-line flags to opts. Flags that take a value
+// accept both "--flag value" and "--flag=value".
+func ParseFlags(opts *Options, flags []string) error {
+	boolFlags := map[string]*bool{
+		"--apply":                    &opts.Apply,
+		"--tag-storage":              &opts.TagStorage,
+		"--fix-orphans":              &opts.FixOrphans,
+		"--migrate-machine-key":      &opts.MigrateMachineKey,
+		"--migrate-stale-keys":       &opts.MigrateStaleKeys,
+		"--migrate-unjournaled-keys": &opts.MigrateUnjournaledKeys,
+		"--refresh-regions":          &opts.RefreshRegions,
+		"--reconcile":                &opts.Reconcile,
+	}
+	valueFlags := map[string]func(string) error{
+		"--output": func(v string) error {
+			format, err := ParseOutputFormat(v)
+			if err != nil {
+				return err
+			}
+			opts.Output = format
+			return nil
+		},
+		"--plan-out": func(v string) error {
+			opts.PlanOut = v
+			return nil
+		},
+		"--journal": func(v string) error {
+			opts.Journal = v
+			return nil
+		},
+		"--propagate-keys": func(v string) error {
+			opts.PropagateKeys = splitList(v)
+			return nil
+		},
+		"--propagate-conflict": func(v string) error {
+			policy, err := ParseConflictPolicy(v)
+			if err != nil {
+				return err
+			}
+			opts.ConflictPolicy = policy
+			return nil
+		},
+		"--policy": func(v string) error {
+			opts.PolicyFile = v
+			return nil
+		},
+		"--min-compliance": func(v string) error {
+			pct, err := strconv.ParseFloat(strings.TrimSuffix(v, "%"), 64)
+			if err != nil || pct < 0 || pct > 100 {
+				return fmt.Errorf("invalid --min-compliance %q (expected a percentage between 0 and 100)", v)
+			}
+			opts.MinCompliance = pct
+			return nil
+		},
+		"--concurrency": func(v string) error {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 1 {
+				return fmt.Errorf("invalid --concurrency %q (expected a positive number)", v)
+			}
+			opts.Concurrency = n
+			return nil
+		},
+		"--max-attempts": func(v string) error {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 1 {
+				return fmt.Errorf("invalid --max-attempts %q (expected a positive number)", v)
+			}
+			opts.MaxAttempts = n
+			return nil
+		},
+		"--keys": func(v string) error {
+			opts.CleanupKeys = splitList(v)
+			return nil
+		},
+		"--from-journal": func(v string) error {
+			opts.FromJournals = splitList(v)
+			return nil
+		},
+		"--ids": func(v string) error {
+			opts.FilterIDs = splitList(v)
+			return nil
+		},
+		"--name": func(v string) error {
+			opts.FilterName = v
+			return nil
+		},
+		"--tag-filter": func(v string) error {
+			filters, err := ParseTagFilters(v)
+			if err != nil {
+				return err
+			}
+			opts.TagFilters = filters
+			return nil
+		},
+		"--exclude-tag": func(v string) error {
+			filters, err := ParseTagFilters(v)
+			if err != nil {
+				return err
+			}
+			opts.ExcludeTags = filters
+			return nil
+		},
+		"--exclude-file": func(v string) error {
+			opts.ExcludeFile = v
+			return nil
+		},
+		"--accounts": func(v string) error {
+			opts.Accounts = splitList(v)
+			return nil
+		},
+		"--role-name": func(v string) error {
+			opts.AccountRole = v
+			return nil
+		},
+		"--include-keys": func(v string) error {
+			opts.IncludeKeys = splitList(v)
+			return nil
+		},
+		"--exclude-keys": func(v string) error {
+			opts.ExcludeKeys = splitList(v)
+			return nil
+		},
+		"--regions": func(v string) error {
+			if v == RegionsAuto || v == RegionsAll {
+				opts.RegionDiscovery = v
+				return nil
+			}
+			regions := splitList(v)
+			valid := len(regions) > 0
+			for _, region := range regions {
+				valid = valid && regionPattern.MatchString(region)
+			}
+			if !valid {
+				return fmt.Errorf("invalid --regions %q (expected auto, all or a list of regions such as us-east-1,eu-west-1)", v)
+			}
+			opts.Regions = regions
+			return nil
+		},
+		"--include-regions": func(v string) error {
+			opts.IncludeRegions = splitList(v)
+			return nil
+		},
+		"--exclude-regions": func(v string) error {
+			opts.ExcludeRegions = splitList(v)
+			return nil
+		},
+		"--profile": func(v string) error {
+			opts.Profile = v
+			return nil
+		},
+		"--role-arn": func(v string) error {
+			if !roleARNPattern.MatchString(v) {
+				return fmt.Errorf("invalid --role-arn %q (expected an IAM role ARN)", v)
+			}
+			opts.RoleARN = v
+			return nil
+		},
+		"--mfa-serial": func(v string) error {
+			opts.MFASerial = v
+			return nil
+		},
+		"--endpoint-url": func(v string) error {
+			u, err := url.Parse(v)
+			if err != nil || u.Scheme == "" || u.Host == "" {
+				return fmt.Errorf("invalid --endpoint-url %q (expected a URL such as http://localhost:4566)", v)
+			}
+			opts.EndpointURL = v
+			return nil
+		},
+		"--failed-out": func(v string) error {
+			opts.FailedOut = v
+			return nil
+		},
+		"--machine-key-value": func(v string) error {
+			value, err := ParseMachineKeyValue(v)
+			if err != nil {
+				return err
+			}
+			opts.MachineKeyValue = value
+			return nil
+		},
+	}
+
+	for i := 0; i < len(flags); i++ {
+		name, value, hasValue := strings.Cut(flags[i], "=")
+
+		if p, ok := boolFlags[name]; ok {
+			if hasValue {
+				return fmt.Errorf("flag %s does not take a value", name)
+			}
+			*p = true
+			continue
+		}
+
+		set, ok := valueFlags[name]
+		if !ok {
+			return fmt.Errorf("unknown flag: %s", name)
+		}
+		if !hasValue {
+			if i+1 >= len(flags) || strings.HasPrefix(flags[i+1], "--") {
+				return fmt.Errorf("flag %s requires a value", name)
+			}
+			i++
+			value = flags[i]
+		}
+		if err := set(value); err != nil {
+			return err
+		}
+	}
+
+	if opts.Mode == ModeAudit && opts.Apply {
+		return fmt.Errorf("audit only reads tags and cannot be combined with --apply")
+	}
+	if opts.Mode == ModeCleanup && len(opts.CleanupKeys) == 0 && len(opts.FromJournals) == 0 {
+		return fmt.Errorf("cleanup needs --keys or --from-journal to know which tags to remove")
+	}
+	for _, ref := range opts.Accounts {
+		if ref == AccountsOrganization {
+			continue
+		}
+		if _, err := ParseAccount(ref, opts.AccountRole); err != nil {
+			return err
+		}
+	}
+	if (len(opts.IncludeKeys) > 0 || len(opts.ExcludeKeys) > 0) && opts.Mode != ModeActivate {
+		return fmt.Errorf("--include-keys and --exclude-keys select the keys activate works on")
+	}
+	if opts.Reconcile && (opts.Mode != ModeActivate || len(opts.IncludeKeys) == 0) {
+		return fmt.Errorf("--reconcile needs activate and --include-keys, the keys to keep active")
+	}
+	if opts.Reconcile && (opts.RegionDiscovery == "" || len(opts.IncludeRegions) > 0 || len(opts.ExcludeRegions) > 0) {
+		return fmt.Errorf("--reconcile needs the tag keys of every region: use --regions auto or all, without --include-regions or --exclude-regions")
+	}
+	if opts.MFASerial != "" && opts.RoleARN == "" {
+		return fmt.Errorf("--mfa-serial is used when assuming --role-arn, which is missing")
+	}
+	if len(opts.FromJournals) > 0 && opts.Mode != ModeCleanup && !migratesStaleKeys(opts.Mode) {
+		return fmt.Errorf("--from-journal applies to cleanup and to the stale machine keys of modes that tag EC2 instances (all, set, dry-run, ec2)")
+	}
+	if opts.MigrateStaleKeys && !migratesStaleKeys(opts.Mode) {
+		return fmt.Errorf("--migrate-stale-keys only applies to modes that tag EC2 instances (all, set, dry-run, ec2)")
+	}
+	if opts.MigrateUnjournaledKeys && !opts.MigrateStaleKeys {
+		return fmt.Errorf("--migrate-unjournaled-keys is an option of --migrate-stale-keys, which is missing")
+	}
+	if opts.MigrateStaleKeys && len(opts.FromJournals) == 0 && !opts.MigrateUnjournaledKeys {
+		return fmt.Errorf("--migrate-stale-keys needs --from-journal to know which keys the tool wrote, or --migrate-unjournaled-keys to migrate them all")
+	}
+	if opts.MigrateMachineKey && opts.MachineKeyValue == "" {
+		return fmt.Errorf("--migrate-machine-key needs a non-empty --machine-key-value to migrate to")
+	}
+	if opts.FailedOut != "" && !opts.Apply && opts.Mode != ModeApplyPlan {
+		return fmt.Errorf("--failed-out saves the resources an apply run failed to tag and needs --apply")
+	}
+	if opts.PlanOut != "" && opts.Apply {
+		return fmt.Errorf("--plan-out saves a dry-run plan and cannot be combined with --apply")
+	}
+
+	// Every run that writes tags keeps a journal so it can be undone
+	if opts.Journal == "" && opts.Mode != ModeUndo && (opts.Apply || opts.Mode == ModeApplyPlan) {
+		opts.Journal = DefaultJournalPath(time.Now())
+	}
+	return nil
+}
+
+// migratesStaleKeys reports whether mode processes instances and can migrate
+// their stale machine keys
+func migratesStaleKeys(mode Mode) bool {
+	switch mode {
+	case ModeAll, ModeSet, ModeDryRun, ModeEC2:
+		return true
+	}
+	return false
+}
+
+// splitList splits a comma-separated flag value, dropping empty items
+func splitList(v string) []string {
+	items := []string{}
+	for _, item := range strings.Split(v, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -0,0 +1,382 @@
+package tagging
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseFlags_BooleanAndValueFlags(t *testing.T) {
+	cases := []struct {
+		name  string
+		flags []string
+		want  OutputFormat
+	}{
+		{"separate value", []string{"--apply", "--output", "json"}, OutputJSON},
+		{"inline value", []string{"--apply", "--output=csv"}, OutputCSV},
+		{"case insensitive", []string{"--output", "NDJSON", "--apply"}, OutputNDJSON},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			opts := DefaultOptions()
+			if err := ParseFlags(&opts, tc.flags); err != nil {
+				t.Fatalf("ParseFlags returned error: %v", err)
+			}
+			if !opts.Apply {
+				t.Errorf("expected Apply to be set")
+			}
+			if opts.Output != tc.want {
+				t.Errorf("expected Output %q, got %q", tc.want, opts.Output)
+			}
+		})
+	}
+}
+
+func TestParseFlags_Errors(t *testing.T) {
+	cases := []struct {
+		name  string
+		flags []string
+	}{
+		{"unknown flag", []string{"--bogus"}},
+		{"missing value", []string{"--output"}},
+		{"value is another flag", []string{"--output", "--apply"}},
+		{"bad format", []string{"--output", "xml"}},
+		{"value on boolean flag", []string{"--apply=false"}},
+		{"missing policy file", []string{"--policy"}},
+		{"compliance out of range", []string{"--min-compliance", "120"}},
+		{"compliance not a number", []string{"--min-compliance=most"}},
+		{"zero concurrency", []string{"--concurrency", "0"}},
+		{"zero attempts", []string{"--max-attempts=0"}},
+		{"failed-out without apply", []string{"--failed-out", "failed.json"}},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			opts := DefaultOptions()
+			if err := ParseFlags(&opts, tc.flags); err == nil {
+				t.Errorf("expected an error for %v", tc.flags)
+			}
+		})
+	}
+}
+
+func TestParseFlags_PlanOutRequiresDryRun(t *testing.T) {
+	opts := DefaultOptions()
+	if err := ParseFlags(&opts, []string{"--plan-out", "plan.json"}); err != nil {
+		t.Fatalf("ParseFlags returned error: %v", err)
+	}
+	if opts.PlanOut != "plan.json" {
+		t.Errorf("expected PlanOut to be plan.json, got %q", opts.PlanOut)
+	}
+
+	opts = DefaultOptions()
+	if err := ParseFlags(&opts, []string{"--apply", "--plan-out=plan.json"}); err == nil {
+		t.Errorf("expected --plan-out with --apply to be rejected")
+	}
+}
+
+func TestParseFlags_ApplyDefaultsJournal(t *testing.T) {
+	opts := DefaultOptions()
+	if err := ParseFlags(&opts, []string{"--apply"}); err != nil {
+		t.Fatalf("ParseFlags returned error: %v", err)
+	}
+	if !strings.HasPrefix(opts.Journal, "tagging-journal-") {
+		t.Errorf("expected a default journal path, got %q", opts.Journal)
+	}
+
+	opts = DefaultOptions()
+	if err := ParseFlags(&opts, nil); err != nil {
+		t.Fatalf("ParseFlags returned error: %v", err)
+	}
+	if opts.Journal != "" {
+		t.Errorf("expected no journal in dry-run, got %q", opts.Journal)
+	}
+}
+
+func TestParseFlags_PropagateKeys(t *testing.T) {
+	opts := DefaultOptions()
+	err := ParseFlags(&opts, []string{"--propagate-keys", "CostCenter, Owner,,Project", "--propagate-conflict=overwrite"})
+	if err != nil {
+		t.Fatalf("ParseFlags returned error: %v", err)
+	}
+	want := []string{"CostCenter", "Owner", "Project"}
+	if strings.Join(opts.PropagateKeys, ",") != strings.Join(want, ",") {
+		t.Errorf("expected PropagateKeys %v, got %v", want, opts.PropagateKeys)
+	}
+	if opts.ConflictPolicy != ConflictOverwrite {
+		t.Errorf("expected ConflictPolicy %q, got %q", ConflictOverwrite, opts.ConflictPolicy)
+	}
+
+	if err := ParseFlags(&opts, []string{"--propagate-conflict", "merge"}); err == nil {
+		t.Errorf("expected an unknown conflict policy to be rejected")
+	}
+}
+
+func TestParseFlags_Audit(t *testing.T) {
+	opts := DefaultOptions()
+	opts.Mode = ModeAudit
+	if err := ParseFlags(&opts, []string{"--min-compliance", "95%"}); err != nil {
+		t.Fatalf("ParseFlags returned error: %v", err)
+	}
+	if opts.MinCompliance != 95 {
+		t.Errorf("expected MinCompliance 95, got %v", opts.MinCompliance)
+	}
+
+	opts = DefaultOptions()
+	opts.Mode = ModeAudit
+	if err := ParseFlags(&opts, []string{"--apply"}); err == nil {
+		t.Errorf("expected audit --apply to be rejected")
+	}
+}
+
+func TestParseFlags_MachineKeyValue(t *testing.T) {
+	cases := map[string]string{
+		"empty":            "",
+		"id":               "{id}",
+		"name":             "{name}",
+		"{region}/{id}":    "{region}/{id}",
+		"managed-by-coaws": "managed-by-coaws",
+	}
+	for in, want := range cases {
+		opts := DefaultOptions()
+		if err := ParseFlags(&opts, []string{"--machine-key-value", in}); err != nil {
+			t.Fatalf("%s: ParseFlags returned error: %v", in, err)
+		}
+		if opts.MachineKeyValue != want {
+			t.Errorf("%s: expected MachineKeyValue %q, got %q", in, want, opts.MachineKeyValue)
+		}
+	}
+
+	opts := DefaultOptions()
+	if err := ParseFlags(&opts, []string{"--machine-key-value", "{owner}"}); err == nil {
+		t.Errorf("expected an unknown placeholder to be rejected")
+	}
+	opts = DefaultOptions()
+	if err := ParseFlags(&opts, []string{"--migrate-machine-key"}); err == nil {
+		t.Errorf("expected --migrate-machine-key without a value to be rejected")
+	}
+}
+
+func TestParseFlags_Retries(t *testing.T) {
+	opts := DefaultOptions()
+	if opts.MaxAttempts != DefaultMaxAttempts {
+		t.Errorf("expected %d attempts by default, got %d", DefaultMaxAttempts, opts.MaxAttempts)
+	}
+	if err := ParseFlags(&opts, []string{"--apply", "--max-attempts", "8", "--failed-out=failed.json"}); err != nil {
+		t.Fatalf("ParseFlags returned error: %v", err)
+	}
+	if opts.MaxAttempts != 8 || opts.FailedOut != "failed.json" {
+		t.Errorf("unexpected options %+v", opts)
+	}
+
+	// apply-plan writes tags without --apply
+	opts = DefaultOptions()
+	opts.Mode = ModeApplyPlan
+	if err := ParseFlags(&opts, []string{"--failed-out", "failed.json"}); err != nil {
+		t.Errorf("expected --failed-out to be accepted by apply-plan, got %v", err)
+	}
+}
+
+func TestParseFlags_Cleanup(t *testing.T) {
+	opts := DefaultOptions()
+	opts.Mode = ModeCleanup
+	if err := ParseFlags(&opts, []string{"--apply"}); err == nil {
+		t.Error("expected cleanup without --keys or --from-journal to be rejected")
+	}
+
+	opts = DefaultOptions()
+	opts.Mode = ModeCleanup
+	if err := ParseFlags(&opts, []string{"--keys", "old-*, tmp-?", "--from-journal=a.ndjson", "--apply"}); err != nil {
+		t.Fatalf("ParseFlags returned error: %v", err)
+	}
+	if len(opts.CleanupKeys) != 2 || opts.CleanupKeys[1] != "tmp-?" || len(opts.FromJournals) != 1 {
+		t.Errorf("unexpected options %+v", opts)
+	}
+	if !strings.HasPrefix(opts.Journal, "tagging-journal-") {
+		t.Errorf("expected cleanup to journal the tags it removes, got %q", opts.Journal)
+	}
+}
+
+func TestParseFlags_MigrateStaleKeys(t *testing.T) {
+	opts := DefaultOptions()
+	opts.Mode = ModeEC2
+	if err := ParseFlags(&opts, []string{"--migrate-stale-keys", "--from-journal", "a.ndjson", "--apply"}); err != nil {
+		t.Fatalf("ParseFlags returned error: %v", err)
+	}
+	if !opts.MigrateStaleKeys || opts.MigrateUnjournaledKeys {
+		t.Error("expected only MigrateStaleKeys to be set")
+	}
+
+	// Without a journal, nothing is known to be written by the tool
+	opts = DefaultOptions()
+	opts.Mode = ModeEC2
+	if err := ParseFlags(&opts, []string{"--migrate-stale-keys", "--apply"}); err == nil {
+		t.Error("expected --migrate-stale-keys without --from-journal to be rejected")
+	}
+	opts = DefaultOptions()
+	opts.Mode = ModeEC2
+	if err := ParseFlags(&opts, []string{"--migrate-stale-keys", "--migrate-unjournaled-keys"}); err != nil {
+		t.Fatalf("ParseFlags returned error: %v", err)
+	}
+	if !opts.MigrateUnjournaledKeys {
+		t.Error("expected MigrateUnjournaledKeys to be set")
+	}
+	opts = DefaultOptions()
+	opts.Mode = ModeEC2
+	if err := ParseFlags(&opts, []string{"--migrate-unjournaled-keys"}); err == nil {
+		t.Error("expected --migrate-unjournaled-keys without --migrate-stale-keys to be rejected")
+	}
+
+	for _, mode := range []Mode{ModeAudit, ModeSnapshots, ModeCleanup} {
+		opts := DefaultOptions()
+		opts.Mode = mode
+		if err := ParseFlags(&opts, []string{"--migrate-stale-keys", "--keys", "x"}); err == nil {
+			t.Errorf("%s: expected --migrate-stale-keys to be rejected", mode)
+		}
+	}
+
+	opts = DefaultOptions()
+	opts.Mode = ModeAudit
+	if err := ParseFlags(&opts, []string{"--from-journal", "a.ndjson"}); err == nil {
+		t.Error("expected --from-journal to be rejected outside cleanup and instance modes")
+	}
+}
+
+func TestParseFlags_Selection(t *testing.T) {
+	opts := DefaultOptions()
+	flags := []string{"--ids", "i-0aaa, vol-0bbb", "--name=web-*", "--tag-filter", "Env=prod,Team", "--exclude-tag", "Backup=skip"}
+	if err := ParseFlags(&opts, flags); err != nil {
+		t.Fatalf("ParseFlags returned error: %v", err)
+	}
+	if len(opts.FilterIDs) != 2 || opts.FilterIDs[1] != "vol-0bbb" || opts.FilterName != "web-*" {
+		t.Errorf("unexpected options %+v", opts)
+	}
+	want := []TagFilter{{Key: "Env", Value: "prod"}, {Key: "Team", AnyValue: true}}
+	if len(opts.TagFilters) != 2 || opts.TagFilters[0] != want[0] || opts.TagFilters[1] != want[1] {
+		t.Errorf("unexpected tag filters %+v", opts.TagFilters)
+	}
+	if len(opts.ExcludeTags) != 1 || opts.ExcludeTags[0].Value != "skip" {
+		t.Errorf("unexpected exclusions %+v", opts.ExcludeTags)
+	}
+
+	opts = DefaultOptions()
+	if err := ParseFlags(&opts, []string{"--tag-filter", "=prod"}); err == nil {
+		t.Error("expected a tag filter without a key to be rejected")
+	}
+}
+
+func TestParseFlags_Accounts(t *testing.T) {
+	opts := DefaultOptions()
+	flags := []string{"--accounts", "111111111111,arn:aws:iam::222222222222:role/Tagger", "--role-name=Tagging"}
+	if err := ParseFlags(&opts, flags); err != nil {
+		t.Fatalf("ParseFlags returned error: %v", err)
+	}
+	if len(opts.Accounts) != 2 || opts.AccountRole != "Tagging" {
+		t.Errorf("unexpected options %+v", opts)
+	}
+	account, err := ParseAccount(opts.Accounts[0], opts.AccountRole)
+	if err != nil || account.RoleARN != "arn:aws:iam::111111111111:role/Tagging" {
+		t.Errorf("unexpected account %+v (%v)", account, err)
+	}
+
+	opts = DefaultOptions()
+	if err := ParseFlags(&opts, []string{"--accounts", "org"}); err != nil || opts.Accounts[0] != AccountsOrganization {
+		t.Errorf("expected --accounts org to be accepted, got %v (%v)", opts.Accounts, err)
+	}
+	opts = DefaultOptions()
+	if err := ParseFlags(&opts, []string{"--accounts", "1234"}); err == nil {
+		t.Error("expected an invalid account ID to be rejected")
+	}
+	opts = DefaultOptions()
+	opts.Mode = ModeActivate
+	if err := ParseFlags(&opts, []string{"--accounts", "111111111111"}); err != nil {
+		t.Errorf("expected activate to read the tag keys of member accounts, got %v", err)
+	}
+}
+
+func TestParseFlags_Credentials(t *testing.T) {
+	opts := DefaultOptions()
+	flags := []string{"--profile", "prod", "--role-arn=arn:aws:iam::111111111111:role/Tagging", "--mfa-serial", "arn:aws:iam::999999999999:mfa/ops", "--endpoint-url", "http://localhost:4566"}
+	if err := ParseFlags(&opts, flags); err != nil {
+		t.Fatalf("ParseFlags returned error: %v", err)
+	}
+	if opts.Profile != "prod" || opts.RoleARN == "" || opts.MFASerial == "" || opts.EndpointURL != "http://localhost:4566" {
+		t.Errorf("unexpected options %+v", opts)
+	}
+
+	for _, flags := range [][]string{
+		{"--role-arn", "Tagging"},
+		{"--endpoint-url", "localhost:4566"},
+		{"--mfa-serial", "arn:aws:iam::999999999999:mfa/ops"},
+	} {
+		opts = DefaultOptions()
+		if err := ParseFlags(&opts, flags); err == nil {
+			t.Errorf("expected %v to be rejected", flags)
+		}
+	}
+}
+
+func TestParseFlags_Regions(t *testing.T) {
+	opts := DefaultOptions()
+	flags := []string{"--regions", "auto", "--include-regions", "us-*,eu-*", "--exclude-regions=eu-south-*", "--refresh-regions"}
+	if err := ParseFlags(&opts, flags); err != nil {
+		t.Fatalf("ParseFlags returned error: %v", err)
+	}
+	if opts.RegionDiscovery != RegionsAuto || len(opts.IncludeRegions) != 2 || opts.ExcludeRegions[0] != "eu-south-*" || !opts.RefreshRegions {
+		t.Errorf("unexpected options %+v", opts)
+	}
+
+	opts = DefaultOptions()
+	if err := ParseFlags(&opts, []string{"--regions", "us-east-1, ap-east-1"}); err != nil {
+		t.Fatalf("ParseFlags returned error: %v", err)
+	}
+	if len(opts.Regions) != 2 || opts.Regions[1] != "ap-east-1" || opts.RegionDiscovery != "" {
+		t.Errorf("unexpected options %+v", opts)
+	}
+
+	for _, v := range []string{"us-east", "", "everywhere"} {
+		opts = DefaultOptions()
+		if err := ParseFlags(&opts, []string{"--regions", v}); err == nil {
+			t.Errorf("expected --regions %q to be rejected", v)
+		}
+	}
+}
+
+func TestParseFlags_ActivateKeys(t *testing.T) {
+	opts := DefaultOptions()
+	opts.Mode = ModeActivate
+	if err := ParseFlags(&opts, []string{"--include-keys", "Team,Env", "--exclude-keys=web-*"}); err != nil {
+		t.Fatalf("ParseFlags returned error: %v", err)
+	}
+	if len(opts.IncludeKeys) != 2 || opts.ExcludeKeys[0] != "web-*" {
+		t.Errorf("unexpected options %+v", opts)
+	}
+
+	opts = DefaultOptions()
+	if err := ParseFlags(&opts, []string{"--include-keys", "Team"}); err == nil {
+		t.Error("expected --include-keys to be rejected outside activate")
+	}
+}
+
+func TestParseFlags_Reconcile(t *testing.T) {
+	opts := DefaultOptions()
+	opts.Mode = ModeActivate
+	if err := ParseFlags(&opts, []string{"--include-keys", "web-*", "--reconcile", "--regions", "all"}); err != nil || !opts.Reconcile {
+		t.Fatalf("expected --reconcile to be accepted, got %v", err)
+	}
+
+	// Keys in regions left out would be deactivated
+	for _, regions := range [][]string{{}, {"--regions", "us-east-1"}, {"--regions", "auto", "--exclude-regions", "ap-*"}} {
+		opts = DefaultOptions()
+		opts.Mode = ModeActivate
+		if err := ParseFlags(&opts, append([]string{"--include-keys", "web-*", "--reconcile"}, regions...)); err == nil {
+			t.Errorf("%v: expected --reconcile to be rejected without every region", regions)
+		}
+	}
+
+	opts = DefaultOptions()
+	opts.Mode = ModeActivate
+	if err := ParseFlags(&opts, []string{"--reconcile", "--regions", "auto"}); err == nil {
+		t.Error("expected --reconcile without --include-keys to be rejected")
+	}
+}
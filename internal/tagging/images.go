@@ -0,0 +1,102 @@
+package tagging
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+// imageIndex holds the self-owned AMIs of a region and their lineage: the
+// snapshots backing each AMI, from its block device mappings, and the
+// instance it was created from.
+type imageIndex struct {
+	images     []types.Image
+	bySnapshot map[string]int
+	byInstance map[string][]int
+	err        error
+}
+
+// newImageIndex indexes images. The source instance of an AMI is the
+// SourceInstanceId DescribeImages returns for AMIs made with CreateImage;
+// copied AMIs and AMIs registered from snapshots have none.
+func newImageIndex(images []types.Image) *imageIndex {
+	idx := &imageIndex{
+		images:     images,
+		bySnapshot: make(map[string]int),
+		byInstance: make(map[string][]int),
+	}
+	for i, image := range images {
+		for _, snapshotID := range imageSnapshotIDs(image) {
+			idx.bySnapshot[snapshotID] = i
+		}
+		if source := aws.ToString(image.SourceInstanceId); source != "" {
+			idx.byInstance[source] = append(idx.byInstance[source], i)
+		}
+	}
+	return idx
+}
+
+// imageSnapshotIDs returns the EBS snapshots backing an AMI, in device order
+func imageSnapshotIDs(image types.Image) []string {
+	ids := []string{}
+	for _, mapping := range image.BlockDeviceMappings {
+		if mapping.Ebs != nil && mapping.Ebs.SnapshotId != nil {
+			ids = append(ids, aws.ToString(mapping.Ebs.SnapshotId))
+		}
+	}
+	return ids
+}
+
+// ofInstance returns the AMIs created from the instance
+func (idx *imageIndex) ofInstance(instanceID string) []types.Image {
+	images := []types.Image{}
+	for _, i := range idx.byInstance[instanceID] {
+		images = append(images, idx.images[i])
+	}
+	return images
+}
+
+// ofSnapshot returns the AMI a snapshot backs, if any
+func (idx *imageIndex) ofSnapshot(snapshotID string) (types.Image, bool) {
+	i, ok := idx.bySnapshot[snapshotID]
+	if !ok {
+		return types.Image{}, false
+	}
+	return idx.images[i], true
+}
+
+// imageIndex returns the image index of rr's region, listing the region's
+// AMIs on first use. Like the snapshot index, a listing error is warned about
+// once and the AMIs listed before it are still indexed.
+func (e *Engine) imageIndex(ctx context.Context, client EC2API, rr *RegionReport) *imageIndex {
+	rs := e.regionSnapshots(rr.Region)
+	rs.imagesOnce.Do(func() {
+		images, err := listImages(ctx, client)
+		rs.images = newImageIndex(images)
+		if err != nil {
+			rs.images.err = err
+			rr.warn(fmt.Sprintf("Failed to describe images: %v", err))
+		}
+	})
+	return rs.images
+}
+
+// listImages pages through the self-owned AMIs of a region
+func listImages(ctx context.Context, client EC2API) ([]types.Image, error) {
+	var images []types.Image
+	input := &ec2.DescribeImagesInput{Owners: []string{"self"}}
+	for {
+		page, err := client.DescribeImages(ctx, input)
+		if err != nil {
+			return images, err
+		}
+		images = append(images, page.Images...)
+		if aws.ToString(page.NextToken) == "" {
+			return images, nil
+		}
+		input.NextToken = page.NextToken
+	}
+}
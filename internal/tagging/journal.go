@@ -0,0 +1,298 @@
+package tagging
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/aws/aws-sdk-go-v2/service/efs"
+	efstypes "github.com/aws/aws-sdk-go-v2/service/efs/types"
+	"github.com/aws/aws-sdk-go-v2/service/fsx"
+	fsxtypes "github.com/aws/aws-sdk-go-v2/service/fsx/types"
+)
+
+// JournalEntry records one tag written or removed by an apply run.
+// Previous is nil when the key did not exist before the write; Removed
+// entries have no Value.
+type JournalEntry struct {
+	Time     time.Time `json:"time"`
+	Account  string    `json:"account,omitempty"`
+	Region   string    `json:"region"`
+	Type     string    `json:"type"`
+	ID       string    `json:"id"`
+	Key      string    `json:"key"`
+	Previous *string   `json:"previous,omitempty"`
+	Value    string    `json:"value"`
+	Removed  bool      `json:"removed,omitempty"`
+}
+
+// DefaultJournalPath returns a timestamped journal file name in the working directory
+func DefaultJournalPath(now time.Time) string {
+	return fmt.Sprintf("tagging-journal-%s.ndjson", now.UTC().Format("20060102-150405"))
+}
+
+// journal appends entries to a journal file, one JSON object per line,
+// so that a run interrupted halfway can still be undone. It is shared by
+// the regions processed concurrently.
+type journal struct {
+	mu  sync.Mutex
+	f   *os.File
+	enc *json.Encoder
+	err error
+}
+
+func openJournal(path string) (*journal, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("cannot open journal: %w", err)
+	}
+	return &journal{f: f, enc: json.NewEncoder(f)}, nil
+}
+
+func (j *journal) Close() error {
+	return j.f.Close()
+}
+
+// record journals the tags just written to res. Once a write fails the
+// journal stays broken, and later writes are refused by journalError.
+func (e *Engine) record(res *ResourceResult, tags []Tag) {
+	entries := make([]JournalEntry, len(tags))
+	for i, tag := range tags {
+		entries[i] = JournalEntry{Key: tag.Key, Value: tag.Value}
+	}
+	e.writeJournal(res, entries)
+}
+
+// recordRemovals journals the keys just removed from res with the value
+// they had, so that undo can put them back
+func (e *Engine) recordRemovals(res *ResourceResult, keys []string) {
+	entries := make([]JournalEntry, len(keys))
+	for i, key := range keys {
+		entries[i] = JournalEntry{Key: key, Removed: true}
+	}
+	e.writeJournal(res, entries)
+}
+
+// writeJournal completes entries with the resource they belong to and
+// appends them to the journal
+func (e *Engine) writeJournal(res *ResourceResult, entries []JournalEntry) {
+	if e.journal == nil {
+		return
+	}
+	e.journal.mu.Lock()
+	defer e.journal.mu.Unlock()
+	if e.journal.err != nil {
+		return
+	}
+
+	now := time.Now().UTC()
+	for _, entry := range entries {
+		entry.Time, entry.Account, entry.Region, entry.Type, entry.ID = now, res.Account, res.Region, res.Type, res.ID
+		if prev, ok := res.ExistingTags[entry.Key]; ok {
+			entry.Previous = aws.String(prev)
+		}
+		if err := e.journal.enc.Encode(entry); err != nil {
+			e.journal.err = fmt.Errorf("cannot write journal: %w", err)
+			return
+		}
+	}
+}
+
+// journalError returns the error that stops further writes, if any
+func (e *Engine) journalError() error {
+	if e.journal == nil {
+		return nil
+	}
+	e.journal.mu.Lock()
+	defer e.journal.mu.Unlock()
+	return e.journal.err
+}
+
+// ReadJournal loads the entries of a journal file, in the order they were written
+func ReadJournal(path string) ([]JournalEntry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var entries []JournalEntry
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var entry JournalEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return nil, fmt.Errorf("invalid journal %s line %d: %w", path, line, err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries, scanner.Err()
+}
+
+// undoTarget is a resource touched by a journal, with the original value
+// of each key and the value the run left behind, nil for removed keys
+type undoTarget struct {
+	account, region, resourceType, id string
+	keys                              []string
+	previous                          map[string]*string
+	final                             map[string]*string
+}
+
+// runUndo reverts the tags recorded in a journal. Keys that were added are
+// removed, and keys that were overwritten or removed get their previous
+// value back. A key whose value changed since the run is left alone.
+func (e *Engine) runUndo(ctx context.Context) error {
+	entries, err := ReadJournal(e.opts.UndoFile)
+	if err != nil {
+		return err
+	}
+
+	// The first entry for a key holds its original value, the last one
+	// what the resource should still have now
+	targets := []*undoTarget{}
+	index := make(map[string]*undoTarget)
+	for _, entry := range entries {
+		ref := entry.Account + "|" + entry.Region + "|" + entry.ID
+		t, ok := index[ref]
+		if !ok {
+			t = &undoTarget{
+				account:      entry.Account,
+				region:       entry.Region,
+				resourceType: entry.Type,
+				id:           entry.ID,
+				previous:     make(map[string]*string),
+				final:        make(map[string]*string),
+			}
+			index[ref] = t
+			targets = append(targets, t)
+		}
+		if _, seen := t.final[entry.Key]; !seen {
+			t.keys = append(t.keys, entry.Key)
+			t.previous[entry.Key] = entry.Previous
+		}
+		t.final[entry.Key] = nil
+		if !entry.Removed {
+			t.final[entry.Key] = aws.String(entry.Value)
+		}
+	}
+
+	defer e.useAccount(ctx, "")
+	for _, t := range targets {
+		rr := e.report.region(t.account, t.region)
+		var current map[string]string
+		err := e.useAccount(ctx, t.account)
+		if err == nil {
+			current, err = e.currentTags(ctx, t.region, t.resourceType, t.id)
+		}
+		res := rr.add(t.resourceType, t.id, current)
+		if err != nil {
+			res.Error = fmt.Sprintf("cannot read current tags: %v", err)
+			continue
+		}
+
+		restore := []Tag{}
+		remove := []string{}
+		written := make(map[string]string)
+		for _, key := range t.keys {
+			value, ok := current[key]
+			prev, final := t.previous[key], t.final[key]
+			if ok != (final != nil) || (ok && value != *final) {
+				rr.warn(fmt.Sprintf("%s %s: tag %s changed since it was applied, leaving it", t.resourceType, res.ShortID(), key))
+				continue
+			}
+			switch {
+			case prev != nil && (final == nil || *final != *prev):
+				restore = append(restore, Tag{Key: key, Value: *prev})
+			case prev == nil && final != nil:
+				remove = append(remove, key)
+				written[key] = *final
+			}
+		}
+
+		e.removeOrPlan(ctx, res, remove, written)
+		if res.Error == "" {
+			e.applyTags(ctx, res, restore)
+		}
+	}
+	return nil
+}
+
+// applyTags plans or applies tags on any supported resource type
+func (e *Engine) applyTags(ctx context.Context, res *ResourceResult, tags []Tag) {
+	switch serviceOf(res.Type) {
+	case "EFS":
+		efsTags := make([]efstypes.Tag, len(tags))
+		for i, tag := range tags {
+			efsTags[i] = efstypes.Tag{Key: aws.String(tag.Key), Value: aws.String(tag.Value)}
+		}
+		e.planOrApplyEFS(ctx, e.clients.EFS(res.Region), res, efsTags)
+	case "FSx":
+		fsxTags := make([]fsxtypes.Tag, len(tags))
+		for i, tag := range tags {
+			fsxTags[i] = fsxtypes.Tag{Key: aws.String(tag.Key), Value: aws.String(tag.Value)}
+		}
+		e.planOrApplyFSx(ctx, e.clients.FSx(res.Region), res, fsxTags)
+	default:
+		ec2Tags := make([]types.Tag, len(tags))
+		for i, tag := range tags {
+			ec2Tags[i] = types.Tag{Key: aws.String(tag.Key), Value: aws.String(tag.Value)}
+		}
+		e.planOrApply(ctx, e.clients.EC2(res.Region), res, ec2Tags)
+	}
+}
+
+// removeOrPlan either plans or removes tag keys from a resource, and
+// journals the removed keys with their values. For EC2 the delete only
+// matches the expected values, so a concurrent change is kept.
+func (e *Engine) removeOrPlan(ctx context.Context, res *ResourceResult, keys []string, expected map[string]string) {
+	if len(keys) == 0 || e.skipProtected(res) {
+		return
+	}
+
+	res.PlannedRemovals = append(res.PlannedRemovals, keys...)
+	if !e.opts.Apply {
+		return
+	}
+	if err := e.journalError(); err != nil {
+		res.Error = err.Error()
+		return
+	}
+
+	var err error
+	switch serviceOf(res.Type) {
+	case "EFS":
+		_, err = e.clients.EFS(res.Region).UntagResource(ctx, &efs.UntagResourceInput{
+			ResourceId: aws.String(res.ID),
+			TagKeys:    keys,
+		})
+	case "FSx":
+		_, err = e.clients.FSx(res.Region).UntagResource(ctx, &fsx.UntagResourceInput{
+			ResourceARN: aws.String(res.ID),
+			TagKeys:     keys,
+		})
+	default:
+		tags := make([]types.Tag, len(keys))
+		for i, key := range keys {
+			tags[i] = types.Tag{Key: aws.String(key), Value: aws.String(expected[key])}
+		}
+		_, err = e.clients.EC2(res.Region).DeleteTags(ctx, &ec2.DeleteTagsInput{
+			Resources: []string{res.ID},
+			Tags:      tags,
+		})
+	}
+	if err != nil {
+		res.Error = err.Error()
+		return
+	}
+	res.RemovedTags = res.PlannedRemovals
+	e.recordRemovals(res, keys)
+}
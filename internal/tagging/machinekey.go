@@ -0,0 +1,61 @@
+package tagging
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// machineKeyPlaceholder matches the {placeholders} of a machine-key value template
+var machineKeyPlaceholder = regexp.MustCompile(`\{[^{}]*\}`)
+
+// ParseMachineKeyValue validates a --machine-key-value setting. The presets
+// "empty", "id" and "name" stand for "", "{id}" and "{name}"; anything else
+// is a template using {id}, {name}, {key} and {region}.
+func ParseMachineKeyValue(v string) (string, error) {
+	switch v {
+	case "empty":
+		return "", nil
+	case "id", "name":
+		return "{" + v + "}", nil
+	}
+
+	for _, p := range machineKeyPlaceholder.FindAllString(v, -1) {
+		switch p {
+		case "{id}", "{name}", "{key}", "{region}":
+		default:
+			return "", fmt.Errorf("unknown placeholder %s in machine key value (expected {id}, {name}, {key} or {region})", p)
+		}
+	}
+	return v, nil
+}
+
+// machineKeyValue returns the value written for a machine key. id is the
+// machine the key belongs to: the instance for its volumes and snapshots,
+// otherwise the resource itself.
+func (e *Engine) machineKeyValue(region, key, name, id string) string {
+	if e.opts.MachineKeyValue == "" {
+		return ""
+	}
+	return strings.NewReplacer(
+		"{id}", id,
+		"{name}", name,
+		"{key}", key,
+		"{region}", region,
+	).Replace(e.opts.MachineKeyValue)
+}
+
+// machineKeyTag returns the machine-key value to write on a resource and
+// whether it needs writing. An existing key is left alone, unless it is
+// empty and MigrateMachineKey rewrites it with the configured value.
+func (e *Engine) machineKeyTag(currentTags map[string]string, region, key, name, id string) (string, bool) {
+	value := e.machineKeyValue(region, key, name, id)
+	current, exists := currentTags[key]
+	switch {
+	case !exists:
+		return value, true
+	case e.opts.MigrateMachineKey && current == "" && value != "":
+		return value, true
+	}
+	return "", false
+}
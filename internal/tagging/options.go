@@ -15,6 +15,10 @@ const (
 	ModeFSx      Mode = "fsx"
 	ModeEFS      Mode = "efs"
 	ModeDryRun   Mode = "dry-run"
+	ModeApplyPlan Mode = "apply-plan"
+	ModeUndo     Mode = "undo"
+	ModeAudit    Mode = "audit"
+	ModeCleanup  Mode = "cleanup"
 )
 
 // Options contains all configuration for the tagging engine
@@ -25,6 +29,16 @@ type Options struct {
 	TagStorage  bool
 	FixOrphans  bool
 	Regions     []string
+
+	// RegionDiscovery (RegionsAuto or RegionsAll) finds the regions with
+	// DescribeRegions when none are given, caching them in RegionCacheFile
+	// unless RefreshRegions. IncludeRegions and ExcludeRegions are globs
+	// narrowing down the regions, whichever way they were chosen.
+	RegionDiscovery string
+	RegionCacheFile string
+	RefreshRegions  bool
+	IncludeRegions  []string
+	ExcludeRegions  []string
 	
 	// Resource-specific flags
 	TagInstances  bool
@@ -32,6 +46,96 @@ type Options struct {
 	TagSnapshots  bool
 	TagEFS        bool
 	TagFSx        bool
+
+	// Output is the format the run report is rendered in
+	Output OutputFormat
+
+	// PlanOut is where a dry-run saves its plan; PlanFile is the plan
+	// applied by ModeApplyPlan
+	PlanOut  string
+	PlanFile string
+
+	// Journal records every tag written by an apply run; UndoFile is the
+	// journal reverted by ModeUndo
+	Journal  string
+	UndoFile string
+
+	// PropagateKeys are instance tags copied onto volumes and snapshots
+	PropagateKeys  []string
+	ConflictPolicy ConflictPolicy
+
+	// PolicyFile is a YAML or JSON tag policy whose defaults are planned
+	// alongside Name and the machine key
+	PolicyFile string
+
+	// MinCompliance is the percentage of compliant resources below which
+	// ModeAudit fails
+	MinCompliance float64
+
+	// MachineKeyValue is the value template written for machine keys ("" keeps
+	// them empty); MigrateMachineKey rewrites existing empty machine keys with it
+	MachineKeyValue   string
+	MigrateMachineKey bool
+
+	// MigrateStaleKeys removes the machine keys instances got under an
+	// earlier Name, from them, their volumes, snapshots and AMIs, once the
+	// current key is written. Only keys FromJournals show the tool wrote
+	// are removed, unless MigrateUnjournaledKeys takes every possibly stale
+	// key for a stale one.
+	MigrateStaleKeys       bool
+	MigrateUnjournaledKeys bool
+
+	// Concurrency is the number of regions processed at once
+	Concurrency int
+
+	// MaxAttempts is how many times an AWS call is tried before it fails,
+	// retries included
+	MaxAttempts int
+
+	// CleanupKeys are key patterns ('*' and '?' wildcards) removed by
+	// ModeCleanup. FromJournals are apply journals: cleanup removes the keys
+	// they added from the resources they were written to, and they show
+	// which stale machine keys the tool wrote and may migrate
+	CleanupKeys  []string
+	FromJournals []string
+
+	// FilterIDs, FilterName (a Name glob), TagFilters and ExcludeTags select
+	// the resources a mode works on; the volumes, snapshots and AMIs of a
+	// selected instance follow it
+	FilterIDs   []string
+	FilterName  string
+	TagFilters  []TagFilter
+	ExcludeTags []TagFilter
+
+	// ExcludeFile lists the IDs and ARNs of resources never to change, like
+	// those tagged with ProtectTag
+	ExcludeFile string
+
+	// Accounts are the member accounts to run in, by account ID or role
+	// ARN, or AccountsOrganization for those of the organization; accounts
+	// given by ID are entered through AccountRole. ModeActivate only reads
+	// their tag keys.
+	Accounts    []string
+	AccountRole string
+
+	// IncludeKeys and ExcludeKeys are globs selecting the tag keys activate
+	// works on. With Reconcile, activate also deactivates the active keys
+	// they do not select, and the selected keys no resource holds anymore
+	// in any region of RegionDiscovery and any account of the organization.
+	IncludeKeys []string
+	ExcludeKeys []string
+	Reconcile   bool
+
+	// Profile, RoleARN and MFASerial choose the credentials of the run, and
+	// EndpointURL overrides the endpoint of every service, as for LocalStack
+	Profile     string
+	RoleARN     string
+	MFASerial   string
+	EndpointURL string
+
+	// FailedOut is where an apply run saves a plan of the resources it
+	// failed to tag, to be re-run with apply-plan
+	FailedOut string
 }
 
 // DefaultOptions returns options with safe defaults (dry-run mode)
@@ -46,5 +150,12 @@ func DefaultOptions() Options {
 		TagSnapshots: true,
 		TagEFS:       false,
 		TagFSx:       false,
+		Output:       OutputText,
+		ConflictPolicy: ConflictKeep,
+		MinCompliance: 100,
+		Concurrency:   1,
+		MaxAttempts:   DefaultMaxAttempts,
+		AccountRole:   DefaultAccountRole,
+		RegionCacheFile: DefaultRegionCacheFile(),
 	}
 }
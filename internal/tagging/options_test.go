@@ -37,6 +37,15 @@ func TestDefaultOptions_SafeDefaults(t *testing.T) {
 	if opts.TagFSx {
 		t.Errorf("expected TagFSx to be false by default")
 	}
+	if opts.Output != OutputText {
+		t.Errorf("expected Output to be %q by default, got %q", OutputText, opts.Output)
+	}
+	if opts.ConflictPolicy != ConflictKeep {
+		t.Errorf("expected ConflictPolicy to be %q by default, got %q", ConflictKeep, opts.ConflictPolicy)
+	}
+	if opts.Concurrency != 1 {
+		t.Errorf("expected Concurrency to be 1 by default, got %d", opts.Concurrency)
+	}
 }
 
 func TestMode_StringValues(t *testing.T) {
@@ -55,6 +64,10 @@ func TestMode_StringValues(t *testing.T) {
 		{ModeFSx, "fsx"},
 		{ModeEFS, "efs"},
 		{ModeDryRun, "dry-run"},
+		{ModeApplyPlan, "apply-plan"},
+		{ModeUndo, "undo"},
+		{ModeAudit, "audit"},
+		{ModeCleanup, "cleanup"},
 	}
 
 	for _, tc := range cases {
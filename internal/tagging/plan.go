@@ -0,0 +1,208 @@
+package tagging
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+// PlanVersion is the format version written to plan files
+const PlanVersion = 1
+
+// PlannedChange is a set of tags to add to one resource, and keys to remove
+// once they are written, along with the tags the resource had when the plan
+// was made
+type PlannedChange struct {
+	Account      string            `json:"account,omitempty"`
+	Region       string            `json:"region"`
+	Type         string            `json:"type"`
+	ID           string            `json:"id"`
+	ExistingTags map[string]string `json:"existing_tags"`
+	Tags         []Tag             `json:"tags"`
+	Remove       []string          `json:"remove,omitempty"`
+}
+
+// Plan is a saved dry-run that can be applied later with apply-plan
+type Plan struct {
+	Version    int             `json:"version"`
+	Mode       Mode            `json:"mode"`
+	TagStorage bool            `json:"tag_storage"`
+	Changes    []PlannedChange `json:"changes"`
+}
+
+// NewPlan builds a plan from the tag changes of a dry-run report
+func NewPlan(r *Report) *Plan {
+	return newPlan(r, r.Changes())
+}
+
+// NewFailedPlan builds a plan from the tags an apply run failed to write,
+// so that they can be retried with apply-plan. Resources whose tags could
+// not be read have nothing planned and are left out.
+func NewFailedPlan(r *Report) *Plan {
+	var failed []*ResourceResult
+	for _, res := range r.Failed() {
+		if len(res.PlannedTags) > 0 && len(res.AppliedTags) == 0 {
+			failed = append(failed, res)
+		}
+	}
+	return newPlan(r, failed)
+}
+
+func newPlan(r *Report, resources []*ResourceResult) *Plan {
+	plan := &Plan{Version: PlanVersion, Mode: r.Mode, TagStorage: r.TagStorage, Changes: []PlannedChange{}}
+	for _, res := range resources {
+		plan.Changes = append(plan.Changes, PlannedChange{
+			Account:      res.Account,
+			Region:       res.Region,
+			Type:         res.Type,
+			ID:           res.ID,
+			ExistingTags: res.ExistingTags,
+			Tags:         res.PlannedTags,
+			Remove:       res.PlannedRemovals,
+		})
+	}
+	return plan
+}
+
+// WritePlan saves a plan as JSON
+func WritePlan(path string, plan *Plan) error {
+	data, err := json.MarshalIndent(plan, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0o644)
+}
+
+// ReadPlan loads a plan written by WritePlan
+func ReadPlan(path string) (*Plan, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var plan Plan
+	if err := json.Unmarshal(data, &plan); err != nil {
+		return nil, fmt.Errorf("invalid plan file %s: %w", path, err)
+	}
+	if plan.Version != PlanVersion {
+		return nil, fmt.Errorf("unsupported plan version %d in %s", plan.Version, path)
+	}
+	return &plan, nil
+}
+
+// runApplyPlan applies a saved plan. Every resource is checked for drift
+// first; if any resource's tags changed since planning, nothing is applied.
+// Changes of member accounts are made with the role of their account.
+func (e *Engine) runApplyPlan(ctx context.Context) error {
+	plan, err := ReadPlan(e.opts.PlanFile)
+	if err != nil {
+		return err
+	}
+
+	e.opts.TagStorage = plan.TagStorage
+
+	results := make([]*ResourceResult, len(plan.Changes))
+	drifted := []string{}
+	defer e.useAccount(ctx, "")
+	for i, change := range plan.Changes {
+		rr := e.report.region(change.Account, change.Region)
+		var current map[string]string
+		err := e.useAccount(ctx, change.Account)
+		if err == nil {
+			current, err = e.currentTags(ctx, change.Region, change.Type, change.ID)
+		}
+		res := rr.add(change.Type, change.ID, current)
+		res.PlannedTags = change.Tags
+		results[i] = res
+
+		switch {
+		case err != nil:
+			res.Error = fmt.Sprintf("cannot read current tags: %v", err)
+			drifted = append(drifted, change.ID)
+		case !sameTags(current, change.ExistingTags):
+			res.Error = "tags drifted since the plan was made"
+			drifted = append(drifted, change.ID)
+		}
+	}
+
+	if len(drifted) > 0 {
+		return fmt.Errorf("refusing to apply plan: %d resources drifted since planning (%s)", len(drifted), strings.Join(drifted, ", "))
+	}
+
+	e.opts.Apply = true
+	for i, change := range plan.Changes {
+		res := results[i]
+		res.PlannedTags = nil
+		if err := e.useAccount(ctx, change.Account); err != nil {
+			res.Error = err.Error()
+			continue
+		}
+		e.applyTags(ctx, res, change.Tags)
+	}
+
+	// Keys are only removed from resources that got their new tags
+	e.flushTags(ctx, "")
+	for i, change := range plan.Changes {
+		if res := results[i]; res.Error == "" && e.useAccount(ctx, change.Account) == nil {
+			e.removeOrPlan(ctx, res, change.Remove, res.ExistingTags)
+		}
+	}
+	return nil
+}
+
+// serviceOf returns the AWS service a report resource type belongs to
+func serviceOf(resourceType string) string {
+	switch {
+	case strings.HasPrefix(resourceType, "EFS "):
+		return "EFS"
+	case strings.HasPrefix(resourceType, "FSx "):
+		return "FSx"
+	}
+	return "EC2"
+}
+
+// currentTags reads the tags a resource has right now
+func (e *Engine) currentTags(ctx context.Context, region, resourceType, id string) (map[string]string, error) {
+	switch serviceOf(resourceType) {
+	case "EFS":
+		return e.listTagsEFS(ctx, e.clients.EFS(region), id)
+	case "FSx":
+		return e.listTagsFSx(ctx, e.clients.FSx(region), id)
+	}
+
+	tags := make(map[string]string)
+	paginator := ec2.NewDescribeTagsPaginator(e.clients.EC2(region), &ec2.DescribeTagsInput{
+		Filters: []types.Filter{
+			{Name: aws.String("resource-id"), Values: []string{id}},
+		},
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, tag := range page.Tags {
+			tags[aws.ToString(tag.Key)] = aws.ToString(tag.Value)
+		}
+	}
+	return tags, nil
+}
+
+// sameTags reports whether two tag sets are identical
+func sameTags(a, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k, v := range a {
+		if bv, ok := b[k]; !ok || bv != v {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,168 @@
+package tagging
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// PolicyResourceTypes are the resource types a policy rule can target
+var PolicyResourceTypes = []string{
+	"EC2 Instance", "Volume", "Snapshot", "AMI",
+	"EFS FileSystem", "EFS AccessPoint",
+	"FSx FileSystem", "FSx Backup", "FSx Volume",
+}
+
+// TagRule describes one key of the organisation's tagging standard
+type TagRule struct {
+	Key           string   `json:"key" yaml:"key"`
+	Required      bool     `json:"required" yaml:"required"`
+	Default       string   `json:"default" yaml:"default"`
+	Allowed       []string `json:"allowed" yaml:"allowed"`
+	Pattern       string   `json:"pattern" yaml:"pattern"`
+	ResourceTypes []string `json:"resource_types" yaml:"resource_types"`
+
+	pattern *regexp.Regexp
+}
+
+// Policy is a declarative tag policy loaded with --policy
+type Policy struct {
+	Tags []*TagRule `json:"tags" yaml:"tags"`
+}
+
+// LoadPolicy reads a policy file. Files ending in .json are read as JSON,
+// anything else as YAML.
+func LoadPolicy(path string) (*Policy, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var policy Policy
+	if ext := strings.ToLower(filepath.Ext(path)); ext == ".json" {
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.DisallowUnknownFields()
+		err = dec.Decode(&policy)
+	} else {
+		dec := yaml.NewDecoder(bytes.NewReader(data))
+		dec.KnownFields(true)
+		// An empty document is an empty policy
+		if err = dec.Decode(&policy); errors.Is(err, io.EOF) {
+			err = nil
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid policy %s: %w", path, err)
+	}
+	if err := policy.validate(); err != nil {
+		return nil, fmt.Errorf("invalid policy %s: %w", path, err)
+	}
+	return &policy, nil
+}
+
+func (p *Policy) validate() error {
+	seen := make(map[string]bool)
+	for i, rule := range p.Tags {
+		if rule.Key == "" {
+			return fmt.Errorf("tags[%d]: key is required", i)
+		}
+		if seen[rule.Key] {
+			return fmt.Errorf("tags[%d]: duplicate key %q", i, rule.Key)
+		}
+		seen[rule.Key] = true
+
+		if rule.Pattern != "" {
+			re, err := regexp.Compile(rule.Pattern)
+			if err != nil {
+				return fmt.Errorf("%s: invalid pattern: %w", rule.Key, err)
+			}
+			rule.pattern = re
+		}
+		for _, t := range rule.ResourceTypes {
+			if !contains(PolicyResourceTypes, t) {
+				return fmt.Errorf("%s: unknown resource type %q (expected one of: %s)", rule.Key, t, strings.Join(PolicyResourceTypes, ", "))
+			}
+		}
+		if rule.Default != "" {
+			if reason := rule.Check(rule.Default); reason != "" {
+				return fmt.Errorf("%s: default %q %s", rule.Key, rule.Default, reason)
+			}
+		}
+	}
+	return nil
+}
+
+// AppliesTo reports whether the rule covers a report resource type
+func (r *TagRule) AppliesTo(resourceType string) bool {
+	if len(r.ResourceTypes) == 0 {
+		return true
+	}
+	// Orphan mode reports snapshots under their own type
+	if resourceType == "Orphaned Snapshot" {
+		resourceType = "Snapshot"
+	}
+	return contains(r.ResourceTypes, resourceType)
+}
+
+// Check returns why value violates the rule, or "" if it is valid
+func (r *TagRule) Check(value string) string {
+	if len(r.Allowed) > 0 && !contains(r.Allowed, value) {
+		return fmt.Sprintf("is not one of %s", strings.Join(r.Allowed, ", "))
+	}
+	if r.pattern != nil && !r.pattern.MatchString(value) {
+		return fmt.Sprintf("does not match %s", r.Pattern)
+	}
+	return ""
+}
+
+// policyTags returns the default tags the policy adds to a resource on top
+// of the tags already planned for it. Existing values are never changed;
+// violations and required keys without a default are reported as warnings.
+func (e *Engine) policyTags(res *ResourceResult) []Tag {
+	if e.policy == nil {
+		return nil
+	}
+
+	planned := make(map[string]bool)
+	for _, tag := range res.PlannedTags {
+		planned[tag.Key] = true
+	}
+
+	rr := res.region
+	tags := []Tag{}
+	for _, rule := range e.policy.Tags {
+		if !rule.AppliesTo(res.Type) || planned[rule.Key] {
+			continue
+		}
+
+		value, exists := res.ExistingTags[rule.Key]
+		switch {
+		case exists:
+			if reason := rule.Check(value); reason != "" {
+				rr.warn(fmt.Sprintf("%s %s: %s=%q %s", res.Type, res.ShortID(), rule.Key, value, reason))
+			}
+		case rule.Default != "":
+			tags = append(tags, Tag{Key: rule.Key, Value: rule.Default})
+		case rule.Required:
+			rr.warn(fmt.Sprintf("%s %s: missing required tag %s (no default in policy)", res.Type, res.ShortID(), rule.Key))
+		}
+	}
+	return tags
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
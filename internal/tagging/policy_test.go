@@ -0,0 +1,67 @@
+package tagging
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writePolicy(t *testing.T, name, data string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadPolicy_YAMLAndJSON(t *testing.T) {
+	yamlPath := writePolicy(t, "policy.yaml", "tags:\n  - key: Env\n    allowed: [prod, dev]\n    default: dev\n")
+	jsonPath := writePolicy(t, "policy.json", `{"tags": [{"key": "Env", "allowed": ["prod", "dev"], "default": "dev"}]}`)
+
+	for _, path := range []string{yamlPath, jsonPath} {
+		policy, err := LoadPolicy(path)
+		if err != nil {
+			t.Fatalf("%s: %v", path, err)
+		}
+		if len(policy.Tags) != 1 || policy.Tags[0].Key != "Env" || policy.Tags[0].Default != "dev" {
+			t.Errorf("%s: unexpected policy %+v", path, policy.Tags)
+		}
+	}
+}
+
+func TestLoadPolicy_Invalid(t *testing.T) {
+	cases := map[string]string{
+		"missing key":      "tags:\n  - required: true\n",
+		"duplicate key":    "tags:\n  - key: A\n  - key: A\n",
+		"bad pattern":      "tags:\n  - key: A\n    pattern: \"(\"\n",
+		"unknown type":     "tags:\n  - key: A\n    resource_types: [Bucket]\n",
+		"default enum":     "tags:\n  - key: A\n    allowed: [x]\n    default: y\n",
+		"default pattern":  "tags:\n  - key: A\n    pattern: \"^[0-9]+$\"\n    default: abc\n",
+		"unknown field":    "tags:\n  - key: A\n    requried: true\n",
+		"wrong value type": "tags:\n  - key: A\n    required: yes please\n",
+		"tab indent":       "tags:\n\t- key: A\n",
+		"yaml duplicate":   "tags:\n  - key: A\n    key: B\n",
+	}
+	for name, data := range cases {
+		if _, err := LoadPolicy(writePolicy(t, "policy.yaml", data)); err == nil {
+			t.Errorf("%s: expected an error", name)
+		} else if !strings.Contains(err.Error(), "invalid policy") {
+			t.Errorf("%s: unexpected error %v", name, err)
+		}
+	}
+}
+
+func TestTagRule_AppliesTo(t *testing.T) {
+	rule := &TagRule{Key: "A", ResourceTypes: []string{"Snapshot"}}
+	if !rule.AppliesTo("Snapshot") || !rule.AppliesTo("Orphaned Snapshot") {
+		t.Error("expected the rule to cover snapshots and orphaned snapshots")
+	}
+	if rule.AppliesTo("Volume") {
+		t.Error("expected the rule not to cover volumes")
+	}
+	if !(&TagRule{Key: "A"}).AppliesTo("FSx Backup") {
+		t.Error("a rule without resource_types should cover every type")
+	}
+}
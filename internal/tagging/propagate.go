@@ -0,0 +1,70 @@
+package tagging
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+// ConflictPolicy decides what happens when a volume or snapshot already has
+// a propagated key with a different value than its instance
+type ConflictPolicy string
+
+const (
+	ConflictKeep      ConflictPolicy = "keep"
+	ConflictOverwrite ConflictPolicy = "overwrite"
+	ConflictError     ConflictPolicy = "error"
+)
+
+// ParseConflictPolicy validates a --propagate-conflict value
+func ParseConflictPolicy(s string) (ConflictPolicy, error) {
+	switch p := ConflictPolicy(strings.ToLower(s)); p {
+	case ConflictKeep, ConflictOverwrite, ConflictError:
+		return p, nil
+	}
+	return "", fmt.Errorf("unknown conflict policy %q (expected keep, overwrite or error)", s)
+}
+
+// propagatedTags returns the instance tags listed in PropagateKeys, in option order
+func (e *Engine) propagatedTags(instance types.Instance) []types.Tag {
+	if len(e.opts.PropagateKeys) == 0 {
+		return nil
+	}
+
+	values := make(map[string]string)
+	for _, tag := range instance.Tags {
+		values[aws.ToString(tag.Key)] = aws.ToString(tag.Value)
+	}
+
+	tags := []types.Tag{}
+	for _, key := range e.opts.PropagateKeys {
+		if value, ok := values[key]; ok {
+			tags = append(tags, types.Tag{Key: aws.String(key), Value: aws.String(value)})
+		}
+	}
+	return tags
+}
+
+// addPropagatedTags appends the propagated tags a resource is missing to
+// tagsToAdd. Keys the resource already holds with another value follow the
+// conflict policy: kept with a warning, overwritten, or reported as an error.
+func (e *Engine) addPropagatedTags(rr *RegionReport, res *ResourceResult, propagated, tagsToAdd []types.Tag) ([]types.Tag, error) {
+	for _, tag := range propagated {
+		key, value := aws.ToString(tag.Key), aws.ToString(tag.Value)
+		current, exists := res.ExistingTags[key]
+		switch {
+		case !exists:
+			tagsToAdd = append(tagsToAdd, tag)
+		case current == value:
+		case e.opts.ConflictPolicy == ConflictOverwrite:
+			tagsToAdd = append(tagsToAdd, tag)
+		case e.opts.ConflictPolicy == ConflictError:
+			return nil, fmt.Errorf("%s is %q but the instance has %q", key, current, value)
+		default:
+			rr.warn(fmt.Sprintf("%s %s: keeping %s=%q, instance has %q", res.Type, res.ShortID(), key, current, value))
+		}
+	}
+	return tagsToAdd, nil
+}
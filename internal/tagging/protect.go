@@ -0,0 +1,63 @@
+package tagging
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// ProtectTag marks a resource that automation must never change, when set
+// to "true"
+const ProtectTag = "coaws:skip"
+
+// LoadExclusions reads an exclusion file: one resource ID or ARN per line,
+// with blank lines and lines starting with '#' ignored
+func LoadExclusions(path string) (map[string]bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read exclusion file: %w", err)
+	}
+	defer f.Close()
+
+	excluded := make(map[string]bool)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		excluded[line] = true
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("cannot read exclusion file: %w", err)
+	}
+	return excluded, nil
+}
+
+// protection returns why a resource must not be changed, or "" when it can
+// be. A resource is protected by the protection tag, or by being listed in
+// the exclusion file by ID, ARN or the last element of its ARN.
+func (e *Engine) protection(res *ResourceResult) string {
+	if strings.EqualFold(res.ExistingTags[ProtectTag], "true") {
+		return ProtectTag + "=true"
+	}
+	if e.excluded[res.ID] || e.excluded[res.ShortID()] {
+		return "exclusion file"
+	}
+	return ""
+}
+
+// skipProtected marks a protected resource as skipped and reports whether
+// it is. Every path that plans a change to a resource asks first.
+func (e *Engine) skipProtected(res *ResourceResult) bool {
+	if res.Skipped != "" {
+		return true
+	}
+	reason := e.protection(res)
+	if reason == "" {
+		return false
+	}
+	res.Skipped = "protected: " + reason
+	return true
+}
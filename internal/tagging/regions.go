@@ -0,0 +1,195 @@
+package tagging
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+)
+
+// Region discovery modes of --regions
+const (
+	// RegionsAuto discovers the regions enabled in the account
+	RegionsAuto = "auto"
+	// RegionsAll discovers every region, including those not opted in
+	RegionsAll = "all"
+)
+
+// regionCacheTTL is how long discovered regions are reused
+const regionCacheTTL = 6 * time.Hour
+
+var regionPattern = regexp.MustCompile(`^[a-z]{2}(-[a-z]+)+-\d+$`)
+
+// DefaultRegionCacheFile returns where discovered regions are cached, in
+// the user's cache directory, or "" when there is none
+func DefaultRegionCacheFile() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "coaws", "regions.json")
+}
+
+// discoveredRegion is a region listed by DescribeRegions
+type discoveredRegion struct {
+	Name  string `json:"name"`
+	OptIn string `json:"opt_in"`
+}
+
+// regionCacheEntry is the result of one discovery, kept per account
+type regionCacheEntry struct {
+	Time    time.Time          `json:"time"`
+	Regions []discoveredRegion `json:"regions"`
+}
+
+// IdentityClientFactory is a ClientFactory that can tell the account its
+// credentials belong to
+type IdentityClientFactory interface {
+	ClientFactory
+	CallerAccount(ctx context.Context) (string, error)
+}
+
+// CallerAccount returns the account of the factory's credentials, with
+// GetCallerIdentity
+func (f *awsClientFactory) CallerAccount(ctx context.Context) (string, error) {
+	result, err := sts.NewFromConfig(f.cfg).GetCallerIdentity(ctx, &sts.GetCallerIdentityInput{})
+	if err != nil {
+		return "", err
+	}
+	return aws.ToString(result.Account), nil
+}
+
+// resolveRegions determines which regions to operate on: the listed ones,
+// the single Region, discovered ones with RegionDiscovery, or TargetRegions,
+// narrowed down by IncludeRegions and ExcludeRegions
+func (e *Engine) resolveRegions(ctx context.Context) []string {
+	var regions []string
+	switch {
+	case len(e.opts.Regions) > 0:
+		regions = e.opts.Regions
+	case e.opts.Region != "":
+		regions = []string{e.opts.Region}
+	case e.opts.RegionDiscovery != "":
+		regions = e.discoverRegions(ctx)
+	default:
+		regions = TargetRegions
+	}
+	return filterRegions(regions, e.opts.IncludeRegions, e.opts.ExcludeRegions)
+}
+
+// discoverRegions lists the regions of the account with DescribeRegions.
+// In auto mode, regions that are not opted in are left out. When discovery
+// fails, TargetRegions are used instead.
+func (e *Engine) discoverRegions(ctx context.Context) []string {
+	discovered, err := e.describeRegions(ctx)
+	if err != nil {
+		e.report.Warnings = append(e.report.Warnings, fmt.Sprintf("Failed to describe regions, using the default list: %v", err))
+		return TargetRegions
+	}
+
+	regions := []string{}
+	for _, r := range discovered {
+		if e.opts.RegionDiscovery == RegionsAuto && r.OptIn == "not-opted-in" {
+			continue
+		}
+		regions = append(regions, r.Name)
+	}
+	sort.Strings(regions)
+	e.discovered = true
+	return regions
+}
+
+// describeRegions returns every region with its opt-in status, from the
+// cache when a recent discovery was made in the same account. Discoveries
+// are not cached when the account cannot be told.
+func (e *Engine) describeRegions(ctx context.Context) ([]discoveredRegion, error) {
+	key := e.regionCacheKey(ctx)
+	var cache map[string]regionCacheEntry
+	if key != "" {
+		cache = readRegionCache(e.opts.RegionCacheFile)
+		if entry, ok := cache[key]; ok && !e.opts.RefreshRegions && time.Since(entry.Time) < regionCacheTTL {
+			return entry.Regions, nil
+		}
+	}
+
+	result, err := e.clients.EC2("").DescribeRegions(ctx, &ec2.DescribeRegionsInput{AllRegions: aws.Bool(true)})
+	if err != nil {
+		return nil, err
+	}
+	regions := make([]discoveredRegion, len(result.Regions))
+	for i, r := range result.Regions {
+		regions[i] = discoveredRegion{Name: aws.ToString(r.RegionName), OptIn: aws.ToString(r.OptInStatus)}
+	}
+
+	if key != "" {
+		cache[key] = regionCacheEntry{Time: time.Now().UTC(), Regions: regions}
+		writeRegionCache(e.opts.RegionCacheFile, cache)
+	}
+	return regions, nil
+}
+
+// regionCacheKey identifies the account regions are discovered in, and the
+// endpoint they are discovered at, or is "" when discoveries cannot be
+// cached. Profiles and roles are no key: the default credentials, or those
+// of a profile, can change account from one run to the next.
+func (e *Engine) regionCacheKey(ctx context.Context) string {
+	identity, ok := e.clients.(IdentityClientFactory)
+	if !ok || e.opts.RegionCacheFile == "" {
+		return ""
+	}
+	account, err := identity.CallerAccount(ctx)
+	if err != nil || account == "" {
+		return ""
+	}
+	return account + "|" + e.opts.EndpointURL
+}
+
+// readRegionCache loads the region cache. A missing or unreadable cache is
+// empty: it only saves a DescribeRegions call.
+func readRegionCache(path string) map[string]regionCacheEntry {
+	cache := make(map[string]regionCacheEntry)
+	if path == "" {
+		return cache
+	}
+	if data, err := os.ReadFile(path); err == nil {
+		if json.Unmarshal(data, &cache) != nil {
+			cache = make(map[string]regionCacheEntry)
+		}
+	}
+	return cache
+}
+
+// writeRegionCache saves the region cache, ignoring failures for the same reason
+func writeRegionCache(path string, cache map[string]regionCacheEntry) {
+	data, err := json.MarshalIndent(cache, "", "  ")
+	if err != nil {
+		return
+	}
+	if os.MkdirAll(filepath.Dir(path), 0o755) == nil {
+		_ = os.WriteFile(path, append(data, '\n'), 0o644)
+	}
+}
+
+// filterRegions keeps the regions matching any include pattern, when there
+// are some, and none of the exclude patterns. Patterns use the '*' and '?'
+// wildcards.
+func filterRegions(regions, include, exclude []string) []string {
+	if len(include) == 0 && len(exclude) == 0 {
+		return regions
+	}
+	filtered := []string{}
+	for _, region := range regions {
+		if (len(include) == 0 || matchesAnyGlob(region, include)) && !matchesAnyGlob(region, exclude) {
+			filtered = append(filtered, region)
+		}
+	}
+	return filtered
+}
@@ -0,0 +1,473 @@
+package tagging
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// OutputFormat selects how a report is rendered
+type OutputFormat string
+
+const (
+	OutputText   OutputFormat = "text"
+	OutputJSON   OutputFormat = "json"
+	OutputNDJSON OutputFormat = "ndjson"
+	OutputCSV    OutputFormat = "csv"
+)
+
+// ParseOutputFormat validates an --output value
+func ParseOutputFormat(s string) (OutputFormat, error) {
+	switch f := OutputFormat(strings.ToLower(s)); f {
+	case OutputText, OutputJSON, OutputNDJSON, OutputCSV:
+		return f, nil
+	}
+	return "", fmt.Errorf("unknown output format %q (expected text, json, ndjson or csv)", s)
+}
+
+// Render writes the report to w in the given format. An empty format renders text.
+func Render(w io.Writer, r *Report, format OutputFormat) error {
+	switch format {
+	case OutputText, "":
+		return RenderText(w, r)
+	case OutputJSON:
+		return RenderJSON(w, r)
+	case OutputNDJSON:
+		return RenderNDJSON(w, r)
+	case OutputCSV:
+		return RenderCSV(w, r)
+	}
+	return fmt.Errorf("unknown output format %q", format)
+}
+
+// RenderJSON writes the whole report, with per-region summaries, as a
+// single indented JSON document
+func RenderJSON(w io.Writer, r *Report) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(struct {
+		*Report
+		Summaries []RegionSummary `json:"summaries"`
+	}{r, r.Summaries()})
+}
+
+// RenderNDJSON writes one JSON object per resource that had tags to add,
+// or per non-compliant resource for an audit
+func RenderNDJSON(w io.Writer, r *Report) error {
+	resources := r.Changes()
+	if r.Mode == ModeAudit {
+		resources = r.NonCompliant()
+	}
+
+	enc := json.NewEncoder(w)
+	for _, res := range resources {
+		if err := enc.Encode(res); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// csvHeader is the column layout written by RenderCSV
+var csvHeader = []string{"region", "type", "id", "name", "action", "status", "key", "value", "error", "account"}
+
+// RenderCSV writes one row per planned tag change, with its action
+// (add or remove) and status (planned, applied or failed). For an audit
+// it writes one "audit" row per issue, with the problem as status.
+func RenderCSV(w io.Writer, r *Report) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(csvHeader); err != nil {
+		return err
+	}
+
+	for _, res := range r.NonCompliant() {
+		for _, issue := range res.Issues {
+			row := []string{res.Region, res.Type, res.ID, res.Name, "audit", issue.Problem, issue.Key, res.ExistingTags[issue.Key], issue.Detail, res.Account}
+			if err := cw.Write(row); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, res := range r.Changes() {
+		status := "planned"
+		switch {
+		case res.Error != "":
+			status = "failed"
+		case len(res.AppliedTags) > 0 || len(res.RemovedTags) > 0:
+			status = "applied"
+		}
+		for _, key := range res.PlannedRemovals {
+			row := []string{res.Region, res.Type, res.ID, res.Name, "remove", status, key, "", res.Error, res.Account}
+			if err := cw.Write(row); err != nil {
+				return err
+			}
+		}
+		for _, tag := range res.PlannedTags {
+			row := []string{res.Region, res.Type, res.ID, res.Name, "add", status, tag.Key, tag.Value, res.Error, res.Account}
+			if err := cw.Write(row); err != nil {
+				return err
+			}
+		}
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
+
+// RenderText writes the human-readable form of a report to w
+func RenderText(w io.Writer, r *Report) error {
+	bw := bufio.NewWriter(w)
+
+	switch {
+	case r.CostAllocation != nil:
+		renderActivate(bw, r)
+	case r.Mode == ModeShow:
+		renderShow(bw, r)
+	case r.Compliance != nil:
+		renderAudit(bw, r)
+	default:
+		renderTagging(bw, r)
+	}
+
+	return bw.Flush()
+}
+
+// regionNames returns the region names of the report, in processing order,
+// once each when several accounts were processed
+func (r *Report) regionNames() []string {
+	names := []string{}
+	for _, rr := range r.Regions {
+		if !contains(names, rr.Region) {
+			names = append(names, rr.Region)
+		}
+	}
+	return names
+}
+
+// renderTargets writes the accounts and regions a report covers
+func renderTargets(w io.Writer, r *Report) {
+	if len(r.Accounts) > 0 {
+		fmt.Fprintf(w, "Accounts: %s\n", strings.Join(r.Accounts, ", "))
+	}
+	fmt.Fprintf(w, "Target regions: %s\n", strings.Join(r.regionNames(), ", "))
+}
+
+// heading returns the region heading, preceded by its member account
+func (r *RegionReport) heading() string {
+	if r.Account == "" {
+		return "REGION: " + strings.ToUpper(r.Region)
+	}
+	return fmt.Sprintf("ACCOUNT: %s | REGION: %s", r.Account, strings.ToUpper(r.Region))
+}
+
+// location returns where a resource is, as its region or account/region
+func location(account, region string) string {
+	if account == "" {
+		return region
+	}
+	return account + "/" + region
+}
+
+func modeLabel(apply bool) string {
+	if apply {
+		return "APPLY"
+	}
+	return "DRY-RUN"
+}
+
+func renderTagging(w io.Writer, r *Report) {
+	if r.Apply {
+		fmt.Fprintf(w, "\nAPPLY MODE – REAL CHANGES!\n")
+	} else {
+		fmt.Fprintf(w, "\nDRY-RUN MODE\n")
+	}
+	fmt.Fprintf(w, "Action: %s\n", r.Mode)
+	renderTargets(w, r)
+	for _, warning := range r.Warnings {
+		fmt.Fprintf(w, "[WARN] %s\n", warning)
+	}
+
+	for _, rr := range r.Regions {
+		fmt.Fprintf(w, "\n%s\n", strings.Repeat("=", 80))
+		fmt.Fprintf(w, "%s | Mode: %s\n", rr.heading(), modeLabel(r.Apply))
+		fmt.Fprintf(w, "%s\n", strings.Repeat("=", 80))
+
+		if r.FixOrphans {
+			fmt.Fprintf(w, "\n[ORPHAN MODE] Fixing orphaned AMI snapshots that have no Name tag...\n")
+		}
+		for _, warning := range rr.Warnings {
+			fmt.Fprintf(w, "[WARN] %s\n", warning)
+		}
+		section := ""
+		for _, res := range rr.Resources {
+			// Storage resources get their own heading, as they are processed after EC2
+			if svc := strings.Fields(res.Type)[0]; (svc == "EFS" || svc == "FSx") && svc != section {
+				fmt.Fprintf(w, "\n[%s] Processing %s resources in %s (%s)\n", svc, svc, strings.ToUpper(rr.Region), modeLabel(r.Apply))
+				section = svc
+			}
+			renderResource(w, res, r.Apply)
+		}
+
+		s := rr.Summary()
+		processed := make([]string, len(s.Processed))
+		for i, tc := range s.Processed {
+			processed[i] = fmt.Sprintf("%s: %d", tc.Type, tc.Count)
+		}
+		tagged := fmt.Sprintf("%d to tag", s.Planned)
+		switch {
+		case r.Mode == ModeCleanup && r.Apply:
+			tagged = fmt.Sprintf("%d cleaned up", s.Applied)
+		case r.Mode == ModeCleanup:
+			tagged = fmt.Sprintf("%d to clean up", s.Planned)
+		case r.Apply:
+			tagged = fmt.Sprintf("%d tagged", s.Applied)
+		}
+		fmt.Fprintf(w, "\n[SUMMARY] %s → %d resources processed", location(rr.Account, rr.Region), s.Resources)
+		if len(processed) > 0 {
+			fmt.Fprintf(w, " (%s)", strings.Join(processed, ", "))
+		}
+		if s.Skipped > 0 {
+			tagged += fmt.Sprintf(", %d skipped (protected)", s.Skipped)
+		}
+		fmt.Fprintf(w, ", %s, %d errors\n", tagged, s.Errors)
+	}
+
+	fmt.Fprintf(w, "\n%s\n", strings.Repeat("═", 80))
+	switch {
+	case r.Mode == ModeUndo:
+		fmt.Fprintln(w, "UNDO COMPLETED!")
+	case r.Mode == ModeCleanup:
+		fmt.Fprintln(w, "CLEANUP COMPLETED!")
+	case r.TagStorage:
+		fmt.Fprintln(w, "TAG PROPAGATION COMPLETED!")
+		fmt.Fprintln(w, "EC2 + EFS + FSx resources were processed.")
+	default:
+		fmt.Fprintln(w, "TAG PROPAGATION COMPLETED!")
+		fmt.Fprintln(w, "EC2 resources were processed. Use --tag-storage to include EFS/FSx.")
+	}
+	if r.Journal != "" {
+		fmt.Fprintf(w, "Journal: %s (revert with: tagging undo %s --apply)\n", r.Journal, r.Journal)
+	}
+	if stale := r.Stale(); len(stale) > 0 {
+		fmt.Fprintf(w, "Stale machine keys: %d resources (migrate with --migrate-stale-keys)\n", len(stale))
+	}
+	if stale := r.PossiblyStale(); len(stale) > 0 {
+		fmt.Fprintf(w, "Possibly stale machine keys: %d resources (kept; pass the journals that wrote them with --from-journal)\n", len(stale))
+	}
+	if failed := r.Failed(); len(failed) > 0 {
+		fmt.Fprintf(w, "Failed after retries: %d resources\n", len(failed))
+		for _, res := range failed {
+			fmt.Fprintf(w, "  - %s %s (%s): %s\n", res.Type, res.ShortID(), location(res.Account, res.Region), res.Error)
+		}
+		if r.FailedPlan != "" {
+			fmt.Fprintf(w, "Re-run plan: %s (retry with: tagging apply-plan %s)\n", r.FailedPlan, r.FailedPlan)
+		}
+	}
+	fmt.Fprintf(w, "%s\n", strings.Repeat("═", 80))
+}
+
+func renderResource(w io.Writer, res *ResourceResult, apply bool) {
+	if res.Type == "EC2 Instance" {
+		display := res.Name
+		if res.Name != res.ID {
+			display = fmt.Sprintf("%s (%s)", res.Name, res.ID)
+		}
+		fmt.Fprintf(w, "\n[PROCESSING] %s → Using tag key: '%s'\n", display, res.MachineKey)
+	}
+
+	action := "PLAN"
+	if apply && res.Error == "" {
+		action = "APPLY"
+	}
+	for _, key := range res.PlannedRemovals {
+		fmt.Fprintf(w, "    [%s] %s %s → remove %s\n", action, res.Type, res.ShortID(), key)
+	}
+	for _, tag := range res.PlannedTags {
+		value := tag.Value
+		if value == "" {
+			value = "(empty)"
+		}
+		fmt.Fprintf(w, "    [%s] %s %s → %s = %s\n", action, res.Type, res.ShortID(), tag.Key, value)
+	}
+	if len(res.PlannedRemovals) == 0 && res.Skipped == "" {
+		for _, key := range res.StaleKeys {
+			fmt.Fprintf(w, "    [STALE] %s %s → %s is the machine key of an earlier Name\n", res.Type, res.ShortID(), key)
+		}
+	}
+	if res.Skipped == "" {
+		for _, key := range res.PossiblyStaleKeys {
+			fmt.Fprintf(w, "    [STALE?] %s %s → %s may be the machine key of an earlier Name, no journal shows it was written by the tool\n", res.Type, res.ShortID(), key)
+		}
+	}
+	if res.Skipped != "" {
+		fmt.Fprintf(w, "    [SKIP] %s %s → skipped (%s)\n", res.Type, res.ShortID(), res.Skipped)
+	}
+	if res.Error != "" {
+		fmt.Fprintf(w, "    [ERROR] %s %s: %s\n", res.Type, res.ShortID(), res.Error)
+	}
+}
+
+func renderAudit(w io.Writer, r *Report) {
+	fmt.Fprintf(w, "\nTAG COMPLIANCE AUDIT\n")
+	renderTargets(w, r)
+	for _, warning := range r.Warnings {
+		fmt.Fprintf(w, "[WARN] %s\n", warning)
+	}
+
+	for i, rr := range r.Regions {
+		fmt.Fprintf(w, "\n%s\n", strings.Repeat("=", 80))
+		fmt.Fprintf(w, "%s | Mode: AUDIT\n", rr.heading())
+		fmt.Fprintf(w, "%s\n", strings.Repeat("=", 80))
+		for _, warning := range rr.Warnings {
+			fmt.Fprintf(w, "[WARN] %s\n", warning)
+		}
+
+		for _, tc := range r.Compliance.Regions[i].Types {
+			unreadable := ""
+			if tc.Unreadable > 0 {
+				unreadable = fmt.Sprintf(", %d unreadable", tc.Unreadable)
+			}
+			fmt.Fprintf(w, "  %-16s %d/%d compliant (%d missing, %d empty, %d invalid%s)\n",
+				tc.Type, tc.Compliant, tc.Resources, tc.Missing, tc.Empty, tc.Invalid, unreadable)
+		}
+		for _, res := range rr.Resources {
+			if res.Error != "" {
+				fmt.Fprintf(w, "    [ERROR] %s %s: %s\n", res.Type, res.ShortID(), res.Error)
+			}
+			for _, issue := range res.Issues {
+				detail := issue.Key
+				if issue.Detail != "" {
+					detail = fmt.Sprintf("%s=%s", issue.Key, issue.Detail)
+				}
+				fmt.Fprintf(w, "    [%s] %s %s → %s\n", strings.ToUpper(issue.Problem), res.Type, res.ShortID(), detail)
+			}
+		}
+	}
+
+	c := r.Compliance
+	result := "PASSED"
+	if !c.Passed() {
+		result = "FAILED"
+	}
+	fmt.Fprintf(w, "\n%s\n", strings.Repeat("═", 80))
+	fmt.Fprintf(w, "COMPLIANCE: %.1f%% (%d/%d resources), threshold %.1f%% → %s\n", c.Percent, c.Compliant, c.Resources, c.Threshold, result)
+	if c.Incomplete > 0 {
+		fmt.Fprintf(w, "INCOMPLETE: %d listing failures, some resources were not audited\n", c.Incomplete)
+	}
+	fmt.Fprintf(w, "%s\n", strings.Repeat("═", 80))
+}
+
+func renderShow(w io.Writer, r *Report) {
+	for _, warning := range r.Warnings {
+		fmt.Fprintf(w, "[WARN] %s\n", warning)
+	}
+	for _, rr := range r.Regions {
+		fmt.Fprintf(w, "\n%s\n", strings.Repeat("=", 80))
+		fmt.Fprintf(w, "[SHOW] %s\n", rr.heading())
+		fmt.Fprintf(w, "%s\n", strings.Repeat("=", 80))
+
+		for _, inv := range rr.Inventory {
+			if inv.Error != "" {
+				fmt.Fprintf(w, "[%s] Not accessible or no %s in this region\n", inv.Service, inv.Service)
+				continue
+			}
+			fmt.Fprintf(w, "[%s] %s: %d\n", inv.Service, inv.Resource, inv.Count)
+		}
+	}
+}
+
+func renderActivate(w io.Writer, r *Report) {
+	ca := r.CostAllocation
+
+	fmt.Fprintf(w, "\n[COST ALLOCATION TAGS] Activating eligible tag keys\n")
+	fmt.Fprintf(w, "Regions: %s\n", strings.Join(r.regionNames(), ", "))
+	fmt.Fprintf(w, "Mode: %s\n\n", modeLabel(r.Apply))
+	for _, warning := range r.Warnings {
+		fmt.Fprintf(w, "[WARN] %s\n", warning)
+	}
+
+	// Listing the active keys failed before any region was scanned
+	if ca.Error != "" && len(r.Regions) == 0 {
+		fmt.Fprintf(w, "[ERROR] %s\n", ca.Error)
+		return
+	}
+
+	fmt.Fprintf(w, "Currently active Cost Allocation Tags: %d\n", ca.ActiveKeys)
+	for _, rr := range r.Regions {
+		if rr.Account != "" {
+			fmt.Fprintf(w, "  Scanning account %s, region %s...\n", rr.Account, strings.ToUpper(rr.Region))
+		} else {
+			fmt.Fprintf(w, "  Scanning region %s...\n", strings.ToUpper(rr.Region))
+		}
+		for _, warning := range rr.Warnings {
+			fmt.Fprintf(w, "    [WARN] %s\n", warning)
+		}
+	}
+	if ca.Error != "" {
+		fmt.Fprintf(w, "\n[ERROR] %s\n", ca.Error)
+		return
+	}
+
+	fmt.Fprintf(w, "\nFound %d unique tag keys → %d eligible for activation", ca.DiscoveredKeys, len(ca.Eligible))
+	if ca.Skipped > 0 {
+		fmt.Fprintf(w, ", %d skipped by key filters", ca.Skipped)
+	}
+	fmt.Fprintln(w)
+	if ca.Reconcile {
+		fmt.Fprintf(w, "Active tag keys not selected or no longer found on any resource → %d to deactivate\n", len(ca.Deactivate))
+	}
+	if len(ca.Eligible) == 0 && len(ca.Deactivate) == 0 {
+		if ca.Reconcile {
+			fmt.Fprintln(w, "Cost Allocation Tags are already reconciled.")
+		} else {
+			fmt.Fprintln(w, "No new Cost Allocation Tags to activate.")
+		}
+		return
+	}
+
+	status := "PLAN"
+	if r.Apply {
+		status = "APPLY"
+	}
+	failed := make(map[string]CostAllocationKeyError)
+	for _, keyErr := range ca.Failed {
+		failed[keyErr.Key] = keyErr
+	}
+	renderKeys := func(title string, keys []string) {
+		if len(keys) == 0 {
+			return
+		}
+		fmt.Fprintf(w, "\n%s\n", title)
+		for _, key := range keys {
+			if keyErr, ok := failed[key]; ok {
+				fmt.Fprintf(w, "    [FAILED] %s: %s\n", key, strings.TrimPrefix(keyErr.Code+": "+keyErr.Message, ": "))
+				continue
+			}
+			fmt.Fprintf(w, "    [%s] %s\n", status, key)
+		}
+	}
+	renderKeys("Tag keys to activate:", ca.Eligible)
+	renderKeys("Tag keys to deactivate:", ca.Deactivate)
+
+	if !r.Apply {
+		action := "activate"
+		if ca.Reconcile {
+			action = "reconcile"
+		}
+		fmt.Fprintf(w, "\nDRY-RUN: No changes made. Use --apply to %s.\n", action)
+		return
+	}
+	if len(ca.Failed) > 0 {
+		fmt.Fprintf(w, "\nFAILED: %d Cost Allocation Tags could not be updated\n", len(ca.Failed))
+	}
+	fmt.Fprintf(w, "\nSUCCESS: %d Cost Allocation Tags activated", len(ca.Activated))
+	if ca.Reconcile {
+		fmt.Fprintf(w, ", %d deactivated", len(ca.Deactivated))
+	}
+	fmt.Fprintln(w, "!")
+	fmt.Fprintln(w, "Cost Explorer will reflect these tags within 24-48 hours.")
+}
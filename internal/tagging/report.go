@@ -0,0 +1,267 @@
+package tagging
+
+import "strings"
+
+// Tag is a single tag key/value pair
+type Tag struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+// ResourceResult is the outcome of processing a single resource
+type ResourceResult struct {
+	// Account is the member account of the resource, empty for the account
+	// the tool runs with
+	Account      string            `json:"account,omitempty"`
+	Region       string            `json:"region"`
+	Type         string            `json:"type"`
+	ID           string            `json:"id"`
+	Name         string            `json:"name,omitempty"`
+	MachineKey   string            `json:"machine_key,omitempty"`
+	ExistingTags map[string]string `json:"existing_tags"`
+	PlannedTags  []Tag             `json:"planned_tags,omitempty"`
+	AppliedTags  []Tag             `json:"applied_tags,omitempty"`
+	// PlannedRemovals and RemovedTags are tag keys taken off by undo
+	PlannedRemovals []string `json:"planned_removals,omitempty"`
+	RemovedTags     []string `json:"removed_tags,omitempty"`
+	// StaleKeys are machine keys of an earlier instance Name still on the
+	// resource, removed with MigrateStaleKeys. PossiblyStaleKeys look like
+	// them, but no journal shows the tool wrote them, so they are kept.
+	StaleKeys         []string `json:"stale_keys,omitempty"`
+	PossiblyStaleKeys []string `json:"possibly_stale_keys,omitempty"`
+	// Issues are the tag problems found by audit mode
+	Issues []ComplianceIssue `json:"issues,omitempty"`
+	// Skipped is why a protected resource was left unchanged
+	Skipped string `json:"skipped,omitempty"`
+	Error   string `json:"error,omitempty"`
+
+	// region is the report the resource was recorded in
+	region *RegionReport
+}
+
+// HasChanges reports whether any tag was planned to be added or removed
+func (r *ResourceResult) HasChanges() bool {
+	return len(r.PlannedTags) > 0 || len(r.PlannedRemovals) > 0
+}
+
+// ShortID returns the last path element of an ARN, or the ID itself
+func (r *ResourceResult) ShortID() string {
+	if idx := strings.LastIndex(r.ID, "/"); idx >= 0 {
+		return r.ID[idx+1:]
+	}
+	return r.ID
+}
+
+// InventoryCount is a resource count reported by show mode
+type InventoryCount struct {
+	Service  string `json:"service"`
+	Resource string `json:"resource"`
+	Count    int    `json:"count"`
+	Error    string `json:"error,omitempty"`
+}
+
+// RegionReport collects the results of a single region
+type RegionReport struct {
+	Account   string            `json:"account,omitempty"`
+	Region    string            `json:"region"`
+	Resources []*ResourceResult `json:"resources"`
+	Inventory []InventoryCount  `json:"inventory,omitempty"`
+	Warnings  []string          `json:"warnings,omitempty"`
+}
+
+// TypeCount is the number of resources of one type processed in a region
+type TypeCount struct {
+	Type  string `json:"type"`
+	Count int    `json:"count"`
+}
+
+// RegionSummary aggregates the results of a single region
+type RegionSummary struct {
+	Account   string      `json:"account,omitempty"`
+	Region    string      `json:"region"`
+	Processed []TypeCount `json:"processed"`
+	Resources int         `json:"resources"`
+	Planned   int         `json:"planned"`
+	Applied   int         `json:"applied"`
+	Errors    int         `json:"errors"`
+	Skipped   int         `json:"skipped,omitempty"`
+	Warnings  int         `json:"warnings"`
+}
+
+// Summary aggregates the resources recorded for the region
+func (r *RegionReport) Summary() RegionSummary {
+	s := RegionSummary{Account: r.Account, Region: r.Region, Warnings: len(r.Warnings)}
+	index := make(map[string]int)
+	for _, res := range r.Resources {
+		i, ok := index[res.Type]
+		if !ok {
+			i = len(s.Processed)
+			index[res.Type] = i
+			s.Processed = append(s.Processed, TypeCount{Type: res.Type})
+		}
+		s.Processed[i].Count++
+		s.Resources++
+		if res.HasChanges() {
+			s.Planned++
+		}
+		if len(res.AppliedTags) > 0 || len(res.RemovedTags) > 0 {
+			s.Applied++
+		}
+		if res.Error != "" {
+			s.Errors++
+		}
+		if res.Skipped != "" {
+			s.Skipped++
+		}
+	}
+	return s
+}
+
+// add records a resource in the region and returns it for further updates
+func (r *RegionReport) add(resourceType, id string, currentTags map[string]string) *ResourceResult {
+	res := &ResourceResult{
+		Account:      r.Account,
+		Region:       r.Region,
+		Type:         resourceType,
+		ID:           id,
+		ExistingTags: currentTags,
+		region:       r,
+	}
+	r.Resources = append(r.Resources, res)
+	return res
+}
+
+// merge moves the resources and warnings recorded in buf to the end of r
+func (r *RegionReport) merge(buf *RegionReport) {
+	for _, res := range buf.Resources {
+		res.region = r
+	}
+	r.Resources = append(r.Resources, buf.Resources...)
+	r.Warnings = append(r.Warnings, buf.Warnings...)
+	r.Inventory = append(r.Inventory, buf.Inventory...)
+}
+
+// warn records a region-level problem that did not stop the run
+func (r *RegionReport) warn(msg string) {
+	r.Warnings = append(r.Warnings, msg)
+}
+
+// CostAllocationResult is the outcome of activate mode. With Reconcile,
+// Deactivate are the active user-defined keys outside the selected ones, or
+// that no resource holds anymore.
+type CostAllocationResult struct {
+	ActiveKeys     int      `json:"active_keys"`
+	DiscoveredKeys int      `json:"discovered_keys"`
+	Eligible       []string `json:"eligible"`
+	// Skipped counts the inactive keys left out by IncludeKeys, ExcludeKeys
+	// or for being reserved by AWS
+	Skipped     int                      `json:"skipped,omitempty"`
+	Activated   []string                 `json:"activated,omitempty"`
+	Reconcile   bool                     `json:"reconcile,omitempty"`
+	Deactivate  []string                 `json:"deactivate,omitempty"`
+	Deactivated []string                 `json:"deactivated,omitempty"`
+	Failed      []CostAllocationKeyError `json:"failed,omitempty"`
+	Error       string                   `json:"error,omitempty"`
+}
+
+// Report is the structured result of an engine run
+type Report struct {
+	Mode           Mode                  `json:"mode"`
+	Apply          bool                  `json:"apply"`
+	TagStorage     bool                  `json:"tag_storage"`
+	FixOrphans     bool                  `json:"fix_orphans"`
+	Accounts       []string              `json:"accounts,omitempty"`
+	Regions        []*RegionReport       `json:"regions"`
+	CostAllocation *CostAllocationResult `json:"cost_allocation,omitempty"`
+	Compliance     *ComplianceSummary    `json:"compliance,omitempty"`
+	Journal        string                `json:"journal,omitempty"`
+	FailedPlan     string                `json:"failed_plan,omitempty"`
+	Warnings       []string              `json:"warnings,omitempty"`
+}
+
+// region returns the report for name in account, creating it on first use
+func (r *Report) region(account, name string) *RegionReport {
+	for _, rr := range r.Regions {
+		if rr.Account == account && rr.Region == name {
+			return rr
+		}
+	}
+	rr := &RegionReport{Account: account, Region: name, Resources: []*ResourceResult{}}
+	r.Regions = append(r.Regions, rr)
+	return rr
+}
+
+// Resources returns every resource in the report, in processing order
+func (r *Report) Resources() []*ResourceResult {
+	var all []*ResourceResult
+	for _, rr := range r.Regions {
+		all = append(all, rr.Resources...)
+	}
+	return all
+}
+
+// Changes returns the resources that had tags to add or remove, in processing order
+func (r *Report) Changes() []*ResourceResult {
+	var changes []*ResourceResult
+	for _, res := range r.Resources() {
+		if res.HasChanges() {
+			changes = append(changes, res)
+		}
+	}
+	return changes
+}
+
+// Summaries returns the per-region summaries, in processing order
+func (r *Report) Summaries() []RegionSummary {
+	summaries := make([]RegionSummary, len(r.Regions))
+	for i, rr := range r.Regions {
+		summaries[i] = rr.Summary()
+	}
+	return summaries
+}
+
+// Failed returns the resources that failed, in processing order
+func (r *Report) Failed() []*ResourceResult {
+	var failed []*ResourceResult
+	for _, res := range r.Resources() {
+		if res.Error != "" {
+			failed = append(failed, res)
+		}
+	}
+	return failed
+}
+
+// Stale returns the resources holding stale machine keys that are not being
+// removed, in processing order
+func (r *Report) Stale() []*ResourceResult {
+	var stale []*ResourceResult
+	for _, res := range r.Resources() {
+		if len(res.StaleKeys) > 0 && len(res.PlannedRemovals) == 0 && res.Skipped == "" {
+			stale = append(stale, res)
+		}
+	}
+	return stale
+}
+
+// PossiblyStale returns the resources holding keys that look like stale
+// machine keys, but that no journal shows the tool wrote
+func (r *Report) PossiblyStale() []*ResourceResult {
+	var stale []*ResourceResult
+	for _, res := range r.Resources() {
+		if len(res.PossiblyStaleKeys) > 0 && res.Skipped == "" {
+			stale = append(stale, res)
+		}
+	}
+	return stale
+}
+
+// Errors returns the number of resources that failed
+func (r *Report) Errors() int {
+	n := 0
+	for _, res := range r.Resources() {
+		if res.Error != "" {
+			n++
+		}
+	}
+	return n
+}
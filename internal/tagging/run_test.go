@@ -0,0 +1,1687 @@
+package tagging_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	cetypes "github.com/aws/aws-sdk-go-v2/service/costexplorer/types"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	ec2types "github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	orgtypes "github.com/aws/aws-sdk-go-v2/service/organizations/types"
+	"github.com/aws/smithy-go"
+
+	"github.com/Th3Mayar/aws-cost-optimization-tools/internal/tagging"
+	"github.com/Th3Mayar/aws-cost-optimization-tools/internal/tagging/fakeaws"
+)
+
+var update = flag.Bool("update", false, "update golden files in testdata/")
+
+// newFixture seeds a small account spread over two regions
+func newFixture() *fakeaws.Backend {
+	b := fakeaws.New()
+	b.PageSize = 2
+
+	b.AddInstance("us-east-1", fakeaws.Instance{
+		ID:        "i-0aaa",
+		ImageID:   "ami-0base",
+		Tags:      map[string]string{"Name": "web server 01", "Env": "prod"},
+		VolumeIDs: []string{"vol-0aaa"},
+	})
+	b.AddInstance("us-east-1", fakeaws.Instance{
+		ID:        "i-0bbb",
+		State:     ec2types.InstanceStateNameStopped,
+		VolumeIDs: []string{"vol-0bbb"},
+	})
+	b.AddInstance("us-east-1", fakeaws.Instance{
+		ID:    "i-0ccc",
+		State: ec2types.InstanceStateNameTerminated,
+		Tags:  map[string]string{"Name": "gone"},
+	})
+	b.AddVolume("us-east-1", fakeaws.Volume{ID: "vol-0aaa", InstanceID: "i-0aaa"})
+	b.AddVolume("us-east-1", fakeaws.Volume{ID: "vol-0bbb", InstanceID: "i-0bbb", Tags: map[string]string{"Name": "scratch"}})
+	b.AddVolume("us-east-1", fakeaws.Volume{ID: "vol-0ddd", Tags: map[string]string{"Name": "detached data"}})
+	b.AddSnapshot("us-east-1", fakeaws.Snapshot{ID: "snap-0aaa", VolumeID: "vol-0aaa", Description: "nightly"})
+	b.AddSnapshot("us-east-1", fakeaws.Snapshot{ID: "snap-0ami", VolumeID: "vol-0aaa", Description: "Created by CreateImage(i-0aaa) for ami-0web"})
+	b.AddSnapshot("us-east-1", fakeaws.Snapshot{ID: "snap-0old", VolumeID: "vol-0gone", Description: "Created by CreateImage(i-0zzz) for ami-0old"})
+	b.AddEFSFileSystem("us-east-1", fakeaws.EFSFileSystem{ID: "fs-0efs", Name: "shared home"})
+	b.AddEFSAccessPoint("us-east-1", fakeaws.EFSAccessPoint{ID: "fsap-0aaa", FileSystemID: "fs-0efs"})
+	b.AddFSxFileSystem("us-east-1", fakeaws.FSxFileSystem{ID: "fs-0fsx", Tags: map[string]string{"Name": "win share"}})
+	b.AddFSxBackup("us-east-1", fakeaws.FSxBackup{ID: "backup-0aaa"})
+	b.AddFSxVolume("us-east-1", fakeaws.FSxVolume{ID: "fsvol-0aaa", FileSystemID: "fs-0fsx", Name: "vol1"})
+
+	b.AddInstance("eu-west-1", fakeaws.Instance{
+		ID:   "i-0eee",
+		Tags: map[string]string{"Name": "batch"},
+	})
+
+	b.SetCostAllocationTag("Name", cetypes.CostAllocationTagStatusActive)
+	b.SetCostAllocationTag("Env", cetypes.CostAllocationTagStatusInactive)
+	return b
+}
+
+// runText runs the engine and returns the report with its text rendering
+func runText(t *testing.T, opts tagging.Options, b *fakeaws.Backend) (*tagging.Report, string) {
+	t.Helper()
+
+	report, err := tagging.NewEngine(opts, b).Run(context.Background())
+	if err != nil {
+		t.Errorf("Run returned error: %v", err)
+	}
+	if report == nil {
+		t.Fatal("Run returned a nil report")
+	}
+
+	var buf bytes.Buffer
+	if err := tagging.RenderText(&buf, report); err != nil {
+		t.Fatalf("RenderText: %v", err)
+	}
+	return report, buf.String()
+}
+
+func assertGolden(t *testing.T, name, got string) {
+	t.Helper()
+
+	path := filepath.Join("testdata", name+".golden")
+	if *update {
+		if err := os.MkdirAll("testdata", 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(got), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("reading golden file (run with -update to create it): %v", err)
+	}
+	if !bytes.Equal(want, []byte(got)) {
+		t.Errorf("output mismatch for %s (run with -update to accept)\n--- got ---\n%s\n--- want ---\n%s", path, got, want)
+	}
+}
+
+func TestRun_GoldenOutput(t *testing.T) {
+	cases := []struct {
+		name string
+		opts func(*tagging.Options)
+	}{
+		{"all", func(o *tagging.Options) { o.Mode = tagging.ModeAll }},
+		{"all_tag_storage", func(o *tagging.Options) { o.Mode = tagging.ModeAll; o.TagStorage = true }},
+		{"fix_orphans", func(o *tagging.Options) { o.Mode = tagging.ModeAll; o.FixOrphans = true }},
+		{"ec2", func(o *tagging.Options) { o.Mode = tagging.ModeEC2 }},
+		{"ebs", func(o *tagging.Options) { o.Mode = tagging.ModeEBS }},
+		{"volumes", func(o *tagging.Options) { o.Mode = tagging.ModeVolumes }},
+		{"snapshots", func(o *tagging.Options) { o.Mode = tagging.ModeSnapshots }},
+		{"fsx", func(o *tagging.Options) { o.Mode = tagging.ModeFSx }},
+		{"efs", func(o *tagging.Options) { o.Mode = tagging.ModeEFS }},
+		{"activate", func(o *tagging.Options) { o.Mode = tagging.ModeActivate }},
+		{"show", func(o *tagging.Options) { o.Mode = tagging.ModeShow }},
+		{"audit", func(o *tagging.Options) { o.Mode = tagging.ModeAudit; o.MinCompliance = 0 }},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			opts := tagging.DefaultOptions()
+			opts.Regions = []string{"us-east-1", "eu-west-1"}
+			tc.opts(&opts)
+
+			b := newFixture()
+			_, out := runText(t, opts, b)
+
+			assertGolden(t, tc.name, out)
+			if n := b.CallCount("ec2:CreateTags") + b.CallCount("efs:TagResource") + b.CallCount("fsx:TagResource"); n != 0 {
+				t.Errorf("dry-run issued %d tagging calls", n)
+			}
+		})
+	}
+}
+
+func TestRender_MachineReadableFormats(t *testing.T) {
+	opts := tagging.DefaultOptions()
+	opts.Regions = []string{"us-east-1", "eu-west-1"}
+	opts.TagStorage = true
+
+	report, _ := runText(t, opts, newFixture())
+
+	for _, format := range []tagging.OutputFormat{tagging.OutputJSON, tagging.OutputNDJSON, tagging.OutputCSV} {
+		t.Run(string(format), func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := tagging.Render(&buf, report, format); err != nil {
+				t.Fatalf("Render: %v", err)
+			}
+			assertGolden(t, "all_tag_storage."+string(format), buf.String())
+		})
+	}
+}
+
+func TestRun_ApplyMutatesBackend(t *testing.T) {
+	opts := tagging.DefaultOptions()
+	opts.Regions = []string{"us-east-1", "eu-west-1"}
+	opts.Apply = true
+	opts.TagStorage = true
+
+	b := newFixture()
+	report, _ := runText(t, opts, b)
+	if report.Errors() != 0 {
+		t.Errorf("expected no errors, got %d", report.Errors())
+	}
+
+	cases := []struct {
+		region, resource string
+		want             map[string]string
+	}{
+		{"us-east-1", "i-0aaa", map[string]string{"Name": "web server 01", "Env": "prod", "web-server-01": ""}},
+		{"us-east-1", "i-0bbb", map[string]string{"Name": "i-0bbb", "i-0bbb": ""}},
+		{"us-east-1", "i-0ccc", map[string]string{"Name": "gone"}},
+		{"us-east-1", "vol-0aaa", map[string]string{"Name": "web server 01", "web-server-01": ""}},
+		{"us-east-1", "vol-0bbb", map[string]string{"Name": "scratch", "i-0bbb": ""}},
+		{"us-east-1", "snap-0ami", map[string]string{"Name": "web server 01", "web-server-01": ""}},
+		{"us-east-1", "fs-0efs", map[string]string{"Name": "shared home", "shared-home": ""}},
+		{"us-east-1", "fsap-0aaa", map[string]string{"Name": "shared home-ap", "shared-home-ap": ""}},
+		{"us-east-1", fakeaws.FSxARN("us-east-1", "file-system", "fs-0fsx"), map[string]string{"Name": "win share", "win-share": ""}},
+		{"eu-west-1", "i-0eee", map[string]string{"Name": "batch", "batch": ""}},
+	}
+
+	for _, tc := range cases {
+		got := b.Tags(tc.region, tc.resource)
+		if len(got) != len(tc.want) {
+			t.Errorf("%s: expected tags %v, got %v", tc.resource, tc.want, got)
+			continue
+		}
+		for k, v := range tc.want {
+			if gv, ok := got[k]; !ok || gv != v {
+				t.Errorf("%s: expected %s=%q, got %v", tc.resource, k, v, got)
+			}
+		}
+	}
+
+	// A second run must find nothing left to do
+	report, out := runText(t, opts, b)
+	for _, s := range report.Summaries() {
+		if s.Planned != 0 || s.Applied != 0 {
+			t.Errorf("expected second run to be a no-op, got:\n%s", out)
+			break
+		}
+	}
+}
+
+func TestRun_ActivateApply(t *testing.T) {
+	opts := tagging.DefaultOptions()
+	opts.Mode = tagging.ModeActivate
+	opts.Regions = []string{"ap-south-1"}
+	opts.Apply = true
+
+	b := newFixture()
+	b.AddInstance("ap-south-1", fakeaws.Instance{ID: "i-0fff", Tags: map[string]string{"Team": "finops"}})
+	report, _ := runText(t, opts, b)
+
+	if ca := report.CostAllocation; ca == nil || len(ca.Activated) != 1 || ca.Activated[0] != "Team" {
+		t.Errorf("expected report to list Team as activated, got %#v", ca)
+	}
+
+	if got := b.CostAllocationTagStatus("Team"); got != cetypes.CostAllocationTagStatusActive {
+		t.Errorf("expected Team to be activated, got %q", got)
+	}
+	if got := b.CallCount("ce:UpdateCostAllocationTagsStatus"); got != 1 {
+		t.Errorf("expected 1 UpdateCostAllocationTagsStatus call, got %d", got)
+	}
+}
+
+// savePlan runs a dry-run that writes its plan to a temporary file
+func savePlan(t *testing.T, b *fakeaws.Backend) string {
+	t.Helper()
+
+	opts := tagging.DefaultOptions()
+	opts.Regions = []string{"us-east-1", "eu-west-1"}
+	opts.TagStorage = true
+	opts.PlanOut = filepath.Join(t.TempDir(), "plan.json")
+	runText(t, opts, b)
+	return opts.PlanOut
+}
+
+func TestRun_ApplyPlanAppliesSavedChanges(t *testing.T) {
+	b := newFixture()
+	path := savePlan(t, b)
+
+	plan, err := tagging.ReadPlan(path)
+	if err != nil {
+		t.Fatalf("ReadPlan: %v", err)
+	}
+	if len(plan.Changes) != 12 {
+		t.Fatalf("expected 12 planned changes, got %d", len(plan.Changes))
+	}
+
+	opts := tagging.DefaultOptions()
+	opts.Mode = tagging.ModeApplyPlan
+	opts.PlanFile = path
+	report, _ := runText(t, opts, b)
+
+	if !report.Apply {
+		t.Errorf("expected apply-plan to report Apply=true")
+	}
+	if n := len(report.Changes()); n != 12 {
+		t.Errorf("expected 12 applied resources, got %d", n)
+	}
+	if got := b.Tags("us-east-1", "vol-0aaa"); got["Name"] != "web server 01" {
+		t.Errorf("expected vol-0aaa to be tagged from the plan, got %v", got)
+	}
+	if got := b.Tags("us-east-1", "fs-0efs"); got["Name"] != "shared home" {
+		t.Errorf("expected fs-0efs to be tagged from the plan, got %v", got)
+	}
+
+	// Nothing is left to do afterwards
+	dry := tagging.DefaultOptions()
+	dry.Regions = []string{"us-east-1", "eu-west-1"}
+	dry.TagStorage = true
+	if report, _ := runText(t, dry, b); len(report.Changes()) != 0 {
+		t.Errorf("expected no changes after apply-plan, got %d", len(report.Changes()))
+	}
+}
+
+func TestRun_ApplyPlanRefusesDrift(t *testing.T) {
+	b := newFixture()
+	path := savePlan(t, b)
+
+	// Someone tags a planned resource by hand after the plan was reviewed
+	_, err := b.EC2("us-east-1").CreateTags(context.Background(), &ec2.CreateTagsInput{
+		Resources: []string{"vol-0aaa"},
+		Tags:      []ec2types.Tag{{Key: aws.String("Name"), Value: aws.String("manual")}},
+	})
+	if err != nil {
+		t.Fatalf("CreateTags: %v", err)
+	}
+	before := b.CallCount("ec2:CreateTags")
+
+	opts := tagging.DefaultOptions()
+	opts.Mode = tagging.ModeApplyPlan
+	opts.PlanFile = path
+	report, err := tagging.NewEngine(opts, b).Run(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "vol-0aaa") {
+		t.Fatalf("expected a drift error naming vol-0aaa, got %v", err)
+	}
+
+	if n := b.CallCount("ec2:CreateTags") - before; n != 0 {
+		t.Errorf("expected no CreateTags calls after drift, got %d", n)
+	}
+	if n := b.CallCount("efs:TagResource") + b.CallCount("fsx:TagResource"); n != 0 {
+		t.Errorf("expected no TagResource calls after drift, got %d", n)
+	}
+	if report.Errors() != 1 {
+		t.Errorf("expected exactly one drifted resource in the report, got %d", report.Errors())
+	}
+}
+
+func TestRun_UndoRevertsJournaledApply(t *testing.T) {
+	b := newFixture()
+	journal := filepath.Join(t.TempDir(), "journal.ndjson")
+
+	opts := tagging.DefaultOptions()
+	opts.Regions = []string{"us-east-1", "eu-west-1"}
+	opts.TagStorage = true
+	opts.Apply = true
+	opts.Journal = journal
+	runText(t, opts, b)
+
+	entries, err := tagging.ReadJournal(journal)
+	if err != nil {
+		t.Fatalf("ReadJournal: %v", err)
+	}
+	if len(entries) != 20 {
+		t.Fatalf("expected 20 journaled tags, got %d", len(entries))
+	}
+
+	// A tag changed by hand after the apply must survive the undo
+	_, err = b.EC2("us-east-1").CreateTags(context.Background(), &ec2.CreateTagsInput{
+		Resources: []string{"vol-0aaa"},
+		Tags:      []ec2types.Tag{{Key: aws.String("Name"), Value: aws.String("manual")}},
+	})
+	if err != nil {
+		t.Fatalf("CreateTags: %v", err)
+	}
+
+	undo := tagging.DefaultOptions()
+	undo.Mode = tagging.ModeUndo
+	undo.UndoFile = journal
+	if report, _ := runText(t, undo, b); len(report.Changes()) != 12 {
+		t.Errorf("expected undo dry-run to plan 12 resources, got %d", len(report.Changes()))
+	}
+	if n := b.CallCount("ec2:DeleteTags") + b.CallCount("efs:UntagResource") + b.CallCount("fsx:UntagResource"); n != 0 {
+		t.Fatalf("undo dry-run issued %d delete calls", n)
+	}
+
+	undo.Apply = true
+	runText(t, undo, b)
+
+	cases := []struct {
+		region, resource string
+		want             map[string]string
+	}{
+		{"us-east-1", "i-0aaa", map[string]string{"Name": "web server 01", "Env": "prod"}},
+		{"us-east-1", "i-0bbb", map[string]string{}},
+		{"us-east-1", "vol-0aaa", map[string]string{"Name": "manual"}},
+		{"us-east-1", "vol-0bbb", map[string]string{"Name": "scratch"}},
+		{"us-east-1", "snap-0ami", map[string]string{}},
+		{"us-east-1", "fs-0efs", map[string]string{}},
+		{"us-east-1", fakeaws.FSxARN("us-east-1", "file-system", "fs-0fsx"), map[string]string{"Name": "win share"}},
+		{"eu-west-1", "i-0eee", map[string]string{"Name": "batch"}},
+	}
+	for _, tc := range cases {
+		got := b.Tags(tc.region, tc.resource)
+		if len(got) != len(tc.want) {
+			t.Errorf("%s: expected tags %v after undo, got %v", tc.resource, tc.want, got)
+			continue
+		}
+		for k, v := range tc.want {
+			if got[k] != v {
+				t.Errorf("%s: expected %s=%q after undo, got %v", tc.resource, k, v, got)
+			}
+		}
+	}
+}
+
+func TestRun_UndoRestoresOverwrittenValue(t *testing.T) {
+	b := newFixture()
+	_, err := b.EC2("us-east-1").CreateTags(context.Background(), &ec2.CreateTagsInput{
+		Resources: []string{"vol-0bbb"},
+		Tags:      []ec2types.Tag{{Key: aws.String("Name"), Value: aws.String("renamed")}},
+	})
+	if err != nil {
+		t.Fatalf("CreateTags: %v", err)
+	}
+
+	journal := filepath.Join(t.TempDir(), "journal.ndjson")
+	line := `{"region":"us-east-1","type":"Volume","id":"vol-0bbb","key":"Name","previous":"scratch","value":"renamed"}` + "\n"
+	if err := os.WriteFile(journal, []byte(line), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	opts := tagging.DefaultOptions()
+	opts.Mode = tagging.ModeUndo
+	opts.UndoFile = journal
+	opts.Apply = true
+	runText(t, opts, b)
+
+	if got := b.Tags("us-east-1", "vol-0bbb")["Name"]; got != "scratch" {
+		t.Errorf("expected Name to be restored to scratch, got %q", got)
+	}
+}
+
+func TestRun_PropagateKeysConflictPolicies(t *testing.T) {
+	seed := func() *fakeaws.Backend {
+		b := fakeaws.New()
+		b.AddInstance("us-west-2", fakeaws.Instance{
+			ID:        "i-0prop",
+			Tags:      map[string]string{"Name": "api", "CostCenter": "cc-1", "Owner": "alice"},
+			VolumeIDs: []string{"vol-0new", "vol-0old"},
+		})
+		b.AddVolume("us-west-2", fakeaws.Volume{ID: "vol-0new", InstanceID: "i-0prop"})
+		b.AddVolume("us-west-2", fakeaws.Volume{ID: "vol-0old", InstanceID: "i-0prop", Tags: map[string]string{"CostCenter": "cc-9"}})
+		b.AddSnapshot("us-west-2", fakeaws.Snapshot{ID: "snap-0new", VolumeID: "vol-0new"})
+		return b
+	}
+
+	cases := []struct {
+		policy      tagging.ConflictPolicy
+		wantOld     string
+		wantOldErr  bool
+		wantWarning bool
+	}{
+		{tagging.ConflictKeep, "cc-9", false, true},
+		{tagging.ConflictOverwrite, "cc-1", false, false},
+		{tagging.ConflictError, "cc-9", true, false},
+	}
+
+	for _, tc := range cases {
+		t.Run(string(tc.policy), func(t *testing.T) {
+			b := seed()
+			opts := tagging.DefaultOptions()
+			opts.Regions = []string{"us-west-2"}
+			opts.Apply = true
+			opts.PropagateKeys = []string{"CostCenter", "Owner", "Project"}
+			opts.ConflictPolicy = tc.policy
+			report, _ := runText(t, opts, b)
+
+			for _, id := range []string{"vol-0new", "snap-0new"} {
+				got := b.Tags("us-west-2", id)
+				if got["CostCenter"] != "cc-1" || got["Owner"] != "alice" {
+					t.Errorf("%s: expected propagated CostCenter and Owner, got %v", id, got)
+				}
+				if _, ok := got["Project"]; ok {
+					t.Errorf("%s: Project is not on the instance and must not be propagated", id)
+				}
+			}
+
+			old := b.Tags("us-west-2", "vol-0old")
+			if old["CostCenter"] != tc.wantOld {
+				t.Errorf("vol-0old: expected CostCenter=%q, got %v", tc.wantOld, old)
+			}
+			if gotErr := report.Errors() == 1; gotErr != tc.wantOldErr {
+				t.Errorf("expected conflict error=%v, got %d errors", tc.wantOldErr, report.Errors())
+			}
+			if gotWarn := len(report.Regions[0].Warnings) == 1; gotWarn != tc.wantWarning {
+				t.Errorf("expected conflict warning=%v, got %v", tc.wantWarning, report.Regions[0].Warnings)
+			}
+		})
+	}
+}
+
+func TestRun_PolicyPlansDefaults(t *testing.T) {
+	b := fakeaws.New()
+	b.AddInstance("ap-south-1", fakeaws.Instance{
+		ID:        "i-0pol",
+		Tags:      map[string]string{"Name": "api", "Environment": "qa"},
+		VolumeIDs: []string{"vol-0pol"},
+	})
+	b.AddVolume("ap-south-1", fakeaws.Volume{ID: "vol-0pol", InstanceID: "i-0pol", Tags: map[string]string{"CostCenter": "CC-12"}})
+	b.AddSnapshot("ap-south-1", fakeaws.Snapshot{ID: "snap-0pol", VolumeID: "vol-0pol"})
+
+	opts := tagging.DefaultOptions()
+	opts.Regions = []string{"ap-south-1"}
+	opts.Apply = true
+	opts.PolicyFile = filepath.Join("testdata", "policy.yaml")
+	report, _ := runText(t, opts, b)
+
+	want := map[string]map[string]string{
+		// Environment=qa violates the policy but existing values are kept
+		"i-0pol":    {"CostCenter": "CC-0000", "Environment": "qa"},
+		"vol-0pol":  {"CostCenter": "CC-12", "Environment": "dev"},
+		"snap-0pol": {"CostCenter": "CC-0000"},
+	}
+	for id, tags := range want {
+		got := b.Tags("ap-south-1", id)
+		for k, v := range tags {
+			if got[k] != v {
+				t.Errorf("%s: expected %s=%q, got %v", id, k, v, got)
+			}
+		}
+	}
+	if _, ok := b.Tags("ap-south-1", "snap-0pol")["Environment"]; ok {
+		t.Error("snap-0pol: Environment only applies to instances and volumes")
+	}
+
+	warnings := strings.Join(report.Regions[0].Warnings, "\n")
+	for _, want := range []string{
+		`EC2 Instance i-0pol: Environment="qa" is not one of prod, staging, dev`,
+		"EC2 Instance i-0pol: missing required tag Owner (no default in policy)",
+		`Volume vol-0pol: CostCenter="CC-12" does not match ^CC-[0-9]{4}$`,
+	} {
+		if !strings.Contains(warnings, want) {
+			t.Errorf("expected warning %q, got:\n%s", want, warnings)
+		}
+	}
+	if n := len(report.Regions[0].Warnings); n != 3 {
+		t.Errorf("expected 3 warnings, got %d:\n%s", n, warnings)
+	}
+}
+
+func TestRun_InvalidPolicyFails(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "policy.yaml")
+	if err := os.WriteFile(path, []byte("tags:\n  - key: Env\n    allowed: [prod]\n    default: dev\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	b := newFixture()
+	opts := tagging.DefaultOptions()
+	opts.Apply = true
+	opts.PolicyFile = path
+	if _, err := tagging.NewEngine(opts, b).Run(context.Background()); err == nil {
+		t.Fatal("expected an invalid policy to fail the run")
+	}
+	if got := b.Tags("us-east-1", "i-0bbb"); len(got) != 0 {
+		t.Errorf("no tags should be written when the policy is invalid, got %v", got)
+	}
+}
+
+func TestRun_AuditChecksPolicyAndThreshold(t *testing.T) {
+	b := fakeaws.New()
+	b.AddInstance("ca-central-1", fakeaws.Instance{
+		ID:        "i-0ok",
+		Tags:      map[string]string{"Name": "api", "api": "", "CostCenter": "CC-1234", "Environment": "prod", "Owner": "ops"},
+		VolumeIDs: []string{"vol-0bad"},
+	})
+	b.AddVolume("ca-central-1", fakeaws.Volume{ID: "vol-0bad", InstanceID: "i-0ok", Tags: map[string]string{"Name": "", "CostCenter": "CC-12", "Environment": "qa"}})
+	b.AddEFSFileSystem("ca-central-1", fakeaws.EFSFileSystem{ID: "fs-0aud", Name: "data"})
+
+	opts := tagging.DefaultOptions()
+	opts.Mode = tagging.ModeAudit
+	opts.Regions = []string{"ca-central-1"}
+	opts.PolicyFile = filepath.Join("testdata", "policy.yaml")
+
+	report, err := tagging.NewEngine(opts, b).Run(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "below the required 100.0%") {
+		t.Fatalf("expected the audit to fail the default threshold, got %v", err)
+	}
+	if n := b.CallCount("ec2:CreateTags") + b.CallCount("efs:TagResource"); n != 0 {
+		t.Errorf("audit issued %d tagging calls", n)
+	}
+	if len(report.Changes()) != 0 {
+		t.Errorf("audit must not plan changes, got %d", len(report.Changes()))
+	}
+
+	got := map[string][]string{}
+	for _, res := range report.NonCompliant() {
+		for _, issue := range res.Issues {
+			got[res.ShortID()] = append(got[res.ShortID()], issue.Problem+":"+issue.Key)
+		}
+	}
+	want := map[string]string{
+		// The volume has no machine key of its own, so only Name and the policy apply
+		"vol-0bad": "empty:Name,invalid:CostCenter,invalid:Environment",
+		// EFS keeps its name outside of the tags, which the tagger fixes
+		"fs-0aud": "missing:Name,missing:CostCenter",
+	}
+	if len(got) != len(want) {
+		t.Errorf("expected issues on %d resources, got %v", len(want), got)
+	}
+	for id, issues := range want {
+		if g := strings.Join(got[id], ","); g != issues {
+			t.Errorf("%s: expected issues %s, got %s", id, issues, g)
+		}
+	}
+
+	c := report.Compliance
+	if c.Resources != 3 || c.Compliant != 1 {
+		t.Errorf("expected 1/3 compliant resources, got %d/%d", c.Compliant, c.Resources)
+	}
+
+	opts.MinCompliance = 30
+	if _, err := tagging.NewEngine(opts, b).Run(context.Background()); err != nil {
+		t.Errorf("expected the audit to pass a 30%% threshold: %v", err)
+	}
+}
+
+func TestRun_AuditFailsWhenResourcesCannotBeRead(t *testing.T) {
+	b := fakeaws.New()
+	b.AddInstance("ca-central-1", fakeaws.Instance{ID: "i-0ok", Tags: map[string]string{"Name": "api", "api": ""}})
+	b.AddEFSFileSystem("ca-central-1", fakeaws.EFSFileSystem{ID: "fs-0aud", Name: "data", Tags: map[string]string{"Name": "data"}})
+	b.Fail("ec2:DescribeInstances", -1, errors.New("UnauthorizedOperation: access denied"))
+	b.Fail("efs:ListTagsForResource", -1, errors.New("AccessDeniedException: access denied"))
+
+	opts := tagging.DefaultOptions()
+	opts.Mode = tagging.ModeAudit
+	opts.Regions = []string{"ca-central-1"}
+	opts.MinCompliance = 0
+
+	report, err := tagging.NewEngine(opts, b).Run(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "cannot be verified") {
+		t.Fatalf("expected the audit to fail when resources cannot be read, got %v", err)
+	}
+
+	c := report.Compliance
+	if c.Passed() || c.Incomplete != 1 {
+		t.Errorf("expected a failed audit with 1 listing failure, got passed=%v incomplete=%d", c.Passed(), c.Incomplete)
+	}
+	// The file system was listed but its tags could not be read
+	if c.Resources != 1 || c.Compliant != 0 || c.Regions[0].Types[0].Unreadable != 1 {
+		t.Errorf("expected 1 unreadable, non-compliant resource, got %+v", c.Regions)
+	}
+}
+
+func TestRun_AuditPagesEFSAndFSx(t *testing.T) {
+	b := fakeaws.New()
+	b.PageSize = 2
+	for _, n := range []string{"1", "2", "3"} {
+		b.AddEFSFileSystem("ca-central-1", fakeaws.EFSFileSystem{ID: "fs-" + n, Tags: map[string]string{"Name": "data", "data": ""}})
+		b.AddFSxBackup("ca-central-1", fakeaws.FSxBackup{ID: "fsb-" + n, Tags: map[string]string{"Name": "nightly", "nightly": ""}})
+	}
+	// Name is on the second page of the tags of fs-4, and missing from fsb-4
+	b.AddEFSFileSystem("ca-central-1", fakeaws.EFSFileSystem{ID: "fs-4", Tags: map[string]string{"Env": "prod", "Environment": "prod", "Name": "logs"}})
+	b.AddFSxBackup("ca-central-1", fakeaws.FSxBackup{ID: "fsb-4", Tags: map[string]string{"Env": "prod", "Environment": "prod"}})
+
+	opts := tagging.DefaultOptions()
+	opts.Mode = tagging.ModeAudit
+	opts.Regions = []string{"ca-central-1"}
+	opts.TagEFS = true
+	opts.TagFSx = true
+	opts.MinCompliance = 0
+	report, text := runText(t, opts, b)
+	assertGolden(t, "audit_paged", text)
+
+	// Every page of the listings and of the tags is audited
+	c := report.Compliance
+	if c.Resources != 8 || c.Compliant != 7 {
+		t.Errorf("expected 7/8 compliant resources, got %d/%d", c.Compliant, c.Resources)
+	}
+	if got := report.NonCompliant(); len(got) != 1 || got[0].ShortID() != "fsb-4" {
+		t.Errorf("expected only fsb-4 to be non-compliant, got %v", got)
+	}
+}
+
+func TestRun_MachineKeyValueAndMigration(t *testing.T) {
+	seed := func() *fakeaws.Backend {
+		b := fakeaws.New()
+		// i-0mk was tagged by an earlier version, which left the machine key empty
+		b.AddInstance("eu-west-2", fakeaws.Instance{
+			ID:        "i-0mk",
+			Tags:      map[string]string{"Name": "db 01", "db-01": ""},
+			VolumeIDs: []string{"vol-0mk"},
+		})
+		b.AddVolume("eu-west-2", fakeaws.Volume{ID: "vol-0mk", InstanceID: "i-0mk", Tags: map[string]string{"db-01": "custom"}})
+		b.AddSnapshot("eu-west-2", fakeaws.Snapshot{ID: "snap-0mk", VolumeID: "vol-0mk"})
+		return b
+	}
+
+	opts := tagging.DefaultOptions()
+	opts.Regions = []string{"eu-west-2"}
+	opts.Apply = true
+	opts.MachineKeyValue = "{region}/{id}"
+
+	b := seed()
+	runText(t, opts, b)
+	if got := b.Tags("eu-west-2", "snap-0mk")["db-01"]; got != "eu-west-2/i-0mk" {
+		t.Errorf("snap-0mk: expected the instance-based machine key value, got %q", got)
+	}
+	if got := b.Tags("eu-west-2", "i-0mk")["db-01"]; got != "" {
+		t.Errorf("i-0mk: existing machine key must be kept without migration, got %q", got)
+	}
+
+	opts.MigrateMachineKey = true
+	b = seed()
+	runText(t, opts, b)
+	if got := b.Tags("eu-west-2", "i-0mk")["db-01"]; got != "eu-west-2/i-0mk" {
+		t.Errorf("i-0mk: expected the empty machine key to be migrated, got %q", got)
+	}
+	if got := b.Tags("eu-west-2", "vol-0mk")["db-01"]; got != "custom" {
+		t.Errorf("vol-0mk: non-empty machine key values must never be rewritten, got %q", got)
+	}
+}
+
+func TestRun_ConcurrencyMatchesSequential(t *testing.T) {
+	cases := []struct {
+		name string
+		opts func(*tagging.Options)
+	}{
+		{"all_tag_storage", func(o *tagging.Options) { o.TagStorage = true }},
+		{"ebs", func(o *tagging.Options) { o.Mode = tagging.ModeEBS }},
+		{"apply", func(o *tagging.Options) { o.TagStorage = true; o.Apply = true }},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			run := func(concurrency int) (string, map[string]string) {
+				opts := tagging.DefaultOptions()
+				opts.Regions = []string{"us-east-1", "eu-west-1", "ap-south-1"}
+				opts.Concurrency = concurrency
+				tc.opts(&opts)
+
+				b := newFixture()
+				report, out := runText(t, opts, b)
+				var js bytes.Buffer
+				if err := tagging.RenderJSON(&js, report); err != nil {
+					t.Fatal(err)
+				}
+				return out + js.String(), b.Tags("us-east-1", "fs-0efs")
+			}
+
+			seqOut, seqTags := run(1)
+			for i := 0; i < 5; i++ {
+				out, tags := run(8)
+				if out != seqOut {
+					t.Fatalf("concurrent output differs from sequential\n--- concurrent ---\n%s\n--- sequential ---\n%s", out, seqOut)
+				}
+				if len(tags) != len(seqTags) {
+					t.Errorf("concurrent run tagged fs-0efs with %v, sequential with %v", tags, seqTags)
+				}
+			}
+		})
+	}
+}
+
+func TestRun_BatchesCreateTagsBySharedTagSet(t *testing.T) {
+	seed := func() *fakeaws.Backend {
+		b := fakeaws.New()
+		for _, id := range []string{"vol-0b1", "vol-0b2", "vol-0b3"} {
+			b.AddVolume("sa-east-1", fakeaws.Volume{ID: id, Tags: map[string]string{"Name": "shared"}})
+		}
+		b.AddVolume("sa-east-1", fakeaws.Volume{ID: "vol-0b4", Tags: map[string]string{"Name": "other"}})
+		return b
+	}
+
+	opts := tagging.DefaultOptions()
+	opts.Mode = tagging.ModeVolumes
+	opts.Regions = []string{"sa-east-1"}
+	opts.Apply = true
+
+	b := seed()
+	report, _ := runText(t, opts, b)
+	if n := b.CallCount("ec2:CreateTags"); n != 2 {
+		t.Errorf("expected one CreateTags call per tag set, got %d", n)
+	}
+	for _, res := range report.Resources() {
+		if len(res.AppliedTags) != 1 || res.Error != "" {
+			t.Errorf("%s: expected the machine key to be applied, got %+v", res.ID, res)
+		}
+	}
+
+	// A batch failing because of one of its resources is retried resource by resource
+	b = seed()
+	b.Fail("ec2:CreateTags", 1, &smithy.GenericAPIError{Code: "InvalidVolume.NotFound", Message: "The volume 'vol-0gone' does not exist."})
+	report, _ = runText(t, opts, b)
+	if report.Errors() != 0 {
+		t.Errorf("expected the retries to succeed, got %d errors", report.Errors())
+	}
+	if got := b.Tags("sa-east-1", "vol-0b2"); got["shared"] != "" || len(got) != 2 {
+		t.Errorf("vol-0b2: expected the machine key after retry, got %v", got)
+	}
+
+	// Throttling fails the whole batch without one call per resource
+	b = seed()
+	b.Fail("ec2:CreateTags", 1, errors.New("RequestLimitExceeded: rate exceeded"))
+	report, _ = runText(t, opts, b)
+	if n := b.CallCount("ec2:CreateTags"); n != 2 {
+		t.Errorf("expected no per-resource retries after throttling, got %d CreateTags calls", n)
+	}
+	if report.Errors() != 3 {
+		t.Errorf("expected the 3 resources of the throttled batch to fail, got %d errors", report.Errors())
+	}
+}
+
+func TestRun_FailedResourcesAreReportedAndSavedForRerun(t *testing.T) {
+	b := newFixture()
+	b.Fail("ec2:CreateTags", -1, errors.New("RequestLimitExceeded: rate exceeded"))
+	b.Fail("efs:ListTagsForResource", -1, errors.New("ThrottlingException"))
+
+	failedOut := filepath.Join(t.TempDir(), "failed.json")
+	opts := tagging.DefaultOptions()
+	opts.Regions = []string{"us-east-1"}
+	opts.Apply = true
+	opts.TagStorage = true
+	opts.FailedOut = failedOut
+
+	report, text := runText(t, opts, b)
+	failed := report.Failed()
+	if len(failed) == 0 || len(failed) != report.Errors() {
+		t.Fatalf("expected failed resources to be reported, got %d of %d errors", len(failed), report.Errors())
+	}
+	readFailed := false
+	for _, res := range failed {
+		readFailed = readFailed || (res.ID == "fs-0efs" && strings.HasPrefix(res.Error, "cannot read tags"))
+	}
+	if !readFailed {
+		t.Error("fs-0efs: expected its tags read error to be reported")
+	}
+	if report.FailedPlan != failedOut || !strings.Contains(text, "Failed after retries") || !strings.Contains(text, "tagging apply-plan "+failedOut) {
+		t.Errorf("expected the footer to list failures and the re-run plan, got:\n%s", text)
+	}
+
+	// Only tags that failed to write can be retried from the plan
+	plan, err := tagging.ReadPlan(failedOut)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, change := range plan.Changes {
+		if strings.HasPrefix(change.Type, "EFS ") {
+			t.Errorf("unexpected %s %s in the failed plan", change.Type, change.ID)
+		}
+	}
+	if len(plan.Changes) == 0 {
+		t.Fatal("expected the EC2 tags to be saved in the failed plan")
+	}
+
+	b.Fail("ec2:CreateTags", 0, nil)
+	opts = tagging.DefaultOptions()
+	opts.Mode = tagging.ModeApplyPlan
+	opts.PlanFile = failedOut
+	report, _ = runText(t, opts, b)
+	if report.Errors() != 0 {
+		t.Fatalf("expected the failed plan to apply cleanly, got %d errors", report.Errors())
+	}
+	if got := b.Tags("us-east-1", "vol-0aaa"); got["Name"] == "" {
+		t.Errorf("vol-0aaa: expected the retried tags, got %v", got)
+	}
+}
+
+func TestRun_EC2ReadsTagsInBulk(t *testing.T) {
+	b := fakeaws.New()
+	b.PageSize = 2
+	for i := 0; i < 5; i++ {
+		id := fmt.Sprintf("vol-0%d", i)
+		b.AddInstance("us-east-1", fakeaws.Instance{ID: fmt.Sprintf("i-0%d", i), VolumeIDs: []string{id, "vol-0deleted"}})
+		b.AddVolume("us-east-1", fakeaws.Volume{ID: id})
+	}
+
+	opts := tagging.DefaultOptions()
+	opts.Mode = tagging.ModeEC2
+	opts.Regions = []string{"us-east-1"}
+	report, _ := runText(t, opts, b)
+
+	// Every page of instances is processed; the deleted volume is skipped
+	counts := map[string]int{}
+	for _, res := range report.Resources() {
+		counts[res.Type]++
+	}
+	if counts["EC2 Instance"] != 5 || counts["Volume"] != 5 {
+		t.Errorf("expected 5 instances and 5 volumes, got %v", counts)
+	}
+	// 3 pages of instances, 3 pages of the 5 volumes read in one batch
+	if n := b.CallCount("ec2:DescribeInstances"); n != 3 {
+		t.Errorf("expected 3 DescribeInstances pages, got %d", n)
+	}
+	if n := b.CallCount("ec2:DescribeVolumes"); n != 3 {
+		t.Errorf("expected 3 DescribeVolumes pages, got %d", n)
+	}
+
+	// A failed read is reported on each volume of the batch
+	b.Fail("ec2:DescribeVolumes", 1, errors.New("RequestLimitExceeded"))
+	report, _ = runText(t, opts, b)
+	for _, res := range report.Resources() {
+		if res.Type == "Volume" && !strings.HasPrefix(res.Error, "cannot read tags") {
+			t.Errorf("%s: expected a read error, got %+v", res.ID, res)
+		}
+	}
+}
+
+func TestRun_CleanupRemovesToolManagedKeys(t *testing.T) {
+	b := newFixture()
+	b.AddVolume("us-east-1", fakeaws.Volume{ID: "vol-0old", Tags: map[string]string{"Name": "old", "old-web": "", "old-db": "", "Owner": "ops"}})
+	b.AddEFSFileSystem("us-east-1", fakeaws.EFSFileSystem{ID: "fs-0tmp", Tags: map[string]string{"tmp-1": "", "tmp-10": ""}})
+
+	// Key patterns
+	opts := tagging.DefaultOptions()
+	opts.Mode = tagging.ModeCleanup
+	opts.Regions = []string{"us-east-1"}
+	opts.CleanupKeys = []string{"old-*", "tmp-?"}
+	report, text := runText(t, opts, b)
+	assertGolden(t, "cleanup", text)
+	if n := len(report.Changes()); n != 2 {
+		t.Errorf("expected 2 resources to clean up, got %d", n)
+	}
+
+	opts.Apply = true
+	runText(t, opts, b)
+	if got := b.Tags("us-east-1", "vol-0old"); len(got) != 2 || got["Owner"] != "ops" {
+		t.Errorf("vol-0old: expected only Name and Owner to remain, got %v", got)
+	}
+	if got := b.Tags("us-east-1", "fs-0tmp"); len(got) != 1 || got["tmp-10"] != "" {
+		t.Errorf("fs-0tmp: expected tmp-10 to remain, got %v", got)
+	}
+
+	// Keys a journal recorded the tool adding, except Name and keys changed since
+	journal := filepath.Join(t.TempDir(), "journal.ndjson")
+	apply := tagging.DefaultOptions()
+	apply.Regions = []string{"us-east-1"}
+	apply.Apply = true
+	apply.Journal = journal
+	runText(t, apply, b)
+
+	_, err := b.EC2("us-east-1").CreateTags(context.Background(), &ec2.CreateTagsInput{
+		Resources: []string{"vol-0aaa"},
+		Tags:      []ec2types.Tag{{Key: aws.String("web-server-01"), Value: aws.String("kept")}},
+	})
+	if err != nil {
+		t.Fatalf("CreateTags: %v", err)
+	}
+
+	opts = tagging.DefaultOptions()
+	opts.Mode = tagging.ModeCleanup
+	opts.Regions = []string{"us-east-1"}
+	opts.FromJournals = []string{journal}
+	opts.Apply = true
+	runText(t, opts, b)
+
+	if got := b.Tags("us-east-1", "i-0aaa"); len(got) != 2 || got["Env"] != "prod" {
+		t.Errorf("i-0aaa: expected the machine key to be removed, got %v", got)
+	}
+	if got := b.Tags("us-east-1", "vol-0aaa"); got["Name"] == "" || got["web-server-01"] != "kept" {
+		t.Errorf("vol-0aaa: expected Name and the changed key to remain, got %v", got)
+	}
+	if got := b.Tags("us-east-1", "i-0bbb"); len(got) != 1 || got["Name"] != "i-0bbb" {
+		t.Errorf("i-0bbb: expected only the Name written by the tool to remain, got %v", got)
+	}
+}
+
+func TestRun_CleanupPagesAndCanBeUndone(t *testing.T) {
+	b := fakeaws.New()
+	b.PageSize = 1
+	// Owner sorts first, so the keys to remove are only on later tag pages
+	for _, id := range []string{"fs-0aaa", "fs-0bbb", "fs-0ccc"} {
+		b.AddEFSFileSystem("us-east-1", fakeaws.EFSFileSystem{ID: id, Tags: map[string]string{"Owner": "ops", "tmp-1": "a"}})
+	}
+	b.AddEFSAccessPoint("us-east-1", fakeaws.EFSAccessPoint{ID: "fsap-0aaa", FileSystemID: "fs-0ccc", Tags: map[string]string{"Owner": "ops", "tmp-1": "b"}})
+	b.AddEFSAccessPoint("us-east-1", fakeaws.EFSAccessPoint{ID: "fsap-0bbb", FileSystemID: "fs-0ccc", Tags: map[string]string{"Owner": "ops", "tmp-1": "c"}})
+	for _, id := range []string{"backup-0aaa", "backup-0bbb"} {
+		b.AddFSxBackup("us-east-1", fakeaws.FSxBackup{ID: id, Tags: map[string]string{"Owner": "ops", "tmp-1": "d"}})
+	}
+	b.AddVolume("us-east-1", fakeaws.Volume{ID: "vol-0aaa", Tags: map[string]string{"tmp-1": "e", "Owner": "ops"}})
+
+	opts := tagging.DefaultOptions()
+	opts.Mode = tagging.ModeCleanup
+	opts.Regions = []string{"us-east-1"}
+	opts.CleanupKeys = []string{"tmp-*"}
+	opts.PlanOut = filepath.Join(t.TempDir(), "plan.json")
+	report, _ := runText(t, opts, b)
+
+	// Every page of every listing, and of their tags, is cleaned up
+	if n := len(report.Changes()); n != 8 {
+		t.Errorf("expected 8 resources to clean up, got %d", n)
+	}
+
+	// The saved plan applies without mistaking the later tag pages for drift
+	journal := filepath.Join(t.TempDir(), "journal.ndjson")
+	apply := tagging.DefaultOptions()
+	apply.Mode = tagging.ModeApplyPlan
+	apply.PlanFile = opts.PlanOut
+	apply.Journal = journal
+	if _, err := tagging.NewEngine(apply, b).Run(context.Background()); err != nil {
+		t.Fatalf("expected the cleanup plan to apply cleanly, got %v", err)
+	}
+	if got := b.Tags("us-east-1", "fsap-0bbb"); len(got) != 1 || got["Owner"] != "ops" {
+		t.Errorf("fsap-0bbb: expected only Owner to remain, got %v", got)
+	}
+	if got := b.Tags("us-east-1", fakeaws.FSxARN("us-east-1", "backup", "backup-0aaa")); len(got) != 1 {
+		t.Errorf("backup-0aaa: expected only Owner to remain, got %v", got)
+	}
+
+	// The removed tags are journaled with their values and undo puts them back
+	undo := tagging.DefaultOptions()
+	undo.Mode = tagging.ModeUndo
+	undo.UndoFile = journal
+	undo.Apply = true
+	if report, _ := runText(t, undo, b); report.Errors() != 0 {
+		t.Fatalf("expected the undo to apply cleanly, got %d errors", report.Errors())
+	}
+	if got := b.Tags("us-east-1", "vol-0aaa"); len(got) != 2 || got["tmp-1"] != "e" || got["Owner"] != "ops" {
+		t.Errorf("vol-0aaa: expected its tags back, got %v", got)
+	}
+	if got := b.Tags("us-east-1", fakeaws.FSxARN("us-east-1", "backup", "backup-0bbb")); got["tmp-1"] != "d" {
+		t.Errorf("backup-0bbb: expected its tags back, got %v", got)
+	}
+	if got := b.Tags("us-east-1", "fsap-0bbb"); got["tmp-1"] != "c" {
+		t.Errorf("fsap-0bbb: expected its tags back, got %v", got)
+	}
+}
+
+// seedRenamed adds an instance renamed from "web 01" to "web 02", with the
+// old machine key left on it, its volume, and snapshots of the volume and of
+// a volume deleted since
+func seedRenamed() *fakeaws.Backend {
+	b := fakeaws.New()
+	b.AddInstance("us-east-1", fakeaws.Instance{
+		ID:        "i-0web",
+		Tags:      map[string]string{"Name": "web 02", "web-01": "", "web-02": "", "Env": ""},
+		VolumeIDs: []string{"vol-0web"},
+	})
+	b.AddVolume("us-east-1", fakeaws.Volume{ID: "vol-0web", InstanceID: "i-0web", Tags: map[string]string{"Name": "web 01", "web-01": ""}})
+	b.AddSnapshot("us-east-1", fakeaws.Snapshot{ID: "snap-0web", VolumeID: "vol-0web", Tags: map[string]string{"Name": "web 01", "web-01": ""}})
+	b.AddSnapshot("us-east-1", fakeaws.Snapshot{ID: "snap-0old", VolumeID: "vol-0gone", Tags: map[string]string{"Name": "web 01", "web-01": ""}})
+	b.AddSnapshot("us-east-1", fakeaws.Snapshot{ID: "snap-0db", VolumeID: "vol-0gone", Tags: map[string]string{"Name": "db", "db": ""}})
+	return b
+}
+
+// writeRenamedJournal saves the journal of the run that tagged the renamed
+// instance under its earlier Name: it added web-01 to the instance and its
+// volume, and wrote the Name of its snapshot
+func writeRenamedJournal(t *testing.T) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "earlier.ndjson")
+	var buf bytes.Buffer
+	for _, entry := range []tagging.JournalEntry{
+		{Region: "us-east-1", Type: "EC2 Instance", ID: "i-0web", Key: "web-01"},
+		{Region: "us-east-1", Type: "Volume", ID: "vol-0web", Key: "web-01"},
+		{Region: "us-east-1", Type: "Snapshot", ID: "snap-0web", Key: "Name", Value: "web 01"},
+	} {
+		line, err := json.Marshal(entry)
+		if err != nil {
+			t.Fatalf("Marshal: %v", err)
+		}
+		buf.Write(append(line, '\n'))
+	}
+	if err := os.WriteFile(path, buf.Bytes(), 0o644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	return path
+}
+
+func TestRun_StaleMachineKeysAfterRename(t *testing.T) {
+	b := seedRenamed()
+	earlier := writeRenamedJournal(t)
+	opts := tagging.DefaultOptions()
+	opts.Mode = tagging.ModeEC2
+	opts.Regions = []string{"us-east-1"}
+	opts.PropagateKeys = []string{"Env"}
+	opts.FromJournals = []string{earlier}
+	report, text := runText(t, opts, b)
+	assertGolden(t, "stale_keys", text)
+
+	stale := []string{}
+	for _, res := range report.Stale() {
+		stale = append(stale, res.ID)
+	}
+	if got := strings.Join(stale, ","); got != "i-0web,vol-0web,snap-0web,snap-0old" {
+		t.Errorf("unexpected resources with stale keys %s", got)
+	}
+
+	// A saved migration plan carries the removals to apply-plan
+	opts.MigrateStaleKeys = true
+	opts.PlanOut = filepath.Join(t.TempDir(), "plan.json")
+	runText(t, opts, b)
+
+	apply := tagging.DefaultOptions()
+	apply.Mode = tagging.ModeApplyPlan
+	apply.PlanFile = opts.PlanOut
+	if report, _ := runText(t, apply, b); report.Errors() != 0 {
+		t.Fatalf("expected the migration plan to apply cleanly, got %d errors", report.Errors())
+	}
+	for _, id := range []string{"i-0web", "vol-0web", "snap-0web", "snap-0old"} {
+		got := b.Tags("us-east-1", id)
+		if _, ok := got["web-01"]; ok {
+			t.Errorf("%s: expected the stale key to be removed, got %v", id, got)
+		}
+		if _, ok := got["web-02"]; !ok {
+			t.Errorf("%s: expected the current machine key, got %v", id, got)
+		}
+	}
+	if got := b.Tags("us-east-1", "i-0web"); len(got) != 3 {
+		t.Errorf("i-0web: expected Name, the machine key and Env to remain, got %v", got)
+	}
+	if got := b.Tags("us-east-1", "snap-0db"); len(got) != 2 {
+		t.Errorf("snap-0db: expected an unrelated snapshot to be left alone, got %v", got)
+	}
+
+	// Resources that fail to get the current key keep the stale one, while
+	// the instance already has it
+	b = seedRenamed()
+	b.Fail("ec2:CreateTags", -1, errors.New("RequestLimitExceeded: rate exceeded"))
+	opts = tagging.DefaultOptions()
+	opts.Mode = tagging.ModeEC2
+	opts.Regions = []string{"us-east-1"}
+	opts.PropagateKeys = []string{"Env"}
+	opts.FromJournals = []string{earlier}
+	opts.MigrateStaleKeys = true
+	opts.Apply = true
+	opts.Journal = filepath.Join(t.TempDir(), "journal.ndjson")
+	runText(t, opts, b)
+	if got := b.Tags("us-east-1", "vol-0web"); got["web-01"] != "" || len(got) != 2 {
+		t.Errorf("vol-0web: expected the stale key to be kept, got %v", got)
+	}
+	if got := b.Tags("us-east-1", "i-0web"); got["web-02"] != "" || len(got) != 3 {
+		t.Errorf("i-0web: expected the stale key to be removed, got %v", got)
+	}
+}
+
+func TestRun_KeepsPossiblyStaleKeys(t *testing.T) {
+	possible := func(report *tagging.Report) string {
+		got := []string{}
+		for _, res := range report.PossiblyStale() {
+			got = append(got, res.ID+":"+strings.Join(res.PossiblyStaleKeys, "+"))
+		}
+		return strings.Join(got, ",")
+	}
+
+	// Without a journal, web-01 may be the user's own empty tag
+	b := seedRenamed()
+	opts := tagging.DefaultOptions()
+	opts.Mode = tagging.ModeEC2
+	opts.Regions = []string{"us-east-1"}
+	opts.MigrateStaleKeys = true
+	opts.Apply = true
+	opts.Journal = filepath.Join(t.TempDir(), "journal.ndjson")
+	report, text := runText(t, opts, b)
+	if len(report.Stale()) != 0 {
+		t.Errorf("expected no stale keys without a journal, got %d resources", len(report.Stale()))
+	}
+	if got := possible(report); got != "i-0web:Env+web-01,vol-0web:web-01,snap-0web:web-01,snap-0old:web-01" {
+		t.Errorf("unexpected possibly stale keys %s", got)
+	}
+	if !strings.Contains(text, "[STALE?] EC2 Instance i-0web → Env may be the machine key of an earlier Name") {
+		t.Errorf("expected the possibly stale Env key to be reported, got:\n%s", text)
+	}
+	if got := b.Tags("us-east-1", "i-0web"); len(got) != 4 {
+		t.Errorf("i-0web: expected every tag to be kept, got %v", got)
+	}
+	if got := b.Tags("us-east-1", "snap-0old"); len(got) != 2 {
+		t.Errorf("snap-0old: expected no current machine key without a stale one, got %v", got)
+	}
+
+	// With one, the tool's web-01 is migrated, the non-propagated empty Env
+	// tag is not
+	b = seedRenamed()
+	opts.FromJournals = []string{writeRenamedJournal(t)}
+	opts.Journal = filepath.Join(t.TempDir(), "journal.ndjson")
+	report, _ = runText(t, opts, b)
+	if got := possible(report); got != "i-0web:Env" {
+		t.Errorf("unexpected possibly stale keys %s", got)
+	}
+	if got := b.Tags("us-east-1", "i-0web"); len(got) != 3 || got["Env"] != "" || got["web-02"] != "" {
+		t.Errorf("i-0web: expected Name, the machine key and Env, got %v", got)
+	}
+
+	// The migration is journaled and undo puts the stale keys back
+	undo := tagging.DefaultOptions()
+	undo.Mode = tagging.ModeUndo
+	undo.UndoFile = opts.Journal
+	undo.Apply = true
+	if report, _ := runText(t, undo, b); report.Errors() != 0 {
+		t.Fatalf("expected the undo to apply cleanly, got %d errors", report.Errors())
+	}
+	want := seedRenamed()
+	for _, id := range []string{"i-0web", "vol-0web", "snap-0web", "snap-0old"} {
+		got, orig := b.Tags("us-east-1", id), want.Tags("us-east-1", id)
+		if len(got) != len(orig) {
+			t.Errorf("%s: expected %v after undo, got %v", id, orig, got)
+			continue
+		}
+		for k, v := range orig {
+			if value, ok := got[k]; !ok || value != v {
+				t.Errorf("%s: expected %v after undo, got %v", id, orig, got)
+				break
+			}
+		}
+	}
+
+	// Opting in migrates every possibly stale key, Env included
+	b = seedRenamed()
+	opts.FromJournals = nil
+	opts.MigrateUnjournaledKeys = true
+	opts.Journal = filepath.Join(t.TempDir(), "journal.ndjson")
+	report, _ = runText(t, opts, b)
+	if got := possible(report); got != "" {
+		t.Errorf("expected no possibly stale keys left, got %s", got)
+	}
+	if got := b.Tags("us-east-1", "i-0web"); len(got) != 2 || got["web-02"] != "" {
+		t.Errorf("i-0web: expected only Name and the machine key, got %v", got)
+	}
+}
+
+func TestRun_SelectsResources(t *testing.T) {
+	ids := func(report *tagging.Report) string {
+		got := []string{}
+		for _, res := range report.Resources() {
+			got = append(got, res.ShortID())
+		}
+		return strings.Join(got, ",")
+	}
+
+	// An instance selected by Name brings its volumes and snapshots
+	opts := tagging.DefaultOptions()
+	opts.Mode = tagging.ModeEC2
+	opts.Regions = []string{"us-east-1"}
+	opts.FilterName = "web ser?er*"
+	report, _ := runText(t, opts, newFixture())
+	if got := ids(report); got != "i-0aaa,vol-0aaa,snap-0aaa,snap-0ami" {
+		t.Errorf("--name: unexpected resources %s", got)
+	}
+
+	opts = tagging.DefaultOptions()
+	opts.Mode = tagging.ModeEBS
+	opts.Regions = []string{"us-east-1"}
+	opts.TagFilters = []tagging.TagFilter{{Key: "Name", AnyValue: true}}
+	opts.ExcludeTags = []tagging.TagFilter{{Key: "Name", Value: "scr*"}}
+	report, _ = runText(t, opts, newFixture())
+	if got := ids(report); got != "vol-0ddd" {
+		t.Errorf("--tag-filter/--exclude-tag: unexpected resources %s", got)
+	}
+
+	// Storage resources are listed by ID, FSx ones by the end of their ARN
+	opts = tagging.DefaultOptions()
+	opts.Regions = []string{"us-east-1"}
+	opts.TagStorage = true
+	opts.FilterIDs = []string{"fsap-0aaa", "fs-0fsx"}
+	report, _ = runText(t, opts, newFixture())
+	if got := ids(report); got != "fsap-0aaa,fs-0fsx" {
+		t.Errorf("--ids: unexpected resources %s", got)
+	}
+
+	// Orphaned snapshots are only named when selected
+	opts = tagging.DefaultOptions()
+	opts.Regions = []string{"us-east-1"}
+	opts.FixOrphans = true
+	opts.FilterIDs = []string{"snap-0old"}
+	report, _ = runText(t, opts, newFixture())
+	if got := ids(report); got != "snap-0old" {
+		t.Errorf("--fix-orphans --ids: unexpected resources %s", got)
+	}
+}
+
+func TestRun_ProtectedResourcesAreSkipped(t *testing.T) {
+	b := newFixture()
+	_, err := b.EC2("us-east-1").CreateTags(context.Background(), &ec2.CreateTagsInput{
+		Resources: []string{"vol-0aaa"},
+		Tags:      []ec2types.Tag{{Key: aws.String(tagging.ProtectTag), Value: aws.String("TRUE")}},
+	})
+	if err != nil {
+		t.Fatalf("CreateTags: %v", err)
+	}
+	excludeFile := filepath.Join(t.TempDir(), "exclude.txt")
+	if err := os.WriteFile(excludeFile, []byte("# never touch\ni-0bbb\n\nfs-0fsx\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	opts := tagging.DefaultOptions()
+	opts.Regions = []string{"us-east-1"}
+	opts.TagStorage = true
+	opts.ExcludeFile = excludeFile
+	opts.Apply = true
+	opts.Journal = filepath.Join(t.TempDir(), "journal.ndjson")
+	report, text := runText(t, opts, b)
+
+	skipped := []string{}
+	for _, res := range report.Resources() {
+		if res.Skipped != "" {
+			skipped = append(skipped, res.ShortID()+" "+res.Skipped)
+		}
+	}
+	want := "vol-0aaa protected: coaws:skip=true,i-0bbb protected: exclusion file,fs-0fsx protected: exclusion file"
+	if got := strings.Join(skipped, ","); got != want {
+		t.Errorf("unexpected skipped resources %s", got)
+	}
+	if !strings.Contains(text, "[SKIP] Volume vol-0aaa → skipped (protected: coaws:skip=true)") || !strings.Contains(text, "3 skipped (protected)") {
+		t.Errorf("expected protected resources in the output, got:\n%s", text)
+	}
+	if got := b.Tags("us-east-1", "vol-0aaa"); len(got) != 1 {
+		t.Errorf("vol-0aaa: expected no change, got %v", got)
+	}
+	if got := b.Tags("us-east-1", "i-0bbb"); len(got) != 0 {
+		t.Errorf("i-0bbb: expected no change, got %v", got)
+	}
+	if got := b.Tags("us-east-1", "snap-0aaa"); got["Name"] != "web server 01" {
+		t.Errorf("snap-0aaa: expected unprotected resources to be tagged, got %v", got)
+	}
+
+	// Removals honour protection too
+	opts = tagging.DefaultOptions()
+	opts.Mode = tagging.ModeCleanup
+	opts.Regions = []string{"us-east-1"}
+	opts.CleanupKeys = []string{"*"}
+	opts.Apply = true
+	runText(t, opts, b)
+	if got := b.Tags("us-east-1", "vol-0aaa"); got[tagging.ProtectTag] != "TRUE" {
+		t.Errorf("vol-0aaa: expected the protection tag to remain, got %v", got)
+	}
+}
+
+func TestRun_MultipleAccounts(t *testing.T) {
+	b := fakeaws.New()
+	for id, name := range map[string]string{"111111111111": "web", "222222222222": "db"} {
+		member := b.Account(id)
+		member.AddInstance("us-east-1", fakeaws.Instance{ID: "i-" + id[:4], Tags: map[string]string{"Name": name}, VolumeIDs: []string{"vol-" + id[:4]}})
+		member.AddVolume("us-east-1", fakeaws.Volume{ID: "vol-" + id[:4], InstanceID: "i-" + id[:4]})
+	}
+	b.AddInstance("us-east-1", fakeaws.Instance{ID: "i-0root", Tags: map[string]string{"Name": "root"}})
+	b.DenyAccount("333333333333")
+
+	planFile := filepath.Join(t.TempDir(), "plan.json")
+	opts := tagging.DefaultOptions()
+	opts.Regions = []string{"us-east-1"}
+	opts.Accounts = []string{"111111111111", "arn:aws:iam::222222222222:role/Tagger", "333333333333"}
+	opts.PlanOut = planFile
+	report, text := runText(t, opts, b)
+
+	if got := strings.Join(report.Accounts, ","); got != "111111111111,222222222222" {
+		t.Errorf("unexpected accounts %s", got)
+	}
+	if len(report.Warnings) != 1 || !strings.Contains(report.Warnings[0], "account 333333333333") {
+		t.Errorf("expected a warning for the account that cannot be assumed, got %v", report.Warnings)
+	}
+	for _, res := range report.Resources() {
+		if res.ID == "i-0root" {
+			t.Error("expected the caller's own account not to be processed")
+		}
+		if !strings.HasPrefix(res.Account, res.ID[strings.Index(res.ID, "-")+1:]) {
+			t.Errorf("%s: reported in account %s", res.ID, res.Account)
+		}
+	}
+	if !strings.Contains(text, "ACCOUNT: 222222222222 | REGION: US-EAST-1") || !strings.Contains(text, "[SUMMARY] 111111111111/us-east-1") {
+		t.Errorf("expected account headings in the output, got:\n%s", text)
+	}
+
+	// The plan remembers the account of each change
+	opts = tagging.DefaultOptions()
+	opts.Mode = tagging.ModeApplyPlan
+	opts.PlanFile = planFile
+	opts.Journal = filepath.Join(t.TempDir(), "journal.ndjson")
+	runText(t, opts, b)
+	if got := b.Account("222222222222").Tags("us-east-1", "vol-2222"); got["Name"] != "db" {
+		t.Errorf("vol-2222: expected the plan to be applied in its account, got %v", got)
+	}
+
+	opts.Mode = tagging.ModeUndo
+	opts.UndoFile = opts.Journal
+	opts.Journal = ""
+	opts.Apply = true
+	runText(t, opts, b)
+	if got := b.Account("111111111111").Tags("us-east-1", "vol-1111"); len(got) != 0 {
+		t.Errorf("vol-1111: expected undo to revert its tags, got %v", got)
+	}
+}
+
+func TestRun_DiscoversOrganizationAccounts(t *testing.T) {
+	b := fakeaws.New()
+	b.PageSize = 1
+	for _, id := range []string{"111111111111", "222222222222", "333333333333"} {
+		b.Account(id).AddInstance("us-east-1", fakeaws.Instance{ID: "i-" + id[:4], Tags: map[string]string{"Name": id[:4]}})
+	}
+	b.SuspendAccount("333333333333")
+
+	// Every active account but the caller's own, listed ones only once
+	opts := tagging.DefaultOptions()
+	opts.Regions = []string{"us-east-1"}
+	opts.Accounts = []string{"222222222222", tagging.AccountsOrganization}
+	report, _ := runText(t, opts, b)
+	if got := strings.Join(report.Accounts, ","); got != "222222222222,111111111111" {
+		t.Errorf("unexpected accounts %s", got)
+	}
+	if n := b.CallCount("organizations:ListAccounts"); n != 4 {
+		t.Errorf("expected every page of accounts to be listed, got %d ListAccounts calls", n)
+	}
+
+	// Without the organization's accounts, nothing runs
+	b.Fail("organizations:ListAccounts", 1, errors.New("AWSOrganizationsNotInUseException: your account is not a member of an organization"))
+	report, err := tagging.NewEngine(opts, b).Run(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "cannot list the accounts of the organization") {
+		t.Errorf("expected the listing failure to fail the run, got %v", err)
+	}
+	if len(report.Resources()) != 0 {
+		t.Errorf("expected no account to be processed, got %d resources", len(report.Resources()))
+	}
+}
+
+func TestRun_AuditCoversEveryAccount(t *testing.T) {
+	b := fakeaws.New()
+	b.Account("111111111111").AddInstance("us-east-1", fakeaws.Instance{ID: "i-1111", Tags: map[string]string{"Name": "web", "web": ""}})
+	b.Account("222222222222").AddInstance("us-east-1", fakeaws.Instance{ID: "i-2222", Tags: map[string]string{"Name": "db"}})
+	b.DenyAccount("333333333333")
+
+	opts := tagging.DefaultOptions()
+	opts.Mode = tagging.ModeAudit
+	opts.Regions = []string{"us-east-1"}
+	opts.MinCompliance = 0
+
+	// The summary covers every account audited, and the last one skipped
+	opts.Accounts = []string{"111111111111", "222222222222", "333333333333"}
+	report, err := tagging.NewEngine(opts, b).Run(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "cannot be verified") {
+		t.Errorf("expected the audit to fail when an account cannot be assumed, got %v", err)
+	}
+	if c := report.Compliance; c.Passed() || c.Incomplete != 1 || c.Resources != 2 || c.Compliant != 1 {
+		t.Errorf("expected 1/2 compliant resources and 1 skipped account, got %+v", c)
+	}
+
+	// Auditing no account at all is no pass either
+	opts.Accounts = []string{"333333333333"}
+	report, err = tagging.NewEngine(opts, b).Run(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "no account could be audited") {
+		t.Errorf("expected the audit to fail when no account is audited, got %v", err)
+	}
+	if report.Compliance == nil || report.Compliance.Passed() {
+		t.Errorf("expected a failed compliance summary, got %+v", report.Compliance)
+	}
+}
+
+func TestRun_DiscoversEnabledRegions(t *testing.T) {
+	b := fakeaws.New()
+	for _, region := range []string{"us-east-1", "ap-east-1", "me-south-1", "eu-south-2"} {
+		b.AddInstance(region, fakeaws.Instance{ID: "i-" + region, Tags: map[string]string{"Name": region}})
+	}
+	b.SetRegionOptIn("ap-east-1", "opted-in")
+	b.SetRegionOptIn("me-south-1", "not-opted-in")
+
+	regionNames := func(r *tagging.Report) string {
+		names := []string{}
+		for _, rr := range r.Regions {
+			names = append(names, rr.Region)
+		}
+		return strings.Join(names, ",")
+	}
+
+	opts := tagging.DefaultOptions()
+	opts.RegionDiscovery = tagging.RegionsAuto
+	opts.RegionCacheFile = filepath.Join(t.TempDir(), "regions.json")
+	opts.ExcludeRegions = []string{"eu-*"}
+	report, _ := runText(t, opts, b)
+	if got := regionNames(report); got != "ap-east-1,us-east-1" {
+		t.Errorf("expected the enabled regions, got %s", got)
+	}
+
+	// The discovery is cached until refreshed
+	runText(t, opts, b)
+	if n := b.CallCount("ec2:DescribeRegions"); n != 1 {
+		t.Errorf("expected the cached regions to be reused, got %d DescribeRegions calls", n)
+	}
+	opts.RefreshRegions = true
+	runText(t, opts, b)
+	if n := b.CallCount("ec2:DescribeRegions"); n != 2 {
+		t.Errorf("expected --refresh-regions to describe regions again, got %d calls", n)
+	}
+
+	// The cache is kept per account, whatever the profile, and skipped when
+	// the account cannot be told
+	opts.RefreshRegions = false
+	other := b.Account("222222222222")
+	other.AddRegion("us-west-2")
+	if report, _ := runText(t, opts, other); regionNames(report) != "us-west-2" {
+		t.Errorf("expected the regions of the other account, got %s", regionNames(report))
+	}
+	b.Fail("sts:GetCallerIdentity", -1, errors.New("ExpiredToken: the security token has expired"))
+	runText(t, opts, b)
+	if n := b.CallCount("ec2:DescribeRegions"); n != 3 {
+		t.Errorf("expected regions to be described again without an account, got %d calls", n)
+	}
+	b.Fail("sts:GetCallerIdentity", 0, nil)
+
+	opts.RegionDiscovery = tagging.RegionsAll
+	opts.ExcludeRegions = nil
+	opts.IncludeRegions = []string{"me-*", "eu-*"}
+	report, _ = runText(t, opts, b)
+	if got := regionNames(report); got != "eu-south-2,me-south-1" {
+		t.Errorf("expected every region matching the includes, got %s", got)
+	}
+}
+
+func TestRun_ActivateFiltersAndBatchesKeys(t *testing.T) {
+	b := fakeaws.New()
+	b.PageSize = 10
+	for i := 0; i < 45; i++ {
+		key := fmt.Sprintf("machine-%02d", i)
+		b.AddInstance("us-east-1", fakeaws.Instance{ID: fmt.Sprintf("i-%04d", i), Tags: map[string]string{key: ""}})
+		if i < 12 {
+			b.SetCostAllocationTag(key, cetypes.CostAllocationTagStatusActive)
+		}
+	}
+	b.AddInstance("us-east-1", fakeaws.Instance{ID: "i-team", Tags: map[string]string{"Team": "finops", "aws:cloudformation:stack-name": "web"}})
+	b.SetCostAllocationTag("Team", cetypes.CostAllocationTagStatusInactive)
+	b.RefuseCostAllocationTag("machine-20", "ValidationException", "tag key not found")
+
+	opts := tagging.DefaultOptions()
+	opts.Mode = tagging.ModeActivate
+	opts.Regions = []string{"us-east-1"}
+	opts.IncludeKeys = []string{"machine-*", "Team"}
+	opts.ExcludeKeys = []string{"machine-4?"}
+	opts.Apply = true
+	report, err := tagging.NewEngine(opts, b).Run(context.Background())
+	if err == nil {
+		t.Error("expected the refused key to fail the run")
+	}
+
+	ca := report.CostAllocation
+	if ca.ActiveKeys != 12 || len(ca.Eligible) != 29 || ca.Skipped != 6 {
+		t.Errorf("unexpected keys: %d active, %d eligible, %d skipped", ca.ActiveKeys, len(ca.Eligible), ca.Skipped)
+	}
+	if len(ca.Activated) != 28 || len(ca.Failed) != 1 || ca.Failed[0].Key != "machine-20" {
+		t.Errorf("unexpected result: %d activated, failed %+v", len(ca.Activated), ca.Failed)
+	}
+	if got := b.CostAllocationTagStatus("Team"); got != cetypes.CostAllocationTagStatusActive {
+		t.Errorf("expected the inactive Team key to be activated, got %q", got)
+	}
+	if got := b.CostAllocationTagStatus("machine-40"); got != "" {
+		t.Errorf("expected excluded keys to be left alone, got %q", got)
+	}
+	for op, want := range map[string]int{"ce:UpdateCostAllocationTagsStatus": 2, "ce:ListCostAllocationTags": 2, "tagging:GetTagKeys": 5} {
+		if got := b.CallCount(op); got != want {
+			t.Errorf("expected %d %s calls, got %d", want, op, got)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := tagging.RenderText(&buf, report); err != nil {
+		t.Fatalf("RenderText: %v", err)
+	}
+	if text := buf.String(); !strings.Contains(text, "[FAILED] machine-20: ValidationException: tag key not found") || !strings.Contains(text, "6 skipped by key filters") {
+		t.Errorf("expected the failed key in the output, got:\n%s", text)
+	}
+}
+
+func TestRun_ActivateReconcilesKeys(t *testing.T) {
+	b := fakeaws.New()
+	b.AddInstance("us-east-1", fakeaws.Instance{ID: "i-0web", Tags: map[string]string{"Name": "web-02", "web-02": "", "Team": "web"}})
+	b.AddEFSFileSystem("us-east-1", fakeaws.EFSFileSystem{ID: "fs-0web", Tags: map[string]string{"web-home": ""}})
+	b.AddFSxBackup("us-east-1", fakeaws.FSxBackup{ID: "backup-0web", Tags: map[string]string{"web-backup": ""}})
+	b.AddInstance("ap-south-1", fakeaws.Instance{ID: "i-0ap", Tags: map[string]string{"web-ap": ""}})
+	for key, status := range map[string]cetypes.CostAllocationTagStatus{
+		"web-01":     cetypes.CostAllocationTagStatusActive, // machine key of the instance's earlier Name
+		"web-ap":     cetypes.CostAllocationTagStatusActive, // only in ap-south-1
+		"web-02":     cetypes.CostAllocationTagStatusInactive,
+		"web-home":   cetypes.CostAllocationTagStatusActive, // only on EFS
+		"web-backup": cetypes.CostAllocationTagStatusActive, // only on FSx
+		"Team":       cetypes.CostAllocationTagStatusActive,
+		"Owner":      cetypes.CostAllocationTagStatusActive, // outside the desired set
+		"web-test":   cetypes.CostAllocationTagStatusActive, // excluded from it
+	} {
+		b.SetCostAllocationTag(key, status)
+	}
+
+	// Keys may be held in regions not searched, so every region is needed
+	opts := tagging.DefaultOptions()
+	opts.Mode = tagging.ModeActivate
+	opts.Regions = []string{"us-east-1"}
+	opts.IncludeKeys = []string{"web-*", "Team"}
+	opts.ExcludeKeys = []string{"web-test"}
+	opts.Reconcile = true
+	opts.Apply = true
+	if _, err := tagging.NewEngine(opts, b).Run(context.Background()); err == nil || !strings.Contains(err.Error(), "use --regions auto or all") {
+		t.Errorf("expected reconcile to need every region, got %v", err)
+	}
+	if n := b.CallCount("ce:UpdateCostAllocationTagsStatus"); n != 0 {
+		t.Errorf("expected no update without every region, got %d", n)
+	}
+
+	opts.Regions = nil
+	opts.RegionDiscovery = tagging.RegionsAuto
+	opts.RegionCacheFile = ""
+	b.Fail("ec2:DescribeRegions", 1, errors.New("UnauthorizedOperation: not authorized to perform ec2:DescribeRegions"))
+	if _, err := tagging.NewEngine(opts, b).Run(context.Background()); err == nil || !strings.Contains(err.Error(), "the regions could not be discovered") {
+		t.Errorf("expected reconcile to need the discovered regions, got %v", err)
+	}
+
+	opts.Apply = false
+	report, text := runText(t, opts, b)
+
+	ca := report.CostAllocation
+	if strings.Join(ca.Eligible, ",") != "web-02" || strings.Join(ca.Deactivate, ",") != "Owner,web-01,web-test" {
+		t.Errorf("unexpected plan: activate %v, deactivate %v", ca.Eligible, ca.Deactivate)
+	}
+	if !strings.Contains(text, "Tag keys to deactivate:\n    [PLAN] Owner\n    [PLAN] web-01\n    [PLAN] web-test") || !strings.Contains(text, "Use --apply to reconcile.") {
+		t.Errorf("expected the deactivation in the output, got:\n%s", text)
+	}
+	if n := b.CallCount("ce:UpdateCostAllocationTagsStatus"); n != 0 {
+		t.Errorf("dry-run issued %d updates", n)
+	}
+
+	opts.Apply = true
+	report, _ = runText(t, opts, b)
+	want := map[string]cetypes.CostAllocationTagStatus{
+		"web-01":     cetypes.CostAllocationTagStatusInactive,
+		"web-ap":     cetypes.CostAllocationTagStatusActive,
+		"web-02":     cetypes.CostAllocationTagStatusActive,
+		"web-home":   cetypes.CostAllocationTagStatusActive,
+		"web-backup": cetypes.CostAllocationTagStatusActive,
+		"Team":       cetypes.CostAllocationTagStatusActive,
+		"Owner":      cetypes.CostAllocationTagStatusInactive,
+		"web-test":   cetypes.CostAllocationTagStatusInactive,
+	}
+	for key, status := range want {
+		if got := b.CostAllocationTagStatus(key); got != status {
+			t.Errorf("%s: expected %s, got %s", key, status, got)
+		}
+	}
+	if ca := report.CostAllocation; len(ca.Activated) != 1 || len(ca.Deactivated) != 3 {
+		t.Errorf("unexpected result %+v", ca)
+	}
+
+	_, text = runText(t, opts, b)
+	if !strings.Contains(text, "Cost Allocation Tags are already reconciled.") {
+		t.Errorf("expected a second run to find nothing to do, got:\n%s", text)
+	}
+
+	// Keys that cannot be listed in a region may be in use there, so the
+	// run refuses to reconcile anything
+	b.SetCostAllocationTag("web-01", cetypes.CostAllocationTagStatusActive)
+	b.SetCostAllocationTag("web-03", cetypes.CostAllocationTagStatusInactive)
+	b.AddInstance("eu-west-1", fakeaws.Instance{ID: "i-0eu", Tags: map[string]string{"Name": "web-03", "web-03": ""}})
+	b.Fail("tagging:GetTagKeys", 1, errors.New("AccessDeniedException: not authorized to perform tag:GetTagKeys"))
+	updates := b.CallCount("ce:UpdateCostAllocationTagsStatus")
+	report, err := tagging.NewEngine(opts, b).Run(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "1 tag key listings failed") {
+		t.Errorf("expected the incomplete listing to fail the run, got %v", err)
+	}
+	if ca := report.CostAllocation; len(ca.Deactivate) != 0 || b.CallCount("ce:UpdateCostAllocationTagsStatus") != updates {
+		t.Errorf("expected nothing to be planned or updated, got %+v", ca)
+	}
+	if got := b.CostAllocationTagStatus("web-01"); got != cetypes.CostAllocationTagStatusActive {
+		t.Errorf("web-01: expected to stay active, got %s", got)
+	}
+}
+
+func TestRun_ActivateReconcilesMemberAccounts(t *testing.T) {
+	b := fakeaws.New()
+	b.AddInstance("us-east-1", fakeaws.Instance{ID: "i-0web", Tags: map[string]string{"Name": "web", "web": ""}})
+	b.Account("111111111111").AddInstance("us-east-1", fakeaws.Instance{ID: "i-0db", Tags: map[string]string{"Name": "db", "db": ""}})
+	b.SetCostAllocationTag("web", cetypes.CostAllocationTagStatusActive)
+	b.SetCostAllocationTag("db", cetypes.CostAllocationTagStatusActive) // only in the member account
+	b.SetCostAllocationTag("old", cetypes.CostAllocationTagStatusActive)
+
+	opts := tagging.DefaultOptions()
+	opts.Mode = tagging.ModeActivate
+	opts.RegionDiscovery = tagging.RegionsAuto
+	opts.RegionCacheFile = ""
+	opts.IncludeKeys = []string{"*"}
+	opts.Reconcile = true
+	opts.Apply = true
+
+	// The organization's other accounts may hold the keys
+	if _, err := tagging.NewEngine(opts, b).Run(context.Background()); err == nil || !strings.Contains(err.Error(), "accounts 111111111111 of the organization") {
+		t.Errorf("expected reconcile to need the member accounts, got %v", err)
+	}
+	b.DenyAccount("333333333333")
+	opts.Accounts = []string{tagging.AccountsOrganization, "333333333333"}
+	if _, err := tagging.NewEngine(opts, b).Run(context.Background()); err == nil || !strings.Contains(err.Error(), "1 tag key listings failed") {
+		t.Errorf("expected reconcile to need every account, got %v", err)
+	}
+	if n := b.CallCount("ce:UpdateCostAllocationTagsStatus"); n != 0 {
+		t.Errorf("expected no update without every account, got %d", n)
+	}
+
+	// Keys held in member accounts stay active
+	opts.Accounts = []string{tagging.AccountsOrganization}
+	report, text := runText(t, opts, b)
+	if !strings.Contains(text, "  Scanning region US-EAST-1...\n  Scanning account 111111111111, region US-EAST-1...") {
+		t.Errorf("expected the member account to be scanned, got:\n%s", text)
+	}
+	if got := strings.Join(report.CostAllocation.Deactivate, ","); got != "old" {
+		t.Errorf("expected only old to be deactivated, got %s", got)
+	}
+	if got := b.CostAllocationTagStatus("db"); got != cetypes.CostAllocationTagStatusActive {
+		t.Errorf("db: expected to stay active, got %s", got)
+	}
+
+	// An account outside of any organization has no others
+	b = fakeaws.New()
+	b.SetCostAllocationTag("old", cetypes.CostAllocationTagStatusActive)
+	b.AddRegion("us-east-1")
+	b.Fail("organizations:ListAccounts", 1, &orgtypes.AWSOrganizationsNotInUseException{Message: aws.String("Your account is not a member of an organization.")})
+	opts.Accounts = nil
+	report, _ = runText(t, opts, b)
+	if got := b.CostAllocationTagStatus("old"); got != cetypes.CostAllocationTagStatusInactive {
+		t.Errorf("old: expected to be deactivated, got %s (%+v)", got, report.CostAllocation)
+	}
+}
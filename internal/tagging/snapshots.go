@@ -0,0 +1,158 @@
+package tagging
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+// instanceRef matches the instance IDs referenced by a snapshot description,
+// such as "Created by CreateImage(i-0abc) for ami-0def"
+var instanceRef = regexp.MustCompile(`\bi-[0-9a-zA-Z]+\b`)
+
+// snapshotIndex holds the self-owned snapshots of a region, listed once and
+// looked up by ID, by volume and by the instance their description names
+type snapshotIndex struct {
+	snapshots  []types.Snapshot
+	byID       map[string]int
+	byVolume   map[string][]int
+	byInstance map[string][]int
+	err        error
+}
+
+func newSnapshotIndex(snapshots []types.Snapshot) *snapshotIndex {
+	idx := &snapshotIndex{
+		snapshots:  snapshots,
+		byID:       make(map[string]int),
+		byVolume:   make(map[string][]int),
+		byInstance: make(map[string][]int),
+	}
+	for i, snapshot := range snapshots {
+		idx.byID[aws.ToString(snapshot.SnapshotId)] = i
+		if volumeID := aws.ToString(snapshot.VolumeId); volumeID != "" {
+			idx.byVolume[volumeID] = append(idx.byVolume[volumeID], i)
+		}
+		desc := aws.ToString(snapshot.Description)
+		for _, id := range uniqueMatches(instanceRef, desc) {
+			idx.byInstance[id] = append(idx.byInstance[id], i)
+		}
+	}
+	return idx
+}
+
+// uniqueMatches returns the distinct matches of re in s
+func uniqueMatches(re *regexp.Regexp, s string) []string {
+	var found []string
+	for _, m := range re.FindAllString(s, -1) {
+		if !contains(found, m) {
+			found = append(found, m)
+		}
+	}
+	return found
+}
+
+// ofVolumes returns the snapshots of any of the volumes, in listing order
+func (idx *snapshotIndex) ofVolumes(volumeIDs []string) []types.Snapshot {
+	var positions []int
+	for _, volumeID := range volumeIDs {
+		positions = append(positions, idx.byVolume[volumeID]...)
+	}
+	return idx.at(positions)
+}
+
+// ofInstance returns the snapshots whose description names the instance
+func (idx *snapshotIndex) ofInstance(instanceID string) []types.Snapshot {
+	return idx.at(idx.byInstance[instanceID])
+}
+
+// get returns the snapshot with the given ID, if the account owns it
+func (idx *snapshotIndex) get(snapshotID string) (types.Snapshot, bool) {
+	i, ok := idx.byID[snapshotID]
+	if !ok {
+		return types.Snapshot{}, false
+	}
+	return idx.snapshots[i], true
+}
+
+// at returns the snapshots at the given positions, in listing order and once each
+func (idx *snapshotIndex) at(positions []int) []types.Snapshot {
+	sorted := append([]int(nil), positions...)
+	sort.Ints(sorted)
+	snapshots := []types.Snapshot{}
+	for i, pos := range sorted {
+		if i > 0 && sorted[i-1] == pos {
+			continue
+		}
+		snapshots = append(snapshots, idx.snapshots[pos])
+	}
+	return snapshots
+}
+
+// snapshotIndexes caches the snapshot and image indexes of each region for
+// the whole run
+type snapshotIndexes struct {
+	mu       sync.Mutex
+	byRegion map[string]*regionSnapshots
+}
+
+type regionSnapshots struct {
+	once sync.Once
+	idx  *snapshotIndex
+
+	imagesOnce sync.Once
+	images     *imageIndex
+}
+
+// regionSnapshots returns the cache entry of a region, creating it on first use
+func (e *Engine) regionSnapshots(region string) *regionSnapshots {
+	e.snapshots.mu.Lock()
+	defer e.snapshots.mu.Unlock()
+	rs, ok := e.snapshots.byRegion[region]
+	if !ok {
+		rs = &regionSnapshots{}
+		e.snapshots.byRegion[region] = rs
+	}
+	return rs
+}
+
+func newSnapshotIndexes() *snapshotIndexes {
+	return &snapshotIndexes{byRegion: make(map[string]*regionSnapshots)}
+}
+
+// snapshotIndex returns the snapshot index of rr's region, listing the
+// region's snapshots on first use. A listing error is warned about once, and
+// the snapshots listed before it are still indexed.
+func (e *Engine) snapshotIndex(ctx context.Context, client EC2API, rr *RegionReport) *snapshotIndex {
+	rs := e.regionSnapshots(rr.Region)
+	rs.once.Do(func() {
+		rs.idx = listSnapshots(ctx, client)
+		if rs.idx.err != nil {
+			rr.warn(fmt.Sprintf("Failed to describe snapshots: %v", rs.idx.err))
+		}
+	})
+	return rs.idx
+}
+
+// listSnapshots pages through the self-owned snapshots of a region
+func listSnapshots(ctx context.Context, client EC2API) *snapshotIndex {
+	var snapshots []types.Snapshot
+	paginator := ec2.NewDescribeSnapshotsPaginator(client, &ec2.DescribeSnapshotsInput{
+		OwnerIds: []string{"self"},
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			idx := newSnapshotIndex(snapshots)
+			idx.err = err
+			return idx
+		}
+		snapshots = append(snapshots, page.Snapshots...)
+	}
+	return newSnapshotIndex(snapshots)
+}
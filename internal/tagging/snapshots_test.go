@@ -0,0 +1,167 @@
+package tagging_test
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/Th3Mayar/aws-cost-optimization-tools/internal/tagging"
+	"github.com/Th3Mayar/aws-cost-optimization-tools/internal/tagging/fakeaws"
+)
+
+// seedSnapshots adds instances with one volume each, every volume having
+// perVolume snapshots plus one AMI snapshot, and unrelated snapshots of
+// deleted volumes up to total snapshots in the region
+func seedSnapshots(b *fakeaws.Backend, region string, instances, perVolume, total int) {
+	n := 0
+	for i := 0; i < instances; i++ {
+		instanceID := fmt.Sprintf("i-%05d", i)
+		volumeID := fmt.Sprintf("vol-%05d", i)
+		b.AddInstance(region, fakeaws.Instance{ID: instanceID, VolumeIDs: []string{volumeID}})
+		b.AddVolume(region, fakeaws.Volume{ID: volumeID, InstanceID: instanceID})
+		for j := 0; j < perVolume; j++ {
+			b.AddSnapshot(region, fakeaws.Snapshot{ID: fmt.Sprintf("snap-%06d", n), VolumeID: volumeID, Description: "nightly"})
+			n++
+		}
+		b.AddSnapshot(region, fakeaws.Snapshot{
+			ID:          fmt.Sprintf("snap-%06d", n),
+			VolumeID:    fmt.Sprintf("vol-ami%05d", i),
+			Description: fmt.Sprintf("Created by CreateImage(%s) for ami-%05d", instanceID, i),
+		})
+		n++
+	}
+	for ; n < total; n++ {
+		b.AddSnapshot(region, fakeaws.Snapshot{ID: fmt.Sprintf("snap-%06d", n), VolumeID: fmt.Sprintf("vol-gone%06d", n)})
+	}
+}
+
+func TestRun_ListsSnapshotsOncePerRegion(t *testing.T) {
+	b := fakeaws.New()
+	b.PageSize = 10
+	seedSnapshots(b, "us-east-1", 5, 2, 40)
+	// i-00001 must not pick up the AMI snapshots of i-000010 and up
+	b.AddSnapshot("us-east-1", fakeaws.Snapshot{ID: "snap-prefix", Description: "Created by CreateImage(i-000010) for ami-0x"})
+
+	opts := tagging.DefaultOptions()
+	opts.Mode = tagging.ModeEC2
+	opts.Regions = []string{"us-east-1"}
+	report, _ := runText(t, opts, b)
+
+	snapshots := map[string]int{}
+	for _, res := range report.Resources() {
+		if res.Type == "Snapshot" {
+			snapshots[res.ID]++
+		}
+	}
+	if len(snapshots) != 15 {
+		t.Errorf("expected the 15 snapshots of the 5 instances, got %d", len(snapshots))
+	}
+	for id, n := range snapshots {
+		if n != 1 || id == "snap-prefix" {
+			t.Errorf("%s: processed %d times", id, n)
+		}
+	}
+
+	// 5 pages to list the 41 snapshots, whose tags are reused, and a single
+	// read of the 5 instance volumes
+	if n := b.CallCount("ec2:DescribeSnapshots"); n != 5 {
+		t.Errorf("expected the region's snapshots to be listed once, got %d DescribeSnapshots calls", n)
+	}
+	if n := b.CallCount("ec2:DescribeVolumes"); n != 1 {
+		t.Errorf("expected the volumes to be read in one batch, got %d DescribeVolumes calls", n)
+	}
+}
+
+func BenchmarkRun_InstanceSnapshots(b *testing.B) {
+	backend := fakeaws.New()
+	backend.PageSize = 1000
+	seedSnapshots(backend, "us-east-1", 200, 4, 20000)
+
+	opts := tagging.DefaultOptions()
+	opts.Mode = tagging.ModeEC2
+	opts.Regions = []string{"us-east-1"}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := tagging.NewEngine(opts, backend).Run(context.Background()); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// seedAMIs adds an instance with an AMI created from it, a copy of that AMI,
+// an AMI registered by another tool and a snapshot of a deregistered AMI.
+// The second snapshot of the AMI lost its CreateImage description, so only
+// the AMI's source instance ties it to the instance.
+func seedAMIs() *fakeaws.Backend {
+	b := fakeaws.New()
+	b.AddInstance("us-east-1", fakeaws.Instance{ID: "i-0src", Tags: map[string]string{"Name": "builder"}, VolumeIDs: []string{"vol-0src"}})
+	b.AddVolume("us-east-1", fakeaws.Volume{ID: "vol-0src", InstanceID: "i-0src", Tags: map[string]string{"Name": "builder", "builder": ""}})
+
+	b.AddSnapshot("us-east-1", fakeaws.Snapshot{ID: "snap-0own1", VolumeID: "vol-0src", Description: "Created by CreateImage(i-0src) for ami-0own"})
+	b.AddSnapshot("us-east-1", fakeaws.Snapshot{ID: "snap-0own2", VolumeID: "vol-0data", Description: "data disk"})
+	b.AddImage("us-east-1", fakeaws.Image{ID: "ami-0own", Name: "golden-image", SnapshotIDs: []string{"snap-0own1", "snap-0own2"}, SourceInstanceID: "i-0src"})
+
+	b.AddSnapshot("us-east-1", fakeaws.Snapshot{ID: "snap-0copy", VolumeID: "vol-ffffffff", Description: "[Copied snap-0own1 from us-west-2] Created by CreateImage(i-0src) for ami-0own"})
+	b.AddImage("us-east-1", fakeaws.Image{ID: "ami-0copy", Name: "golden-copy", SnapshotIDs: []string{"snap-0copy"}})
+
+	// A description naming the instance does not make it the source
+	b.AddSnapshot("us-east-1", fakeaws.Snapshot{ID: "snap-0pack", VolumeID: "vol-ffffffff", Description: "packer build from i-0src for ami-0pack"})
+	b.AddImage("us-east-1", fakeaws.Image{ID: "ami-0pack", Name: "packer-base", SnapshotIDs: []string{"snap-0pack"}})
+
+	b.AddSnapshot("us-east-1", fakeaws.Snapshot{ID: "snap-0gone", VolumeID: "vol-0old", Description: "Created by CreateImage(i-0src) for ami-0dead"})
+	return b
+}
+
+func TestRun_AMILineage(t *testing.T) {
+	b := seedAMIs()
+	opts := tagging.DefaultOptions()
+	opts.Mode = tagging.ModeEC2
+	opts.Regions = []string{"us-east-1"}
+	opts.Apply = true
+	opts.Journal = filepath.Join(t.TempDir(), "journal.ndjson")
+	report, _ := runText(t, opts, b)
+
+	got := []string{}
+	for _, res := range report.Resources() {
+		if res.Type == "AMI" || res.Type == "Snapshot" {
+			got = append(got, res.Type+" "+res.ID)
+		}
+	}
+	want := []string{"Snapshot snap-0own1", "AMI ami-0own", "Snapshot snap-0own2", "Snapshot snap-0gone"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected the AMI created from the instance and its snapshots, got %v", got)
+	}
+	if tags := b.Tags("us-east-1", "ami-0own"); tags["Name"] != "builder" {
+		t.Errorf("ami-0own: expected the instance name, got %v", tags)
+	}
+	if tags := b.Tags("us-east-1", "snap-0copy"); len(tags) != 0 {
+		t.Errorf("snap-0copy: the copy must not be tagged after the source instance, got %v", tags)
+	}
+
+	// Orphans are named after the AMI they back
+	b = seedAMIs()
+	opts = tagging.DefaultOptions()
+	opts.Regions = []string{"us-east-1"}
+	opts.FixOrphans = true
+	report, _ = runText(t, opts, b)
+
+	names := map[string]string{}
+	for _, res := range report.Resources() {
+		if len(res.PlannedTags) == 1 {
+			names[res.ID] = res.PlannedTags[0].Value
+		}
+	}
+	wantNames := map[string]string{
+		"snap-0own1": "golden-image",
+		"snap-0own2": "golden-image",
+		"snap-0copy": "golden-copy",
+		"snap-0pack": "packer-base",
+		"snap-0gone": "AMI-Snapshot-snap-0gone",
+	}
+	if !reflect.DeepEqual(names, wantNames) {
+		t.Errorf("unexpected orphan names %v", names)
+	}
+}
@@ -0,0 +1,240 @@
+package tagging
+
+import (
+	"context"
+	"regexp"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+// staleMachineKeys returns the keys that may be machine keys an instance
+// got under an earlier Name: keys in normalized form, other than its current
+// machine key, holding a value the tool writes for machine keys. Name,
+// propagated and policy keys are never stale.
+func (e *Engine) staleMachineKeys(tags map[string]string, region, machineKey, instanceID string) []string {
+	stale := []string{}
+	for _, key := range sortedKeys(tags) {
+		if key == machineKey || key == "Name" || normalizeKey(key) != key || e.managedKey(key) {
+			continue
+		}
+		if e.isMachineKeyValue(tags[key], region, key, instanceID) {
+			stale = append(stale, key)
+		}
+	}
+	return stale
+}
+
+// journalWrites are the keys apply journals recorded the tool adding to a
+// resource, and the Name values they recorded it writing
+type journalWrites struct {
+	keys  map[string]bool
+	names []string
+}
+
+// loadJournalWrites indexes the writes of apply journals by account,
+// region and resource ID
+func loadJournalWrites(paths []string) (map[string]*journalWrites, error) {
+	writes := make(map[string]*journalWrites)
+	for _, path := range paths {
+		entries, err := ReadJournal(path)
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			if entry.Removed {
+				continue
+			}
+			ref := entry.Account + "|" + entry.Region + "|" + entry.ID
+			w, ok := writes[ref]
+			if !ok {
+				w = &journalWrites{keys: make(map[string]bool)}
+				writes[ref] = w
+			}
+			if entry.Key == "Name" {
+				w.names = append(w.names, entry.Value)
+			} else if entry.Previous == nil {
+				w.keys[entry.Key] = true
+			}
+		}
+	}
+	return writes, nil
+}
+
+// splitStaleKeys separates the stale machine keys of a resource that the
+// journals of FromJournals show the tool wrote, on the resource or on the
+// instance given with it, from the keys that are only possibly stale. A key
+// is written by the tool when a journal recorded it being added, or it is
+// the machine key of a Name the tool wrote. Possibly stale keys may be the
+// user's own empty tags, and are never removed; with MigrateUnjournaledKeys
+// every key is stale.
+func (e *Engine) splitStaleKeys(rr *RegionReport, keys []string, ids ...string) (stale, possible []string) {
+	for _, key := range keys {
+		if e.opts.MigrateUnjournaledKeys || e.wroteKey(rr.Account, rr.Region, key, ids) {
+			stale = append(stale, key)
+		} else {
+			possible = append(possible, key)
+		}
+	}
+	return stale, possible
+}
+
+func (e *Engine) wroteKey(account, region, key string, ids []string) bool {
+	for _, id := range ids {
+		w := e.written[account+"|"+region+"|"+id]
+		if w == nil {
+			continue
+		}
+		if w.keys[key] {
+			return true
+		}
+		for _, name := range w.names {
+			if normalizeKey(name) == key {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// managedKey reports whether key is propagated or part of the tag policy
+func (e *Engine) managedKey(key string) bool {
+	if contains(e.opts.PropagateKeys, key) {
+		return true
+	}
+	if e.policy != nil {
+		for _, rule := range e.policy.Tags {
+			if rule.Key == key {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// isMachineKeyValue reports whether value is one the tool writes for the
+// machine key of id: empty, the default, or the configured template with
+// any name, since the name it was written with may have changed since
+func (e *Engine) isMachineKeyValue(value, region, key, id string) bool {
+	if value == "" {
+		return true
+	}
+	template := e.opts.MachineKeyValue
+	if template == "" {
+		return false
+	}
+
+	var pattern strings.Builder
+	pattern.WriteString("^")
+	last := 0
+	for _, loc := range machineKeyPlaceholder.FindAllStringIndex(template, -1) {
+		pattern.WriteString(regexp.QuoteMeta(template[last:loc[0]]))
+		switch template[loc[0]:loc[1]] {
+		case "{id}":
+			pattern.WriteString(regexp.QuoteMeta(id))
+		case "{key}":
+			pattern.WriteString(regexp.QuoteMeta(key))
+		case "{region}":
+			pattern.WriteString(regexp.QuoteMeta(region))
+		default:
+			pattern.WriteString(".*")
+		}
+		last = loc[1]
+	}
+	pattern.WriteString(regexp.QuoteMeta(template[last:]))
+	pattern.WriteString("$")
+	return regexp.MustCompile(pattern.String()).MatchString(value)
+}
+
+// staleOwner is the instance a stale machine key belonged to
+type staleOwner struct {
+	id, name, machineKey string
+}
+
+// tagDetachedSnapshots looks for the stale machine keys of the region's
+// instances on the snapshots no instance was matched with, such as those of
+// deleted volumes. With MigrateStaleKeys, those holding keys the tool wrote
+// get the current machine key of the instance the stale one belonged to. A
+// key left stale on several instances cannot be attributed and is skipped.
+func (e *Engine) tagDetachedSnapshots(ctx context.Context, client EC2API, rr *RegionReport, instances []types.Instance) {
+	owners := make(map[string]*staleOwner)
+	ambiguous := make(map[string]bool)
+	for _, instance := range instances {
+		if instance.State != nil && instance.State.Name == types.InstanceStateNameTerminated {
+			continue
+		}
+		owner := &staleOwner{id: aws.ToString(instance.InstanceId), name: e.getNameTag(instance), machineKey: e.getMachineKey(instance)}
+		if owner.name == "" {
+			owner.name = owner.id
+		}
+		for _, key := range e.staleMachineKeys(ec2TagMap(instance.Tags), rr.Region, owner.machineKey, owner.id) {
+			if _, seen := owners[key]; seen {
+				ambiguous[key] = true
+			}
+			owners[key] = owner
+		}
+	}
+	for key := range ambiguous {
+		delete(owners, key)
+	}
+	if len(owners) == 0 {
+		return
+	}
+
+	processed := make(map[string]bool)
+	for _, res := range rr.Resources {
+		if res.Type == "Snapshot" {
+			processed[res.ID] = true
+		}
+	}
+
+	for _, snapshot := range e.snapshotIndex(ctx, client, rr).snapshots {
+		snapshotID := aws.ToString(snapshot.SnapshotId)
+		if processed[snapshotID] {
+			continue
+		}
+
+		// Keys of a single instance are migrated, the first one found
+		tags := ec2TagMap(snapshot.Tags)
+		var owner *staleOwner
+		stale := []string{}
+		for _, key := range sortedKeys(tags) {
+			o, ok := owners[key]
+			if !ok || (owner != nil && o != owner) || !e.isMachineKeyValue(tags[key], rr.Region, key, o.id) {
+				continue
+			}
+			owner = o
+			stale = append(stale, key)
+		}
+		if owner == nil {
+			continue
+		}
+
+		res := rr.add("Snapshot", snapshotID, tags)
+		res.StaleKeys, res.PossiblyStaleKeys = e.splitStaleKeys(rr, stale, snapshotID, owner.id)
+		if !e.opts.MigrateStaleKeys || len(res.StaleKeys) == 0 {
+			continue
+		}
+		tagsToAdd := []types.Tag{}
+		if value, ok := e.machineKeyTag(tags, rr.Region, owner.machineKey, owner.name, owner.id); ok {
+			tagsToAdd = append(tagsToAdd, types.Tag{Key: aws.String(owner.machineKey), Value: aws.String(value)})
+		}
+		e.planOrApply(ctx, client, res, tagsToAdd)
+	}
+}
+
+// migrateStaleKeys removes the stale machine keys found in rr. The current
+// machine keys are written first, and a resource that failed to get its
+// current key keeps the stale one, so that its costs stay attributed.
+func (e *Engine) migrateStaleKeys(ctx context.Context, rr *RegionReport) {
+	if !e.opts.MigrateStaleKeys {
+		return
+	}
+	e.flushTags(ctx, rr.Region)
+	for _, res := range rr.Resources {
+		if len(res.StaleKeys) > 0 && res.Error == "" {
+			e.removeOrPlan(ctx, res, res.StaleKeys, res.ExistingTags)
+		}
+	}
+}